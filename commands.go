@@ -0,0 +1,533 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/PrakharSrivastav/migrater/config"
+	"github.com/PrakharSrivastav/migrater/migrate"
+	"github.com/spf13/viper"
+)
+
+// exit codes returned by the commands
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+//go:embed config.template.yaml
+var configTemplate []byte
+
+// command is a subcommand of the cli
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{name: "run", summary: "Migrate the data from the source to the target", run: runCommand},
+		{name: "validate", summary: "Validate the configuration and check the source and target are reachable", run: validateCommand},
+		{name: "plan", summary: "Print what a migration will do without writing anything", run: planCommand},
+		{name: "preview", summary: "Print the first records of the source as they would be migrated", run: previewCommand},
+		{name: "inspect", summary: "Print the columns of the source", run: inspectCommand},
+		{name: "diff", summary: "Compare the source tables with the target tables", run: diffCommand},
+		{name: "init", summary: "Write a commented configuration file to start from", run: initCommand},
+		{name: "version", summary: "Print the version", run: versionCommand},
+	}
+}
+
+// execute runs the subcommand named by the first argument. Without a subcommand the arguments
+// are passed on to run, so `migrater -configPath .` keeps working.
+func execute(args []string) int {
+	if len(args) == 0 {
+		usage(os.Stderr)
+		return exitUsage
+	}
+	name := args[0]
+	switch name {
+	case "help", "-h", "-help", "--help":
+		usage(os.Stdout)
+		return exitOK
+	}
+	if strings.HasPrefix(name, "-") {
+		return runCommand(args)
+	}
+	for _, c := range commands {
+		if c.name == name {
+			return c.run(args[1:])
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Unknown command (%s)\n\n", name)
+	usage(os.Stderr)
+	return exitUsage
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: migrater <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run 'migrater <command> -h' for the flags of a command.")
+}
+
+// newFlagSet creates the flag set for a command with a consistent help text
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		out := flags.Output()
+		for _, c := range commands {
+			if c.name == name {
+				fmt.Fprintf(out, "Usage: migrater %s [flags]\n\n%s\n\nFlags:\n", name, c.summary)
+			}
+		}
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parse parses the flags and returns the exit code to stop with, or -1 to carry on
+func parse(flags *flag.FlagSet, args []string) int {
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintf(flags.Output(), "Unexpected arguments %v\n", flags.Args())
+		flags.Usage()
+		return exitUsage
+	}
+	return -1
+}
+
+// configOptions are the flags shared by the commands reading a configuration
+type configOptions struct {
+	path  string
+	files configFiles
+	flags *flag.FlagSet
+	// progress receives what is printed along the way, stderr for the commands whose stdout is
+	// meant to be parsed or piped: a json plan, records in the target format or a ddl script
+	progress io.Writer
+}
+
+// configFlags adds the flags shared by the commands reading a configuration
+func configFlags(flags *flag.FlagSet) *configOptions {
+	opts := &configOptions{flags: flags, progress: os.Stdout}
+	flags.StringVar(&opts.path, "configPath", "", "Directory holding config.yaml (deprecated, use -config)")
+	flags.Var(&opts.files, "config", "Config file (yaml , json , toml), repeat to merge an overlay over a base file")
+	registerConfigFlags(flags)
+	return opts
+}
+
+// loadConfig loads the jobs from the config files when given, otherwise from the flags
+func loadConfig(opts *configOptions) ([]job, bool) {
+	var err error
+	var jobs []job
+	switch {
+	case len(opts.files) > 0:
+		if jobs, err = loadFromConfigFiles(opts.files, opts.flags, opts.progress); err != nil {
+			fmt.Fprintf(opts.progress, "Error loding configurations from config file [%s]\n", err.Error())
+			return nil, false
+		}
+	case strings.TrimSpace(opts.path) != "":
+		fmt.Fprintln(opts.progress, "Loading from config path")
+		if jobs, err = loadFromConfigPath(opts.path, opts.flags, opts.progress); err != nil {
+			fmt.Fprintf(opts.progress, "Error loding configurations from config file [%s]\n", err.Error())
+			return nil, false
+		}
+	default:
+		if jobs, err = loadJobs(opts.flags, opts.progress); err != nil {
+			fmt.Fprintf(opts.progress, "Error loding configurations from flags [%s]\n", err.Error())
+			return nil, false
+		}
+	}
+	return jobs, true
+}
+
+// selectJob picks the job named by -job, which may be left out when there is a single job
+func selectJob(jobs []job, name string, progress io.Writer) (*job, bool) {
+	if name == "" {
+		if len(jobs) == 1 {
+			return &jobs[0], true
+		}
+		fmt.Fprintln(progress, "Please select one of the jobs with -job")
+		return nil, false
+	}
+	for i := range jobs {
+		if jobs[i].name == name {
+			return &jobs[i], true
+		}
+	}
+	fmt.Fprintf(progress, "Unknown job (%s)\n", name)
+	return nil, false
+}
+
+func runCommand(args []string) int {
+	flags := newFlagSet("run")
+	opts := configFlags(flags)
+	dryRun := flags.Bool("dry-run", false, "Print the migration plan without writing anything")
+	planFormat := flags.String("plan-format", "text", "Format of the dry run plan (text , json)")
+	exactCount := flags.Bool("exact-count", false, "Count the source rows of the dry run plan instead of estimating them")
+	concurrency := flags.Int("concurrency", 0, "Number of jobs to run at the same time (default from the config, else 1)")
+	if code := parse(flags, args); code >= 0 {
+		return code
+	}
+	if *dryRun {
+		return plan(opts, *planFormat, *exactCount)
+	}
+
+	jobs, ok := loadConfig(opts)
+	if !ok {
+		return exitFailure
+	}
+	if len(jobs) == 1 && jobs[0].name == defaultJob {
+		if _, err := runJob(jobs[0]); err != nil {
+			fmt.Println(err)
+			return exitFailure
+		}
+		return exitOK
+	}
+
+	limit := *concurrency
+	if limit <= 0 {
+		limit = viper.GetInt("concurrency")
+	}
+	var migrations []migrate.Job
+	for _, j := range jobs {
+		j := j
+		migrations = append(migrations, migrate.Job{
+			Name:      j.name,
+			DependsOn: j.dependsOn,
+			Run:       func() (migrate.Summary, error) { return runJob(j) },
+		})
+	}
+	results, err := migrate.RunJobs(migrations, limit)
+	if err != nil {
+		fmt.Println(err)
+		return exitFailure
+	}
+	migrate.WriteJobResults(os.Stdout, results)
+	for _, r := range results {
+		if r.Err != nil {
+			return exitFailure
+		}
+	}
+	return exitOK
+}
+
+// runJob migrates a single job and returns its summary
+func runJob(j job) (migrate.Summary, error) {
+	migrater, err := newMigrater(j.source, j.target, runMode, os.Stdout)
+	if err != nil {
+		return migrate.Summary{}, err
+	}
+	if err = migrater.Migrate(); err != nil {
+		return migrater.Summary, fmt.Errorf("Error migrating [%v]", err)
+	}
+	return migrater.Summary, nil
+}
+
+func planCommand(args []string) int {
+	flags := newFlagSet("plan")
+	opts := configFlags(flags)
+	format := flags.String("format", "text", "Format of the plan (text , json)")
+	exactCount := flags.Bool("exact-count", false, "Count the source rows instead of estimating them from the table statistics")
+	if code := parse(flags, args); code >= 0 {
+		return code
+	}
+	return plan(opts, *format, *exactCount)
+}
+
+// plan prints the plan of every job, as a list of plans in json when there is more than one. The
+// source rows are counted when exact is set, else estimated.
+func plan(opts *configOptions, format string, exact bool) int {
+	if format != "text" && format != "json" {
+		fmt.Fprintf(os.Stderr, "Invalid plan format (%s)\n", format)
+		return exitUsage
+	}
+	if format == "json" {
+		opts.progress = os.Stderr
+	}
+	jobs, ok := loadConfig(opts)
+	if !ok {
+		return exitFailure
+	}
+
+	var plans []*migrate.Plan
+	for _, j := range jobs {
+		if j.source.ProducedBy != "" {
+			plans = append(plans, &migrate.Plan{
+				Job:           j.name,
+				DependsOn:     j.dependsOn,
+				Source:        fmt.Sprintf("%s file %s", j.source.FileType, j.source.FilePath),
+				Target:        describeTarget(j.target),
+				EstimatedRows: -1,
+				Note:          fmt.Sprintf("the source is written by job %s, run it to plan this job", j.source.ProducedBy),
+			})
+			continue
+		}
+		migrater, err := newMigrater(j.source, j.target, planMode, opts.progress)
+		if err != nil {
+			fmt.Fprintln(opts.progress, err)
+			return exitFailure
+		}
+		migrater.PlanExactCount = exact
+		p, err := migrater.Plan()
+		if err != nil {
+			fmt.Fprintf(opts.progress, "Error planning the migration [%v]\n", err)
+			return exitFailure
+		}
+		p.Job = j.name
+		p.DependsOn = j.dependsOn
+		plans = append(plans, p)
+	}
+
+	var err error
+	switch {
+	case format == "json" && len(plans) == 1:
+		err = plans[0].WriteJSON(os.Stdout)
+	case format == "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(plans)
+	default:
+		for i, p := range plans {
+			if i > 0 {
+				fmt.Println()
+			}
+			if err = p.WriteText(os.Stdout); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(opts.progress, err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+func describeTarget(t *config.Target) string {
+	if t.SourceType == config.FileType {
+		return fmt.Sprintf("%s file %s", t.FileType, t.FilePath)
+	}
+	return "database table " + t.DBTable
+}
+
+func validateCommand(args []string) int {
+	flags := newFlagSet("validate")
+	opts := configFlags(flags)
+	if code := parse(flags, args); code >= 0 {
+		return code
+	}
+	jobs, ok := loadConfig(opts)
+	if !ok {
+		return exitFailure
+	}
+
+	var migrations []migrate.Job
+	for _, j := range jobs {
+		migrations = append(migrations, migrate.Job{Name: j.name, DependsOn: j.dependsOn})
+		if !checkJob(j) {
+			return exitFailure
+		}
+	}
+	// checks the job names and that the dependencies do not form a cycle
+	if err := migrate.ValidateJobs(migrations); err != nil {
+		fmt.Println(err)
+		return exitFailure
+	}
+	fmt.Println("Configuration is valid")
+	return exitOK
+}
+
+// checkJob checks the source and target of the job are reachable
+func checkJob(j job) bool {
+	if j.source.ProducedBy != "" {
+		fmt.Printf("Source of %s is written by job %s\n", j.name, j.source.ProducedBy)
+		return checkTarget(j)
+	}
+	fmt.Printf("Checking the source of %s\n", j.name)
+	sourceFile, sourceDB, err := j.source.Init()
+	if err != nil {
+		fmt.Printf("Error initializing source [%v]\n", err)
+		return false
+	}
+	if sourceFile != nil {
+		sourceFile.Close()
+	}
+	if sourceDB != nil {
+		sourceDB.Close()
+	}
+	return checkTarget(j)
+}
+
+// checkTarget checks the target database of the job is reachable
+func checkTarget(j job) bool {
+	// a target file is not opened so that validating does not create it
+	if j.target.SourceType == config.DBType {
+		fmt.Printf("Checking the target of %s\n", j.name)
+		_, targetDB, err := j.target.Init()
+		if err != nil {
+			fmt.Printf("Error initializing target [%v]\n", err)
+			return false
+		}
+		targetDB.Close()
+	}
+	return true
+}
+
+func previewCommand(args []string) int {
+	flags := newFlagSet("preview")
+	opts := configFlags(flags)
+	jobName := flags.String("job", "", "Job to preview when the config has more than one")
+	n := flags.Int("n", 20, "Number of records to preview")
+	format := flags.String("format", migrate.PreviewTable, "Print the records as a table or in the target format (table , target)")
+	if code := parse(flags, args); code >= 0 {
+		return code
+	}
+	if *format != migrate.PreviewTable && *format != migrate.PreviewTarget {
+		fmt.Fprintf(os.Stderr, "Invalid preview format (%s)\n", *format)
+		return exitUsage
+	}
+	if *format == migrate.PreviewTarget {
+		opts.progress = os.Stderr
+	}
+
+	jobs, ok := loadConfig(opts)
+	if !ok {
+		return exitFailure
+	}
+	j, ok := selectJob(jobs, *jobName, opts.progress)
+	if !ok {
+		return exitUsage
+	}
+	migrater, err := newMigrater(j.source, j.target, previewMode, opts.progress)
+	if err != nil {
+		fmt.Fprintln(opts.progress, err)
+		return exitFailure
+	}
+	if err = migrater.Preview(*n, *format, os.Stdout); err != nil {
+		fmt.Fprintf(opts.progress, "Error previewing the source [%v]\n", err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+func inspectCommand(args []string) int {
+	flags := newFlagSet("inspect")
+	opts := configFlags(flags)
+	jobName := flags.String("job", "", "Job to inspect when the config has more than one")
+	if code := parse(flags, args); code >= 0 {
+		return code
+	}
+	jobs, ok := loadConfig(opts)
+	if !ok {
+		return exitFailure
+	}
+	j, ok := selectJob(jobs, *jobName, opts.progress)
+	if !ok {
+		return exitUsage
+	}
+	migrater, err := newMigrater(j.source, j.target, previewMode, opts.progress)
+	if err != nil {
+		fmt.Println(err)
+		return exitFailure
+	}
+	if err = migrater.Inspect(os.Stdout); err != nil {
+		fmt.Printf("Error inspecting the source [%v]\n", err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+func diffCommand(args []string) int {
+	flags := newFlagSet("diff")
+	opts := configFlags(flags)
+	jobName := flags.String("job", "", "Job to compare when the config has more than one")
+	ddl := flags.Bool("ddl", false, "Print the sql script bringing the target in line with the source instead of the report")
+	if code := parse(flags, args); code >= 0 {
+		return code
+	}
+	if *ddl {
+		opts.progress = os.Stderr
+	}
+	jobs, ok := loadConfig(opts)
+	if !ok {
+		return exitFailure
+	}
+	j, ok := selectJob(jobs, *jobName, opts.progress)
+	if !ok {
+		return exitUsage
+	}
+	migrater, err := newMigrater(j.source, j.target, planMode, opts.progress)
+	if err != nil {
+		fmt.Fprintln(opts.progress, err)
+		return exitFailure
+	}
+	diffs, err := migrater.Diff()
+	if err != nil {
+		fmt.Fprintf(opts.progress, "Error comparing the source and target [%v]\n", err)
+		return exitFailure
+	}
+	if *ddl {
+		err = migrate.WriteDDL(os.Stdout, diffs)
+	} else {
+		err = migrate.WriteDiff(os.Stdout, diffs)
+	}
+	if err != nil {
+		fmt.Fprintln(opts.progress, err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+func initCommand(args []string) int {
+	flags := newFlagSet("init")
+	output := flags.String("o", "config.yaml", "File to write the configuration to, - for stdout")
+	force := flags.Bool("force", false, "Overwrite the file if it exists")
+	if code := parse(flags, args); code >= 0 {
+		return code
+	}
+
+	if *output == "-" {
+		os.Stdout.Write(configTemplate)
+		return exitOK
+	}
+	if _, err := os.Stat(*output); err == nil && !*force {
+		fmt.Printf("%s already exists, use -force to overwrite it\n", *output)
+		return exitFailure
+	}
+	if err := os.WriteFile(*output, configTemplate, 0644); err != nil {
+		fmt.Printf("Error writing the configuration [%v]\n", err)
+		return exitFailure
+	}
+	fmt.Printf("Wrote %s\n", *output)
+	return exitOK
+}
+
+func versionCommand(args []string) int {
+	flags := newFlagSet("version")
+	if code := parse(flags, args); code >= 0 {
+		return code
+	}
+	fmt.Printf("migrater %s\n", version)
+	return exitOK
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// captureStdout runs fn and returns what it printed on stdout
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	done := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(r)
+		done <- b
+	}()
+	fn()
+	w.Close()
+	return string(<-done)
+}
+
+// writeJob writes a csv source and a config copying it to a csv target, returning the config path
+func writeJob(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	source := filepath.Join(dir, "in.csv")
+	if err := os.WriteFile(source, []byte("id,name\n1,a\n2,b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := filepath.Join(dir, "job.yaml")
+	yaml := "source:\n  file: {type: csv, path: " + source + ", seperator: \",\"}\n" +
+		"target:\n  file: {type: csv, path: " + filepath.Join(dir, "out.csv") + ", seperator: \",\"}\n"
+	if err := os.WriteFile(cfg, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestStructuredOutputKeepsStdoutClean(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  []string
+		check func(t *testing.T, out string)
+	}{
+		{
+			name: "plan json",
+			args: []string{"plan", "-format", "json"},
+			check: func(t *testing.T, out string) {
+				var plan map[string]interface{}
+				if err := json.Unmarshal([]byte(out), &plan); err != nil {
+					t.Errorf("stdout is not json (%s): %q", err, out)
+				}
+			},
+		},
+		{
+			name: "dry run json",
+			args: []string{"run", "-dry-run", "-plan-format", "json"},
+			check: func(t *testing.T, out string) {
+				var plan map[string]interface{}
+				if err := json.Unmarshal([]byte(out), &plan); err != nil {
+					t.Errorf("stdout is not json (%s): %q", err, out)
+				}
+			},
+		},
+		{
+			name: "preview target",
+			args: []string{"preview", "-format", "target"},
+			check: func(t *testing.T, out string) {
+				if want := "id,name\n1,a\n2,b\n"; out != want {
+					t.Errorf("stdout %q, want %q", out, want)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		cfg := writeJob(t)
+		var code int
+		out := captureStdout(t, func() {
+			code = execute(append(tt.args, "-config", cfg))
+		})
+		if code != exitOK {
+			t.Errorf("%s: exit code %d, stdout %q", tt.name, code, out)
+			continue
+		}
+		t.Run(tt.name, func(t *testing.T) { tt.check(t, out) })
+	}
+}
+
+func TestExecuteExitCodes(t *testing.T) {
+	existing := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(existing, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"no command", nil, exitUsage},
+		{"unknown command", []string{"migrate"}, exitUsage},
+		{"help", []string{"help"}, exitOK},
+		{"command help", []string{"run", "-h"}, exitOK},
+		{"unknown flag", []string{"run", "-nope"}, exitUsage},
+		{"extra argument", []string{"version", "now"}, exitUsage},
+		{"plan format", []string{"plan", "-format", "xml"}, exitUsage},
+		{"version", []string{"version"}, exitOK},
+		{"init exists", []string{"init", "-o", existing}, exitFailure},
+	}
+	for _, tt := range tests {
+		var code int
+		captureStdout(t, func() { code = execute(tt.args) })
+		if code != tt.code {
+			t.Errorf("%s: exit code %d, want %d", tt.name, code, tt.code)
+		}
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	cfg := writeJob(t)
+	var code int
+	captureStdout(t, func() { code = execute([]string{"run", "-config", cfg}) })
+	if code != exitOK {
+		t.Fatalf("exit code %d", code)
+	}
+	out, err := os.ReadFile(filepath.Join(filepath.Dir(cfg), "out.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "id,name\n1,a\n2,b\n"; string(out) != want {
+		t.Errorf("target %q, want %q", out, want)
+	}
+}
+
+func TestInitCommand(t *testing.T) {
+	out := captureStdout(t, func() { execute([]string{"init", "-o", "-"}) })
+	if out != string(configTemplate) {
+		t.Errorf("init -o - printed %d bytes, want the %d bytes of the template", len(out), len(configTemplate))
+	}
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var code int
+	captureStdout(t, func() { code = execute([]string{"init", "-o", path, "-force"}) })
+	written, _ := os.ReadFile(path)
+	if code != exitOK || string(written) != string(configTemplate) {
+		t.Errorf("init -force: exit code %d, wrote %d bytes", code, len(written))
+	}
+}
+
+func TestRunTwiceReplacesTheTarget(t *testing.T) {
+	for _, fileType := range []string{"csv", "json", "xml", "pgcopy", "pgbinary", "parquet", "avro"} {
+		dir := t.TempDir()
+		source, target, back := filepath.Join(dir, "in.csv"), filepath.Join(dir, "out."+fileType), filepath.Join(dir, "back.csv")
+		write := func(name, content string) string {
+			path := filepath.Join(dir, name)
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			return path
+		}
+		many := "id,name\n"
+		for i := 0; i < 200; i++ {
+			many += "1000,a longer name for a larger file\n"
+		}
+		// the settings a file of the type needs to be written and read back
+		written, read := "", ""
+		switch fileType {
+		case "csv":
+			written, read = ", seperator: \",\"", ", seperator: \",\""
+		case "pgcopy":
+			written, read = ", header: true", ", header: true"
+		case "pgbinary":
+			read = ", columns: [\"id:text\", \"name:text\"]"
+		}
+		file := "{type: " + fileType + ", path: " + target
+		export := write("export.yaml", "source:\n  file: {type: csv, path: "+source+", seperator: \",\"}\n"+
+			"target:\n  file: "+file+written+"}\n")
+		load := write("load.yaml", "source:\n  file: "+file+read+"}\n"+
+			"target:\n  file: {type: csv, path: "+back+", seperator: \",\"}\n")
+
+		var code int
+		for _, rows := range []string{many, "id,name\n1,a\n"} {
+			write("in.csv", rows)
+			// every run of the cli starts from a fresh configuration
+			viper.Reset()
+			out := captureStdout(t, func() { code = execute([]string{"run", "-config", export}) })
+			if code != exitOK {
+				t.Fatalf("%s: export exit code %d: %s", fileType, code, out)
+			}
+		}
+		viper.Reset()
+		out := captureStdout(t, func() { code = execute([]string{"run", "-config", load}) })
+		if code != exitOK {
+			t.Errorf("%s: reading the file written twice failed with exit code %d: %s", fileType, code, out)
+			continue
+		}
+		got, _ := os.ReadFile(back)
+		if want := "id,name\n1,a\n"; string(got) != want {
+			t.Errorf("%s: read back %q, want %q", fileType, got, want)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package config
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PrakharSrivastav/migrater/retry"
+	"github.com/lib/pq"
+)
+
+// Connection holds the settings of a named database connection, defined once under
+// connections and referenced by any number of sources and targets. The same settings of a
+// source or target open their database through it.
+type Connection struct {
+	Name string
+	Type string
+	// URL is a postgres:// url or a keyword dsn, the other settings override its parts
+	URL  string
+	User string
+	Pass string
+	// PassFile and PassCmd provide the password from a mounted secret or a command instead of Pass
+	PassFile string
+	PassCmd  string
+	Host     string
+	Port     string
+	Schema   string
+	// TLS and session settings, sslmode defaults to disable unless the url sets it
+	SSLMode         string
+	SSLRootCert     string
+	SSLCert         string
+	SSLKey          string
+	ConnectTimeout  string
+	ApplicationName string
+	SearchPath      string
+	// pool settings, empty leaves the database/sql defaults
+	MaxOpenConns    string
+	MaxIdleConns    string
+	ConnMaxLifetime string
+	ConnMaxIdleTime string
+	// Retries is the number of retries of a connect or batch failing with a transient error,
+	// RetryBackoff the wait before the first retry which doubles after every attempt
+	Retries      string
+	RetryBackoff string
+}
+
+// defaultRetries and defaultBackoff apply when retries and retry_backoff are not set
+const (
+	defaultRetries = 3
+	defaultBackoff = time.Second
+)
+
+// sslModes are the sslmode values supported by the driver
+var sslModes = []string{"disable", "require", "verify-ca", "verify-full"}
+
+// Validate checks the settings needed to connect are present. Settings missing from the split
+// fields may come from the url.
+func (c Connection) Validate() error {
+	if c.URL == "" {
+		if c.User == "" {
+			return errors.New("Please provide database user")
+		}
+		if c.Host == "" {
+			return errors.New("Please provide database host")
+		}
+		if c.Port == "" {
+			return errors.New("Please provide database port")
+		}
+		if c.Schema == "" {
+			return errors.New("Please provide database schema")
+		}
+		if c.Pass == "" {
+			return errors.New("Please provide database password")
+		}
+	}
+	if c.SSLMode != "" && !contains(sslModes, c.SSLMode) {
+		return fmt.Errorf("Please provide sslmode as one of %s", strings.Join(sslModes, ", "))
+	}
+	if (c.SSLCert == "") != (c.SSLKey == "") {
+		return errors.New("Please provide both sslcert and sslkey for a client certificate")
+	}
+	for _, file := range []string{c.SSLRootCert, c.SSLCert, c.SSLKey} {
+		if file == "" {
+			continue
+		}
+		if _, err := os.Stat(file); err != nil {
+			return fmt.Errorf("Invalid certificate file (%s)", err)
+		}
+	}
+	for _, kv := range []struct{ key, value string }{
+		{"max_open_conns", c.MaxOpenConns},
+		{"max_idle_conns", c.MaxIdleConns},
+		{"retries", c.Retries},
+	} {
+		if n, err := strconv.Atoi(kv.value); kv.value != "" && (err != nil || n < 0) {
+			return fmt.Errorf("Please provide %s as a positive number", kv.key)
+		}
+	}
+	for _, kv := range []struct{ key, value string }{
+		{"conn_max_lifetime", c.ConnMaxLifetime},
+		{"conn_max_idle_time", c.ConnMaxIdleTime},
+		{"retry_backoff", c.RetryBackoff},
+	} {
+		if d, err := time.ParseDuration(kv.value); kv.value != "" && (err != nil || d < 0) {
+			return fmt.Errorf("Please provide %s as a duration such as 30s or 5m", kv.key)
+		}
+	}
+	if c.ConnectTimeout != "" {
+		if seconds, err := strconv.Atoi(c.ConnectTimeout); err != nil || seconds < 0 {
+			return errors.New("Please provide connect_timeout in seconds")
+		}
+	}
+	return nil
+}
+
+// DSN builds the keyword connection string, the split settings are appended after the url so
+// they win over its parts
+func (c Connection) DSN() (string, error) {
+	var parts []string
+	base := c.URL
+	if strings.HasPrefix(base, "postgres://") || strings.HasPrefix(base, "postgresql://") {
+		var err error
+		if base, err = pq.ParseURL(base); err != nil {
+			return "", fmt.Errorf("Invalid database url (%s)", err)
+		}
+	}
+	if base != "" {
+		parts = append(parts, base)
+	}
+
+	sslMode := c.SSLMode
+	if sslMode == "" && !strings.Contains(base, "sslmode=") {
+		sslMode = "disable"
+	}
+	for _, kv := range []struct{ key, value string }{
+		{"host", c.Host},
+		{"port", c.Port},
+		{"user", c.User},
+		{"password", c.Pass},
+		{"dbname", c.Schema},
+		{"sslmode", sslMode},
+		{"sslrootcert", c.SSLRootCert},
+		{"sslcert", c.SSLCert},
+		{"sslkey", c.SSLKey},
+		{"connect_timeout", c.ConnectTimeout},
+		{"application_name", c.ApplicationName},
+		{"search_path", c.SearchPath},
+	} {
+		if kv.value != "" {
+			parts = append(parts, kv.key+"="+quoteDSN(kv.value))
+		}
+	}
+	return strings.Join(parts, " "), nil
+}
+
+// quoteDSN quotes a keyword value when it is empty or contains spaces, quotes or backslashes
+func quoteDSN(value string) string {
+	if value != "" && !strings.ContainsAny(value, ` '\`) {
+		return value
+	}
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `'`, `\'`, -1)
+	return "'" + value + "'"
+}
+
+// RetryPolicy returns the retries of the connection, the settings are checked by Validate
+func (c Connection) RetryPolicy() retry.Policy {
+	policy := retry.Policy{Retries: defaultRetries, Backoff: defaultBackoff}
+	if c.Retries != "" {
+		policy.Retries, _ = strconv.Atoi(c.Retries)
+	}
+	if c.RetryBackoff != "" {
+		policy.Backoff, _ = time.ParseDuration(c.RetryBackoff)
+	}
+	return policy
+}
+
+// Open connects to the database, retrying a connection failing with a transient error, and
+// applies the pool settings
+func (c Connection) Open() (*sql.DB, error) {
+	switch c.Type {
+	case "pgsql":
+		dsn, err := c.DSN()
+		if err != nil {
+			return nil, err
+		}
+		db, err := sql.Open("postgres", dsn)
+		if err != nil {
+			return nil, err
+		}
+		c.configurePool(db)
+		if err = c.RetryPolicy().Do("Connecting", db.Ping); err != nil {
+			db.Close()
+			return nil, err
+		}
+		log.Println("Successfully connected!")
+		return db, nil
+	}
+	return nil, fmt.Errorf("Invalid database type (%s)", c.Type)
+}
+
+func (c Connection) configurePool(db *sql.DB) {
+	if n, err := strconv.Atoi(c.MaxOpenConns); err == nil {
+		db.SetMaxOpenConns(n)
+	}
+	if n, err := strconv.Atoi(c.MaxIdleConns); err == nil {
+		db.SetMaxIdleConns(n)
+	}
+	if d, err := time.ParseDuration(c.ConnMaxLifetime); err == nil {
+		db.SetConnMaxLifetime(d)
+	}
+	if d, err := time.ParseDuration(c.ConnMaxIdleTime); err == nil {
+		db.SetConnMaxIdleTime(d)
+	}
+}
+
+// ApplyConnection fills the database settings of the source not set explicitly from the connection
+func (s *Source) ApplyConnection(c Connection) {
+	s.DB = merge(s.DB, c)
+}
+
+// ApplyConnection fills the database settings of the target not set explicitly from the connection
+func (t *Target) ApplyConnection(c Connection) {
+	t.DB = merge(t.DB, c)
+}
+
+// merge fills the settings of own not set explicitly from c
+func merge(own, c Connection) Connection {
+	fill(&own.Type, c.Type)
+	fill(&own.URL, c.URL)
+	fill(&own.User, c.User)
+	// the password of the connection is only used when none is set explicitly
+	if own.Pass == "" && own.PassFile == "" && own.PassCmd == "" {
+		own.Pass, own.PassFile, own.PassCmd = c.Pass, c.PassFile, c.PassCmd
+	}
+	fill(&own.Host, c.Host)
+	fill(&own.Port, c.Port)
+	fill(&own.Schema, c.Schema)
+	fill(&own.SSLMode, c.SSLMode)
+	fill(&own.SSLRootCert, c.SSLRootCert)
+	fill(&own.SSLCert, c.SSLCert)
+	fill(&own.SSLKey, c.SSLKey)
+	fill(&own.ConnectTimeout, c.ConnectTimeout)
+	fill(&own.ApplicationName, c.ApplicationName)
+	fill(&own.SearchPath, c.SearchPath)
+	fill(&own.MaxOpenConns, c.MaxOpenConns)
+	fill(&own.MaxIdleConns, c.MaxIdleConns)
+	fill(&own.ConnMaxLifetime, c.ConnMaxLifetime)
+	fill(&own.ConnMaxIdleTime, c.ConnMaxIdleTime)
+	fill(&own.Retries, c.Retries)
+	fill(&own.RetryBackoff, c.RetryBackoff)
+	return own
+}
+
+func fill(field *string, value string) {
+	if *field == "" {
+		*field = value
+	}
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDSN(t *testing.T) {
+	tests := []struct {
+		name string
+		c    Connection
+		want string
+		err  string
+	}{
+		{
+			name: "split settings",
+			c:    Connection{Host: "db", Port: "5432", User: "app", Pass: "it's s3cret", Schema: "orders"},
+			want: `host=db port=5432 user=app password='it\'s s3cret' dbname=orders sslmode=disable`,
+		},
+		{
+			// the split settings follow the url so they win over its parts
+			name: "url",
+			c:    Connection{URL: "postgres://app:pw@db:5433/orders?sslmode=require", Schema: "reports"},
+			want: "dbname='orders' host='db' password='pw' port='5433' sslmode='require' user='app' dbname=reports",
+		},
+		{
+			name: "keyword dsn",
+			c:    Connection{URL: "host=db user=app", SSLMode: "verify-full", SSLRootCert: "/ca.pem", ApplicationName: "migrater"},
+			want: "host=db user=app sslmode=verify-full sslrootcert=/ca.pem application_name=migrater",
+		},
+		{name: "empty password", c: Connection{Host: "db", Pass: ""}, want: "host=db sslmode=disable"},
+		{name: "bad url", c: Connection{URL: "postgres://db:port/x"}, err: "Invalid database url"},
+	}
+	for _, tt := range tests {
+		got, err := tt.c.DSN()
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: got %q %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestConnectionValidate(t *testing.T) {
+	cert := filepath.Join(t.TempDir(), "client.pem")
+	if err := os.WriteFile(cert, []byte("cert"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	split := Connection{User: "app", Pass: "pw", Host: "db", Port: "5432", Schema: "orders"}
+	with := func(change func(c *Connection)) Connection {
+		c := split
+		change(&c)
+		return c
+	}
+	tests := []struct {
+		name string
+		c    Connection
+		err  string
+	}{
+		{name: "split", c: split},
+		{name: "url only", c: Connection{URL: "postgres://app@db/orders"}},
+		{name: "no host", c: with(func(c *Connection) { c.Host = "" }), err: "Please provide database host"},
+		{name: "sslmode", c: with(func(c *Connection) { c.SSLMode = "prefer" }), err: "Please provide sslmode as one of"},
+		{name: "cert without key", c: with(func(c *Connection) { c.SSLCert = cert }), err: "Please provide both sslcert and sslkey"},
+		{name: "client cert", c: with(func(c *Connection) { c.SSLCert, c.SSLKey = cert, cert })},
+		{name: "missing cert", c: with(func(c *Connection) { c.SSLRootCert = cert + ".none" }), err: "Invalid certificate file"},
+		{name: "pool", c: with(func(c *Connection) { c.MaxOpenConns = "-1" }), err: "Please provide max_open_conns as a positive number"},
+		{name: "lifetime", c: with(func(c *Connection) { c.ConnMaxLifetime = "5" }), err: "Please provide conn_max_lifetime as a duration"},
+		{name: "timeout", c: with(func(c *Connection) { c.ConnectTimeout = "5s" }), err: "Please provide connect_timeout in seconds"},
+	}
+	for _, tt := range tests {
+		err := tt.c.Validate()
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
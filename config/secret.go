@@ -0,0 +1,65 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// resolvePassword returns the password given directly, read from a file or printed by a
+// command. Only one of them may be set. A trailing newline is not part of the password.
+func resolvePassword(pass, file, cmd string) (string, error) {
+	set := 0
+	for _, v := range []string{pass, file, cmd} {
+		if v != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return "", errors.New("Use either pass OR pass_file OR pass_cmd")
+	}
+
+	switch {
+	case file != "":
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("Error reading the password file (%s)", err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	case cmd != "":
+		var stderr bytes.Buffer
+		c := exec.Command("sh", "-c", cmd)
+		c.Stderr = &stderr
+		c.Env = os.Environ()
+		out, err := c.Output()
+		if err != nil {
+			return "", fmt.Errorf("Error running the password command (%s) %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return strings.TrimRight(string(out), "\r\n"), nil
+	}
+	return pass, nil
+}
+
+// ResolvePassword sets the database password of the source from its pass_file or pass_cmd
+func (s *Source) ResolvePassword() error {
+	pass, err := resolvePassword(s.DB.Pass, s.DB.PassFile, s.DB.PassCmd)
+	if err != nil {
+		return fmt.Errorf("Source password: %s", err)
+	}
+	s.DB.Pass = pass
+	return nil
+}
+
+// ResolvePassword sets the database password of the target from its pass_file or pass_cmd
+func (t *Target) ResolvePassword() error {
+	pass, err := resolvePassword(t.DB.Pass, t.DB.PassFile, t.DB.PassCmd)
+	if err != nil {
+		return fmt.Errorf("Target password: %s", err)
+	}
+	t.DB.Pass = pass
+	return nil
+}
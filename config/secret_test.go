@@ -0,0 +1,68 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolvePassword(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "pass")
+	if err := os.WriteFile(file, []byte("from file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name            string
+		pass, file, cmd string
+		want, err       string
+	}{
+		{name: "pass", pass: "secret", want: "secret"},
+		{name: "file", file: file, want: "from file"},
+		{name: "cmd", cmd: "printf 'from cmd\\r\\n'", want: "from cmd"},
+		{name: "none", want: ""},
+		{name: "two", pass: "secret", file: file, err: "Use either pass OR pass_file OR pass_cmd"},
+		{name: "missing file", file: file + ".none", err: "Error reading the password file"},
+		{name: "failing cmd", cmd: "echo denied >&2; exit 1", err: "denied"},
+	}
+	for _, tt := range tests {
+		got, err := resolvePassword(tt.pass, tt.file, tt.cmd)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: got %q %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestApplyConnection(t *testing.T) {
+	c := Connection{Type: "pgsql", User: "shared", Pass: "shared", Host: "db", Port: "5432", Schema: "app", Retries: "5"}
+	tests := []struct {
+		name string
+		own  Connection
+		want Connection
+	}{
+		{
+			name: "fills the unset settings",
+			own:  Connection{Schema: "other"},
+			want: Connection{Type: "pgsql", User: "shared", Pass: "shared", Host: "db", Port: "5432", Schema: "other", Retries: "5"},
+		},
+		{
+			// a password file of its own keeps the shared password out
+			name: "own password",
+			own:  Connection{PassFile: "/run/secrets/pass"},
+			want: Connection{Type: "pgsql", User: "shared", PassFile: "/run/secrets/pass", Host: "db", Port: "5432", Schema: "app", Retries: "5"},
+		},
+	}
+	for _, tt := range tests {
+		s := &Source{DB: tt.own}
+		s.ApplyConnection(c)
+		if s.DB != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, s.DB, tt.want)
+		}
+	}
+}
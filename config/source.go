@@ -4,78 +4,163 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"log"
+	"io"
 	"os"
+	"regexp"
 	"strings"
 
+	"github.com/PrakharSrivastav/migrater/fixedwidth"
 	_ "github.com/lib/pq"
 )
 
+// cellRange matches a range of cells of a sheet
+var cellRange = regexp.MustCompile(`^[A-Za-z]{1,3}[1-9][0-9]*:[A-Za-z]{1,3}[1-9][0-9]*$`)
+
 type Source struct {
 	FileType      string
 	FilePath      string
 	FileSeperator string
-	DBUser        string
-	DBType        string
-	DBSchema      string
-	DBTable       string
-	DBHost        string
-	DBPort        string
-	DBPass        string
-	DBSQL         string
-	SourceType    StoreType
+	DBConnection  string
+	// DB holds the settings to connect to the database
+	DB          Connection
+	DBTable     string
+	DBSQL       string
+	DBInclude   []string
+	DBExclude   []string
+	DBNamespace string
+	// FileColumns names the columns of a pgcopy file without a header or of a pgbinary file, as
+	// name or name:type
+	FileColumns []string
+	// FileHeader is set when the first line of a pgcopy file holds the column names
+	FileHeader bool
+	// FileFormat is the format of a pgcopy file (text , csv)
+	FileFormat string
+	// FileSheet, FileRange and FileHeaderRow select the records of an xlsx file, the sheet by
+	// name or 1-based index, the range as A1:D100
+	FileSheet     string
+	FileRange     string
+	FileHeaderRow int
+	// FileArrays flattens the arrays of an xml or json file (index , explode)
+	FileArrays string
+	// FileRecordPath selects the records of an xml file by xpath or of a json file by jsonpath,
+	// FileFields the columns of a record as name=expression relative to it
+	FileRecordPath string
+	FileFields     []string
+	// FileLayout places the fields of a fixedwidth file
+	FileLayout  fixedwidth.Layout
+	DedupKeys   []string
+	DedupKeep   string
+	DedupMemory int
+	// ProducedBy names the job writing the source file, the file need not exist before that job runs
+	ProducedBy string
+	SourceType StoreType
+	// Progress receives what is printed while validating and opening, stdout by default
+	Progress io.Writer
 }
 
 func (s *Source) Validate() (bool, error) {
-	fmt.Println("Validating source configurations")
+	fmt.Fprintln(s.progress(), "Validating source configurations")
 
 	// exactly one of FileType or DBType should be set
-	if (s.FileType != "" && s.DBType != "") ||
-		(s.FileType == "" && s.DBType == "") {
+	if (s.FileType != "" && s.DB.Type != "") ||
+		(s.FileType == "" && s.DB.Type == "") {
 		return false, errors.New("Use either source.File.type OR source.DB.type")
 	}
 
-	fmt.Println("Setting file type")
+	// dedup keeps either the first or the last record for a key
+	if len(s.DedupKeys) > 0 {
+		switch strings.ToLower(s.DedupKeep) {
+		case "", "first", "last":
+		default:
+			return false, errors.New("Please provide source.dedup.keep as first OR last")
+		}
+		if s.DedupMemory < 0 {
+			return false, errors.New("Please provide a positive source.dedup.memory")
+		}
+	}
+
+	fmt.Fprintln(s.progress(), "Setting file type")
 	if s.FileType != "" {
 		// validate if File seperator is provided for csv Files
 		if strings.ToLower(s.FileType) == "csv" && s.FileSeperator == "" {
 			return false, errors.New("Please provide a seperator for csv File (',' OR ';')")
 		}
 
+		if err := validateCopyFile(s.FileType, s.FileFormat, s.FileHeader, "source"); err != nil {
+			return false, err
+		}
+		switch s.FileArrays {
+		case "":
+		case "index", "explode":
+			if t := strings.ToLower(s.FileType); t != "xml" && t != "json" {
+				return false, errors.New("source.file.arrays is only supported for an xml OR json file")
+			}
+		default:
+			return false, errors.New("Please provide source.file.arrays as index OR explode")
+		}
+		if t := strings.ToLower(s.FileType); s.FileRecordPath != "" && t != "xml" && t != "json" {
+			return false, errors.New("source.file.record_path is only supported for an xml OR json file")
+		}
+		if len(s.FileFields) > 0 && s.FileRecordPath == "" {
+			return false, errors.New("Please provide source.file.record_path for source.file.fields")
+		}
+		if len(s.FileLayout) > 0 && strings.ToLower(s.FileType) != "fixedwidth" {
+			return false, errors.New("source.file.layout is only supported for a fixedwidth file")
+		}
+		switch strings.ToLower(s.FileType) {
+		case "pgcopy":
+			if len(s.FileColumns) == 0 && !s.FileHeader {
+				return false, errors.New("Please provide source.file.columns OR source.file.header for a pgcopy file")
+			}
+		case "fixedwidth":
+			if len(s.FileLayout) == 0 {
+				return false, errors.New("Please provide source.file.layout for a fixedwidth file")
+			}
+		case "pgbinary":
+			if len(s.FileColumns) == 0 {
+				return false, errors.New("Please provide source.file.columns (name:type) for a pgbinary file")
+			}
+		default:
+			if len(s.FileColumns) > 0 {
+				return false, errors.New("source.file.columns is only supported for a pgcopy OR pgbinary file")
+			}
+		}
+
+		if err := s.validateSheet(); err != nil {
+			return false, err
+		}
+
 		// validate File path
 		stat, err := os.Stat(s.FilePath)
 		if s.FilePath == "" || (err == nil && stat.IsDir()) { // should be provided and should not be a directory
 			return false, errors.New("Please provide a valid File path")
 		}
-		if err != nil { // any other File error
+		if err != nil && !(os.IsNotExist(err) && s.ProducedBy != "") { // any other File error
 			return false, err
 		}
 		s.SourceType = FileType
-		fmt.Println("SourceType set to ", FileType)
+		fmt.Fprintln(s.progress(), "SourceType set to ", FileType)
 		return true, nil
 	}
 
-	if s.DBUser == "" {
-		return false, errors.New("Please provide database user")
+	if err := s.DB.Validate(); err != nil {
+		return false, err
 	}
-	if s.DBHost == "" {
-		return false, errors.New("Please provide database host")
-	}
-	if s.DBPort == "" {
-		return false, errors.New("Please provide database port")
-	}
-	if s.DBSchema == "" {
-		return false, errors.New("Please provide database schema")
+	// exactly one of table, sql or include should be set
+	modes := 0
+	for _, set := range []bool{s.DBTable != "", s.DBSQL != "", len(s.DBInclude) > 0} {
+		if set {
+			modes++
+		}
 	}
-	if s.DBPass == "" {
-		return false, errors.New("Please provide database password")
+	if modes != 1 {
+		return false, errors.New("For database, either provide source.DB.table OR source.DB.sql OR source.DB.include")
 	}
-	if (s.DBSQL == "" && s.DBTable == "") ||
-		(s.DBSQL != "" && s.DBTable != "") {
-		return false, errors.New("For database, either provide source.DB.table OR source.table.sql")
+	if len(s.DBExclude) > 0 && len(s.DBInclude) == 0 {
+		return false, errors.New("Please provide source.DB.include along with source.DB.exclude")
 	}
 	s.SourceType = DBType
-	fmt.Println("SourceType set to ", DBType)
+	fmt.Fprintln(s.progress(), "SourceType set to ", DBType)
 
 	return true, nil
 }
@@ -85,33 +170,37 @@ func (s *Source) Init() (*os.File, *sql.DB, error) {
 		f, err := os.Open(s.FilePath)
 		if err != nil {
 			f.Close()
-			fmt.Println("Some error here ", err)
+			fmt.Fprintln(s.progress(), "Some error here ", err)
 			return nil, nil, err
 		}
 		return f, nil, nil
 	}
 
-	switch s.DBType {
-	case "pgsql":
-		psqlInfo := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-			s.DBHost,
-			s.DBPort,
-			s.DBUser,
-			s.DBPass,
-			s.DBSchema,
-		)
-
-		db, err := sql.Open("postgres", psqlInfo)
-		if err != nil {
-			return nil, nil, err
-		}
-		err = db.Ping()
-		if err != nil {
-			return nil, nil, err
-		}
-		log.Println("Successfully connected!", s.SourceType)
-		return nil, db, nil
+	db, err := s.DB.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	return nil, db, nil
+}
+
+// validateSheet checks the sheet, range and header row settings, which only an xlsx file takes
+func (s *Source) validateSheet() error {
+	if (s.FileSheet != "" || s.FileRange != "" || s.FileHeaderRow != 0) && strings.ToLower(s.FileType) != "xlsx" {
+		return errors.New("source.file.sheet, range and header_row are only supported for an xlsx file")
+	}
+	if s.FileRange != "" && !cellRange.MatchString(s.FileRange) {
+		return errors.New("Please provide source.file.range as a cell range (A1:D100)")
+	}
+	if s.FileHeaderRow < 0 {
+		return errors.New("Please provide a positive source.file.header_row")
+	}
+	return nil
+}
 
+// progress returns the writer the progress is printed to
+func (s *Source) progress() io.Writer {
+	if s.Progress == nil {
+		return os.Stdout
 	}
-	return nil, nil, errors.New("Invalid database source")
+	return s.Progress
 }
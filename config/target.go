@@ -4,10 +4,11 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"log"
+	"io"
 	"os"
 	"strings"
 
+	"github.com/PrakharSrivastav/migrater/fixedwidth"
 	_ "github.com/lib/pq"
 )
 
@@ -15,25 +16,130 @@ type Target struct {
 	FileType      string
 	FilePath      string
 	FileSeperator string
-	DBUser        string
-	DBType        string
-	DBSchema      string
-	DBTable       string
-	DBHost        string
-	DBPort        string
-	DBPass        string
-	SourceType    StoreType
+	DBConnection  string
+	// DB holds the settings to connect to the database
+	DB      Connection
+	DBTable string
+	// SchemaCopy is set when the source copies a whole schema, the tables keep their names
+	SchemaCopy bool
+	// Replay is set when the source is a sql dump, its statements name the tables
+	Replay bool
+	// FileTable, FileDialect and FileStatement shape a sql dump target
+	FileTable     string
+	FileDialect   string
+	FileStatement string
+	// FileFormat and FileHeader shape a pgcopy target
+	FileFormat string
+	FileHeader bool
+	// FileCompression and FileRowGroupSize shape a parquet target
+	FileCompression  string
+	FileRowGroupSize int
+	// FileSheet names the sheet of an xlsx target
+	FileSheet string
+	// FileLayout places the fields of a fixedwidth file
+	FileLayout fixedwidth.Layout
+	// Transaction is the commit granularity of a database target (batch , job , staging)
+	Transaction string
+	// SchemaPolicy handles an existing table with other columns than the source (fail , add , widen , ignore)
+	SchemaPolicy string
+	// DeferIndexes builds the keys and indexes copied from a source table after the load
+	DeferIndexes bool
+	// Ledger records the committed batches so a retried batch is not loaded twice
+	Ledger       bool
+	Verify       bool
+	VerifyKeys   []string
+	VerifyReport int
+	SourceType   StoreType
+	// Progress receives what is printed while validating and opening, stdout by default
+	Progress io.Writer
 }
 
 func (t *Target) Validate() (bool, error) {
-	fmt.Println("Validating target configurations")
+	fmt.Fprintln(t.progress(), "Validating target configurations")
 
 	// exactly one of FileType or DBType should be set
-	if (t.FileType != "" && t.DBType != "") ||
-		(t.FileType == "" && t.DBType == "") {
+	if (t.FileType != "" && t.DB.Type != "") ||
+		(t.FileType == "" && t.DB.Type == "") {
 		return false, errors.New("Use either target.File.type OR target.DB.type")
 	}
 
+	if t.SchemaCopy && t.FileType != "" {
+		return false, errors.New("Copying a source schema needs a database target")
+	}
+	if t.Replay && t.FileType != "" {
+		return false, errors.New("A sql dump source is replayed into a database target")
+	}
+	if (t.FileDialect != "" || t.FileStatement != "" || t.FileTable != "") && strings.ToLower(t.FileType) != "sql" {
+		return false, errors.New("target.file.table, dialect and statement are only supported for a sql file")
+	}
+	switch t.FileDialect {
+	case "", "postgres", "mysql":
+	default:
+		return false, errors.New("Please provide target.file.dialect as postgres OR mysql")
+	}
+	switch t.FileStatement {
+	case "", "insert":
+	case "copy":
+		if t.FileDialect == "mysql" {
+			return false, errors.New("COPY statements are only supported for the postgres dialect")
+		}
+	default:
+		return false, errors.New("Please provide target.file.statement as insert OR copy")
+	}
+
+	if err := validateCopyFile(t.FileType, t.FileFormat, t.FileHeader, "target"); err != nil {
+		return false, err
+	}
+
+	if t.FileRowGroupSize != 0 && strings.ToLower(t.FileType) != "parquet" {
+		return false, errors.New("target.file.row_group_size is only supported for a parquet file")
+	}
+	if t.FileRowGroupSize < 0 {
+		return false, errors.New("Please provide a positive target.file.row_group_size")
+	}
+	switch strings.ToLower(t.FileType) {
+	case "parquet":
+		if !contains([]string{"", "snappy", "gzip", "zstd", "lz4", "brotli", "none"}, t.FileCompression) {
+			return false, errors.New("Please provide target.file.compression as snappy OR gzip OR zstd OR lz4 OR brotli OR none")
+		}
+	case "avro":
+		if !contains([]string{"", "deflate", "snappy", "none"}, t.FileCompression) {
+			return false, errors.New("Please provide target.file.compression as deflate OR snappy OR none")
+		}
+	default:
+		if t.FileCompression != "" {
+			return false, errors.New("target.file.compression is only supported for a parquet OR avro file")
+		}
+	}
+
+	if len(t.FileLayout) > 0 && strings.ToLower(t.FileType) != "fixedwidth" {
+		return false, errors.New("target.file.layout is only supported for a fixedwidth file")
+	}
+	if len(t.FileLayout) == 0 && strings.ToLower(t.FileType) == "fixedwidth" {
+		return false, errors.New("Please provide target.file.layout for a fixedwidth file")
+	}
+	if t.FileSheet != "" && strings.ToLower(t.FileType) != "xlsx" {
+		return false, errors.New("target.file.sheet is only supported for an xlsx file")
+	}
+
+	switch t.Transaction {
+	case "", "batch", "job", "staging":
+	default:
+		return false, errors.New("Please provide target.transaction as batch OR job OR staging")
+	}
+	switch t.SchemaPolicy {
+	case "", "fail", "add", "widen", "ignore":
+	default:
+		return false, errors.New("Please provide target.schema_policy as fail OR add OR widen OR ignore")
+	}
+	if (t.Transaction != "" || t.DeferIndexes || t.SchemaPolicy != "") && t.FileType != "" {
+		return false, errors.New("target.transaction, target.defer_indexes and target.schema_policy are only supported for a database target")
+	}
+
+	if t.Verify && t.FileType != "" {
+		return false, errors.New("target.verify is only supported for a database target")
+	}
+
 	if t.FileType != "" {
 		// validate if File seperator is provided for csv Files
 		if strings.ToLower(t.FileType) == "csv" && t.FileSeperator == "" {
@@ -45,77 +151,70 @@ func (t *Target) Validate() (bool, error) {
 		if t.FilePath == "" || (err == nil && stat.IsDir()) { // should be provided and should not be a directory
 			return false, errors.New("Please provide a valid File path")
 		}
-		if err != nil { // any other File error
-			if os.IsNotExist(err) {
-				var fileHandler *os.File
-				if fileHandler, err = os.Create(t.FilePath); err != nil {
-					return false, err
-				}
-				fileHandler.Close()
-				t.SourceType = FileType
-				return true, nil
-			}
+		if err != nil && !os.IsNotExist(err) { // a missing File is created on Init
 			return false, err
 		}
 		t.SourceType = FileType
 		return true, nil
 	}
 
-	if t.DBUser == "" {
-		return false, errors.New("Please provide database user")
-	}
-	if t.DBHost == "" {
-		return false, errors.New("Please provide database host")
+	if err := t.DB.Validate(); err != nil {
+		return false, err
 	}
-	if t.DBPort == "" {
-		return false, errors.New("Please provide database port")
-	}
-	if t.DBSchema == "" {
-		return false, errors.New("Please provide database schema")
+	if t.DBTable == "" && !t.SchemaCopy && !t.Replay {
+		return false, errors.New("Please provide target table ")
 	}
-	if t.DBPass == "" {
-		return false, errors.New("Please provide database password")
+	if t.DBTable != "" && t.Replay {
+		return false, errors.New("The tables of a replayed sql dump keep their names, leave target.DB.table empty")
 	}
-	if t.DBTable == "" {
-		return false, errors.New("Please provide target table ")
+	if t.DBTable != "" && t.SchemaCopy {
+		return false, errors.New("The tables of a copied schema keep their names, leave target.DB.table empty")
 	}
 	t.SourceType = DBType
 	return true, nil
 }
 
 func (s *Target) Init() (*os.File, *sql.DB, error) {
-	fmt.Println(s.SourceType)
+	fmt.Fprintln(s.progress(), s.SourceType)
 	if s.SourceType == FileType {
-		f, err := os.OpenFile(s.FilePath, os.O_RDWR, 777)
+		// a file written by an earlier run is replaced, the xlsx writer adds its sheet to the
+		// workbook on disk instead
+		flag := os.O_RDWR | os.O_CREATE | os.O_TRUNC
+		if strings.EqualFold(s.FileType, "xlsx") {
+			flag = os.O_RDWR | os.O_CREATE
+		}
+		f, err := os.OpenFile(s.FilePath, flag, 0666)
 		if err != nil {
 			f.Close()
-			fmt.Println("Error while target init", err.Error())
+			fmt.Fprintln(s.progress(), "Error while target init", err.Error())
 			return nil, nil, err
 		}
 		return f, nil, nil
 	}
 
-	switch s.DBType {
-	case "pgsql":
-		psqlInfo := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-			s.DBHost,
-			s.DBPort,
-			s.DBUser,
-			s.DBPass,
-			s.DBSchema,
-		)
+	db, err := s.DB.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	return nil, db, nil
+}
 
-		db, err := sql.Open("postgres", psqlInfo)
-		if err != nil {
-			return nil, nil, err
-		}
-		err = db.Ping()
-		if err != nil {
-			return nil, nil, err
-		}
-		log.Println("Successfully connected!")
-		return nil, db, nil
+// validateCopyFile checks the format and header settings, which only a pgcopy file takes
+func validateCopyFile(fileType, format string, header bool, side string) error {
+	if (format != "" || header) && strings.ToLower(fileType) != "pgcopy" {
+		return fmt.Errorf("%s.file.format and header are only supported for a pgcopy file", side)
+	}
+	switch format {
+	case "", "text", "csv":
+		return nil
+	}
+	return fmt.Errorf("Please provide %s.file.format as text OR csv", side)
+}
 
+// progress returns the writer the progress is printed to
+func (t *Target) progress() io.Writer {
+	if t.Progress == nil {
+		return os.Stdout
 	}
-	return nil, nil, errors.New("Invalid database source")
+	return t.Progress
 }
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// includeKey lists config files merged in before the file declaring it
+const includeKey = "include"
+
+// configFiles collects the repeatable -config flag
+type configFiles []string
+
+func (c *configFiles) String() string { return strings.Join(*c, ",") }
+
+func (c *configFiles) Set(path string) error {
+	*c = append(*c, path)
+	return nil
+}
+
+// readConfigFiles merges the config files in order, a later file overrides the keys of the
+// earlier ones. The format is taken from the extension (yaml , yml , json , toml).
+func readConfigFiles(paths []string, progress io.Writer) error {
+	visiting := map[string]bool{}
+	for _, path := range paths {
+		if err := mergeConfigFile(path, visiting, progress); err != nil {
+			return err
+		}
+	}
+	return checkConfigKeys()
+}
+
+// mergeConfigFile merges the files listed under include first, so the file itself wins over them
+func mergeConfigFile(path string, visiting map[string]bool, progress io.Writer) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if visiting[abs] {
+		return fmt.Errorf("Config file %s includes itself", path)
+	}
+	visiting[abs] = true
+	defer delete(visiting, abs)
+
+	fmt.Fprintln(progress, "Reading config file", path)
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err = v.ReadInConfig(); err != nil {
+		return fmt.Errorf("Error reading config file %s (%s)", path, err)
+	}
+	settings := v.AllSettings()
+	if err = interpolate(settings); err != nil {
+		return fmt.Errorf("Config file %s: %s", path, err)
+	}
+	for _, include := range stringList(settings[includeKey]) {
+		// includes are relative to the including file
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(path), include)
+		}
+		if err = mergeConfigFile(include, visiting, progress); err != nil {
+			return err
+		}
+	}
+	delete(settings, includeKey)
+	return viper.MergeConfigMap(settings)
+}
+
+// interpolateConfig replaces the environment variables in the configuration read by viper itself
+func interpolateConfig() error {
+	settings := viper.AllSettings()
+	if err := interpolate(settings); err != nil {
+		return fmt.Errorf("Config file %s: %s", viper.ConfigFileUsed(), err)
+	}
+	return viper.MergeConfigMap(settings)
+}
+
+// envPattern matches ${VAR} and ${VAR:-default}, $${ escapes a literal ${
+var envPattern = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// interpolate replaces the environment variables in every string value of the settings. A
+// variable which is not set is an error unless it has a default.
+func interpolate(settings map[string]interface{}) error {
+	for key, value := range settings {
+		expanded, err := interpolateValue(value)
+		if err != nil {
+			return err
+		}
+		settings[key] = expanded
+	}
+	return nil
+}
+
+func interpolateValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return expandEnv(v)
+	case map[string]interface{}:
+		return v, interpolate(v)
+	case []interface{}:
+		for i := range v {
+			item, err := interpolateValue(v[i])
+			if err != nil {
+				return nil, err
+			}
+			v[i] = item
+		}
+		return v, nil
+	}
+	return value, nil
+}
+
+func expandEnv(s string) (string, error) {
+	var missing []string
+	expanded := envPattern.ReplaceAllStringFunc(s, func(match string) string {
+		if match == "$${" {
+			return "${"
+		}
+		parts := envPattern.FindStringSubmatch(match)
+		if value, ok := os.LookupEnv(parts[1]); ok {
+			return value
+		}
+		if parts[2] != "" {
+			return parts[3]
+		}
+		missing = append(missing, parts[1])
+		return match
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s is not set", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+// stringList returns the strings of a list value, or the value itself when it is a single string
+func stringList(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var items []string
+		for _, item := range v {
+			items = append(items, fmt.Sprint(item))
+		}
+		return items
+	}
+	return nil
+}
+
+// connectionKeys are the settings of a named connection under connections.<name>
+var connectionKeys = []string{"type", "url", "user", "pass", "pass_file", "pass_cmd", "host", "port", "schema",
+	"sslmode", "sslrootcert", "sslcert", "sslkey", "connect_timeout", "application_name", "search_path",
+	"max_open_conns", "max_idle_conns", "conn_max_lifetime", "conn_max_idle_time", "retries", "retry_backoff"}
+
+// checkConfigKeys reports the keys of the loaded configuration which are not known to the migrater
+func checkConfigKeys() error {
+	return checkKeys(viper.GetViper(), isTopKey)
+}
+
+// isTopKey allows the keys of a single job next to the jobs list and the named connections
+func isTopKey(key string) bool {
+	switch key {
+	case includeKey, "jobs", "concurrency":
+		return true
+	}
+	if parts := strings.Split(key, "."); len(parts) == 3 && parts[0] == "connections" {
+		for _, k := range connectionKeys {
+			if parts[2] == k {
+				return true
+			}
+		}
+		return false
+	}
+	return isConfigKey(key)
+}
+
+// isJobKey allows the keys of an entry in the jobs list
+func isJobKey(key string) bool {
+	return key == "name" || key == "depends_on" || isConfigKey(key)
+}
+
+func isConfigKey(key string) bool {
+	for _, c := range configKeys {
+		if c.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// checkKeys reports the keys in v which are not allowed, with the closest known key as a
+// suggestion for a misspelling
+func checkKeys(v *viper.Viper, allowed func(string) bool) error {
+	var unknown []string
+	for _, key := range v.AllKeys() {
+		if allowed(key) {
+			continue
+		}
+		msg := fmt.Sprintf("unknown key %q", key)
+		if suggestion := closestKey(key); suggestion != "" {
+			msg += fmt.Sprintf(" (did you mean %q?)", suggestion)
+		}
+		unknown = append(unknown, msg)
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	return fmt.Errorf("Invalid configuration: %s", strings.Join(unknown, ", "))
+}
+
+// closestKey returns the known key within a small edit distance of key
+func closestKey(key string) string {
+	best, bestDistance := "", 4
+	for _, c := range configKeys {
+		if d := editDistance(key, c.key); d < bestDistance {
+			best, bestDistance = c.key, d
+		}
+	}
+	return best
+}
+
+// editDistance is the levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
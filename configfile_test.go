@@ -0,0 +1,141 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// writeFiles writes the files into a temporary directory and returns it
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestReadConfigFiles(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"base.yaml":    "source:\n  db:\n    table: base\n    schema: public\n",
+		"overlay.json": `{"source": {"db": {"table": "overlay"}}}`,
+		"job.toml":     "include = [\"base.yaml\"]\n[target.db]\ntable = \"toml\"\n",
+		"self.yaml":    "include: self.yaml\n",
+		"typo.yaml":    "source:\n  db:\n    tabel: t\n",
+	})
+	tests := []struct {
+		name  string
+		files []string
+		want  map[string]string
+		err   string
+	}{
+		{
+			name:  "overlay wins",
+			files: []string{"base.yaml", "overlay.json"},
+			want:  map[string]string{"source.db.table": "overlay", "source.db.schema": "public"},
+		},
+		{
+			name:  "include",
+			files: []string{"job.toml"},
+			want:  map[string]string{"source.db.table": "base", "target.db.table": "toml"},
+		},
+		{name: "cycle", files: []string{"self.yaml"}, err: "includes itself"},
+		{name: "missing", files: []string{"none.yaml"}, err: "Error reading config file"},
+		{name: "unknown key", files: []string{"typo.yaml"}, err: `unknown key "source.db.tabel" (did you mean "source.db.table"?)`},
+	}
+	for _, tt := range tests {
+		viper.Reset()
+		var paths []string
+		for _, f := range tt.files {
+			paths = append(paths, filepath.Join(dir, f))
+		}
+		var err error
+		captureStdout(t, func() { err = readConfigFiles(paths, os.Stdout) })
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		for key, want := range tt.want {
+			if got := viper.GetString(key); got != want {
+				t.Errorf("%s: %s = %q, want %q", tt.name, key, got, want)
+			}
+		}
+		if viper.IsSet(includeKey) {
+			t.Errorf("%s: the include key is kept", tt.name)
+		}
+	}
+	viper.Reset()
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "abc", 3},
+		{"table", "table", 0},
+		{"tabel", "table", 2},
+		{"kitten", "sitting", 3},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+	if got := closestKey("completely.unrelated.key"); got != "" {
+		t.Errorf("closestKey suggested %q", got)
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("MIGRATER_TEST_HOST", "db.local")
+	tests := []struct {
+		in, want, err string
+	}{
+		{in: "host=${MIGRATER_TEST_HOST}", want: "host=db.local"},
+		{in: "${MIGRATER_TEST_UNSET:-localhost}", want: "localhost"},
+		{in: "${MIGRATER_TEST_UNSET:-}", want: ""},
+		{in: "$${MIGRATER_TEST_HOST}", want: "${MIGRATER_TEST_HOST}"},
+		{in: "$HOME stays", want: "$HOME stays"},
+		{in: "${MIGRATER_TEST_UNSET}", err: "environment variable MIGRATER_TEST_UNSET is not set"},
+	}
+	for _, tt := range tests {
+		got, err := expandEnv(tt.in)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: got error %v, want %q", tt.in, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: got %q %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+
+	// nested maps and lists are interpolated in place
+	settings := map[string]interface{}{
+		"source": map[string]interface{}{"db": map[string]interface{}{"host": "${MIGRATER_TEST_HOST}"}},
+		"keys":   []interface{}{"${MIGRATER_TEST_UNSET:-id}", 1},
+	}
+	if err := interpolate(settings); err != nil {
+		t.Fatal(err)
+	}
+	if host := settings["source"].(map[string]interface{})["db"].(map[string]interface{})["host"]; host != "db.local" {
+		t.Errorf("host %v", host)
+	}
+	if keys := settings["keys"].([]interface{}); keys[0] != "id" || keys[1] != 1 {
+		t.Errorf("keys %v", keys)
+	}
+}
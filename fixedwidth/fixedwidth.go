@@ -0,0 +1,276 @@
+// Package fixedwidth slices the lines of a fixed width file into fields and pads fields into lines
+package fixedwidth
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Alignments of a value in its field
+const (
+	AlignLeft  = "left"
+	AlignRight = "right"
+)
+
+// numericTypes are the types aligned to the right by default, their values are never cut
+var numericTypes = map[string]bool{
+	"smallint": true, "integer": true, "int": true, "int2": true, "int4": true, "int8": true, "bigint": true,
+	"numeric": true, "decimal": true, "real": true, "float4": true, "float8": true, "double precision": true,
+}
+
+// Field is a column of a fixed width file. Start is the 1-based position of its first character
+// and Length the number of characters it spans.
+type Field struct {
+	Name   string
+	Start  int
+	Length int
+	// Type is the postgres type of the values, text by default
+	Type string
+	// Pad fills the field beside the value, Align places the value in the field
+	Pad   rune
+	Align string
+}
+
+// Layout is the fields of a line
+type Layout []Field
+
+// Parse reads a layout from the configuration, a list of fields given as a map with name, start,
+// length, type, pad and align or as name:length[:type]. A field without a start follows the
+// previous one, numeric fields are aligned to the right and all fields are padded with spaces
+// by default.
+func Parse(value interface{}) (Layout, error) {
+	var items []interface{}
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case []string:
+		for _, item := range v {
+			items = append(items, item)
+		}
+	case []interface{}:
+		items = v
+	default:
+		return nil, fmt.Errorf("Layout should be a list of fields, not %T", value)
+	}
+
+	var layout Layout
+	end := 0
+	for i, item := range items {
+		f, err := parseField(item)
+		if err != nil {
+			return nil, fmt.Errorf("Field %d: %s", i+1, err)
+		}
+		if f.Start == 0 {
+			f.Start = end + 1
+		}
+		if f.Start+f.Length-1 > end {
+			end = f.Start + f.Length - 1
+		}
+		layout = append(layout, f)
+	}
+	return layout, layout.validate()
+}
+
+func parseField(item interface{}) (Field, error) {
+	f := Field{Type: "text", Pad: ' '}
+	switch v := item.(type) {
+	case string:
+		parts := strings.Split(v, ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			return f, fmt.Errorf("Please provide the field %q as name:length OR name:length:type", v)
+		}
+		f.Name = strings.TrimSpace(parts[0])
+		length, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return f, fmt.Errorf("Invalid length of field %s (%s)", f.Name, parts[1])
+		}
+		f.Length = length
+		if len(parts) == 3 {
+			f.Type = strings.ToLower(strings.TrimSpace(parts[2]))
+		}
+	case map[string]interface{}:
+		for key, val := range v {
+			s := strings.TrimSpace(fmt.Sprint(val))
+			var err error
+			switch strings.ToLower(key) {
+			case "name":
+				f.Name = s
+			case "start":
+				f.Start, err = strconv.Atoi(s)
+			case "length":
+				f.Length, err = strconv.Atoi(s)
+			case "type":
+				f.Type = strings.ToLower(s)
+			case "pad":
+				// the value is not trimmed, a space is a valid pad
+				pad := fmt.Sprint(val)
+				if utf8.RuneCountInString(pad) != 1 {
+					return f, errors.New("Please provide pad as a single character")
+				}
+				f.Pad, _ = utf8.DecodeRuneInString(pad)
+			case "align":
+				f.Align = strings.ToLower(s)
+			default:
+				return f, fmt.Errorf("Unknown setting %s (name , start , length , type , pad , align)", key)
+			}
+			if err != nil {
+				return f, fmt.Errorf("Invalid %s (%s)", key, s)
+			}
+		}
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			m[fmt.Sprint(key)] = val
+		}
+		return parseField(m)
+	default:
+		return f, fmt.Errorf("Unsupported field %v", item)
+	}
+	if f.Type == "" {
+		f.Type = "text"
+	}
+	if f.Align == "" {
+		f.Align = AlignLeft
+		if f.Numeric() {
+			f.Align = AlignRight
+		}
+	}
+	return f, nil
+}
+
+// validate checks the names, positions and alignments of the fields, the fields may not overlap
+func (l Layout) validate() error {
+	names := map[string]bool{}
+	for _, f := range l {
+		if f.Name == "" {
+			return errors.New("Please provide a name for every field")
+		}
+		if names[f.Name] {
+			return fmt.Errorf("Field %s appears twice", f.Name)
+		}
+		names[f.Name] = true
+		if f.Start < 1 || f.Length < 1 {
+			return fmt.Errorf("Please provide a positive start and length for field %s", f.Name)
+		}
+		if f.Align != AlignLeft && f.Align != AlignRight {
+			return fmt.Errorf("Please provide the align of field %s as left OR right", f.Name)
+		}
+	}
+	sorted := append(Layout(nil), l...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+	for i := 1; i < len(sorted); i++ {
+		if prev := sorted[i-1]; prev.Start+prev.Length > sorted[i].Start {
+			return fmt.Errorf("Fields %s and %s overlap", prev.Name, sorted[i].Name)
+		}
+	}
+	return nil
+}
+
+// Names returns the names of the fields in the order of the layout
+func (l Layout) Names() []string {
+	names := make([]string, len(l))
+	for i, f := range l {
+		names[i] = f.Name
+	}
+	return names
+}
+
+// Width is the number of characters of a line
+func (l Layout) Width() int {
+	width := 0
+	for _, f := range l {
+		if end := f.Start + f.Length - 1; end > width {
+			width = end
+		}
+	}
+	return width
+}
+
+// Numeric tells if the field holds numbers
+func (f Field) Numeric() bool {
+	// numeric(10,2) is a numeric
+	t := f.Type
+	if i := strings.Index(t, "("); i >= 0 {
+		t = t[:i]
+	}
+	return numericTypes[strings.Join(strings.Fields(t), " ")]
+}
+
+// Split slices a line into the values of the fields with the padding removed. A field left
+// blank, or missing from a short line, is returned as ok false.
+func (l Layout) Split(line string) ([]string, []bool) {
+	runes := []rune(line)
+	values := make([]string, len(l))
+	ok := make([]bool, len(l))
+	for i, f := range l {
+		from, to := f.Start-1, f.Start-1+f.Length
+		if from >= len(runes) {
+			continue
+		}
+		if to > len(runes) {
+			to = len(runes)
+		}
+		raw := string(runes[from:to])
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+		values[i], ok[i] = f.trim(raw), true
+	}
+	return values, ok
+}
+
+// trim removes the padding of a value. A number padded with zeros keeps its sign in front and
+// a zero before the decimal point.
+func (f Field) trim(raw string) string {
+	pad := string(f.Pad)
+	if f.Align != AlignRight {
+		return strings.TrimRight(raw, pad)
+	}
+	if f.Pad != '0' {
+		return strings.TrimLeft(raw, pad)
+	}
+	sign := ""
+	if strings.HasPrefix(raw, "-") {
+		sign, raw = "-", raw[1:]
+	}
+	v := strings.TrimLeft(raw, pad)
+	if v == "" || v[0] == '.' {
+		v = "0" + v
+	}
+	return sign + v
+}
+
+// Join pads the values into a line, a nil value or an empty number leaves its field blank. A
+// value longer than its field is cut, except for a number which is an error.
+func (l Layout) Join(values []*string) (string, error) {
+	line := []rune(strings.Repeat(" ", l.Width()))
+	for i, f := range l {
+		// zeros would read back as 0
+		if values[i] == nil || (*values[i] == "" && f.Numeric()) {
+			continue
+		}
+		v := []rune(*values[i])
+		if len(v) > f.Length {
+			if f.Numeric() {
+				return "", fmt.Errorf("Value %s does not fit the %d characters of field %s", *values[i], f.Length, f.Name)
+			}
+			v = v[:f.Length]
+		}
+		padding := []rune(strings.Repeat(string(f.Pad), f.Length-len(v)))
+		switch {
+		case f.Align == AlignRight && f.Pad == '0' && len(v) > 0 && v[0] == '-':
+			// the sign comes before the zeros
+			v = append(append([]rune{'-'}, padding...), v[1:]...)
+		case f.Align == AlignRight:
+			v = append(padding, v...)
+		default:
+			v = append(v, padding...)
+		}
+		copy(line[f.Start-1:], v)
+	}
+	return string(line), nil
+}
@@ -0,0 +1,159 @@
+package fixedwidth
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		want    Layout
+		wantErr string
+	}{
+		{name: "nil", value: nil},
+		{
+			name:  "short form",
+			value: []string{"id:5:integer", "name:10"},
+			want: Layout{
+				{Name: "id", Start: 1, Length: 5, Type: "integer", Pad: ' ', Align: AlignRight},
+				{Name: "name", Start: 6, Length: 10, Type: "text", Pad: ' ', Align: AlignLeft},
+			},
+		},
+		{
+			name: "maps",
+			value: []interface{}{
+				map[string]interface{}{"name": "account", "start": 3, "length": 4},
+				map[interface{}]interface{}{"name": "balance", "length": "6", "type": "Numeric(10,2)", "pad": "0"},
+				map[string]interface{}{"name": "note", "start": 1, "length": 2, "pad": " ", "align": "right"},
+			},
+			want: Layout{
+				{Name: "account", Start: 3, Length: 4, Type: "text", Pad: ' ', Align: AlignLeft},
+				{Name: "balance", Start: 7, Length: 6, Type: "numeric(10,2)", Pad: '0', Align: AlignRight},
+				{Name: "note", Start: 1, Length: 2, Type: "text", Pad: ' ', Align: AlignRight},
+			},
+		},
+		{name: "not a list", value: "id:5", wantErr: "should be a list"},
+		{name: "short form parts", value: []string{"id"}, wantErr: "name:length"},
+		{name: "length", value: []string{"id:x"}, wantErr: "Invalid length"},
+		{name: "zero length", value: []string{"id:0"}, wantErr: "positive start and length"},
+		{name: "twice", value: []string{"id:1", "id:2"}, wantErr: "appears twice"},
+		{name: "no name", value: []string{":3"}, wantErr: "name for every field"},
+		{name: "overlap", value: []interface{}{"a:5", map[string]interface{}{"name": "b", "start": 3, "length": 2}},
+			wantErr: "Fields a and b overlap"},
+		{name: "pad", value: []interface{}{map[string]interface{}{"name": "a", "length": 1, "pad": "ab"}},
+			wantErr: "single character"},
+		{name: "align", value: []interface{}{map[string]interface{}{"name": "a", "length": 1, "align": "center"}},
+			wantErr: "left OR right"},
+		{name: "unknown", value: []interface{}{map[string]interface{}{"name": "a", "length": 1, "width": 2}},
+			wantErr: "Unknown setting width"},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.value)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: got %v, want an error containing %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v %v, want %+v", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	layout := Layout{
+		{Name: "id", Start: 1, Length: 4, Type: "integer", Pad: ' ', Align: AlignRight},
+		{Name: "name", Start: 5, Length: 6, Type: "text", Pad: ' ', Align: AlignLeft},
+		{Name: "amount", Start: 11, Length: 7, Type: "numeric", Pad: '0', Align: AlignRight},
+		{Name: "code", Start: 18, Length: 3, Type: "text", Pad: '*', Align: AlignLeft},
+	}
+	tests := []struct {
+		line string
+		want []string
+		ok   []bool
+	}{
+		{"   1ab    0001250x**", []string{"1", "ab", "1250", "x"}, []bool{true, true, true, true}},
+		{"  12čé    -000012ab*", []string{"12", "čé", "-12", "ab"}, []bool{true, true, true, true}},
+		{"   3      0000.50", []string{"3", "", "0.50", ""}, []bool{true, false, true, false}},
+		{"  -4x     -0000.5", []string{"-4", "x", "-0.5", ""}, []bool{true, true, true, false}},
+		// a short line leaves the fields past its end blank
+		{"   5ab", []string{"5", "ab", "", ""}, []bool{true, true, false, false}},
+		{"    ", []string{"", "", "", ""}, []bool{false, false, false, false}},
+	}
+	for _, tt := range tests {
+		values, ok := layout.Split(tt.line)
+		if !reflect.DeepEqual(values, tt.want) || !reflect.DeepEqual(ok, tt.ok) {
+			t.Errorf("Split(%q) = %q %v, want %q %v", tt.line, values, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestJoin(t *testing.T) {
+	layout := Layout{
+		{Name: "id", Start: 1, Length: 4, Type: "integer", Pad: ' ', Align: AlignRight},
+		{Name: "name", Start: 5, Length: 6, Type: "text", Pad: ' ', Align: AlignLeft},
+		{Name: "amount", Start: 11, Length: 7, Type: "numeric", Pad: '0', Align: AlignRight},
+		// a gap between the fields is left blank
+		{Name: "code", Start: 20, Length: 3, Type: "text", Pad: '*', Align: AlignLeft},
+	}
+	str := func(s string) *string { return &s }
+	tests := []struct {
+		values  []*string
+		want    string
+		wantErr string
+	}{
+		{[]*string{str("1"), str("ab"), str("12.5"), str("x")}, "   1ab    00012.5  x**", ""},
+		{[]*string{str("-42"), str("čéřžýáí"), str("-1.25"), nil}, " -42čéřžýá-001.25     ", ""},
+		{[]*string{nil, nil, nil, nil}, "                      ", ""},
+		// an empty number is blank rather than zeros
+		{[]*string{str(""), str(""), str(""), str("")}, "                   ***", ""},
+		{[]*string{str("12345"), str("a"), str("1"), nil}, "", "Value 12345 does not fit the 4 characters of field id"},
+		{[]*string{str("1"), str("a"), str("-1234567"), nil}, "", "field amount"},
+	}
+	for _, tt := range tests {
+		got, err := layout.Join(tt.values)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Join: got %v, want an error containing %q", err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Join = %q %v, want %q", got, err, tt.want)
+			continue
+		}
+		// the line splits back into the values, the cut ones as cut
+		values, ok := layout.Split(got)
+		for i, v := range tt.values {
+			if v == nil {
+				if ok[i] {
+					t.Errorf("%q: field %s is %q, want blank", got, layout[i].Name, values[i])
+				}
+				continue
+			}
+			want := []rune(*v)
+			if len(want) > layout[i].Length {
+				want = want[:layout[i].Length]
+			}
+			if values[i] != string(want) {
+				t.Errorf("%q: field %s is %q, want %q", got, layout[i].Name, values[i], string(want))
+			}
+		}
+	}
+}
+
+func TestNumeric(t *testing.T) {
+	tests := map[string]bool{
+		"integer": true, "numeric(10,2)": true, "double  precision": true, "decimal(5)": true,
+		"text": false, "date": false, "": false,
+	}
+	for typ, want := range tests {
+		if got := (Field{Type: typ}).Numeric(); got != want {
+			t.Errorf("Numeric(%q) = %v, want %v", typ, got, want)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestConfigKeyNames(t *testing.T) {
+	tests := []struct {
+		key, flag, env string
+	}{
+		{"source.db.table", "source-db-table", "MIGRATER_SOURCE_DB_TABLE"},
+		{"target.file.row_group_size", "target-file-row_group_size", "MIGRATER_TARGET_FILE_ROW_GROUP_SIZE"},
+	}
+	for _, tt := range tests {
+		c := configKey{key: tt.key}
+		if c.flagName() != tt.flag || c.envName() != tt.env {
+			t.Errorf("%s: flag %s env %s, want %s and %s", tt.key, c.flagName(), c.envName(), tt.flag, tt.env)
+		}
+	}
+	seen := map[string]bool{}
+	for _, c := range configKeys {
+		if seen[c.key] {
+			t.Errorf("key %s is registered twice", c.key)
+		}
+		seen[c.key] = true
+	}
+}
+
+func TestApplyOverrides(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		key  string
+		want interface{}
+	}{
+		{name: "config", key: "source.db.table", want: "from_config"},
+		{name: "flag", args: []string{"-source-db-table", "from_flag"}, key: "source.db.table", want: "from_flag"},
+		{
+			name: "env wins",
+			args: []string{"-source-db-table", "from_flag"},
+			env:  map[string]string{"MIGRATER_SOURCE_DB_TABLE": "from_env"},
+			key:  "source.db.table",
+			want: "from_env",
+		},
+		{name: "list", args: []string{"-source-dedup-keys", " id, ,name "}, key: "source.dedup.keys", want: []string{"id", "name"}},
+		{name: "empty flag", args: []string{"-source-db-table", ""}, key: "source.db.table", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			flags := flag.NewFlagSet("test", flag.ContinueOnError)
+			registerConfigFlags(flags)
+			if err := flags.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			v := viper.New()
+			v.Set("source.db.table", "from_config")
+			applyOverrides(v, flags)
+			if got := v.Get(tt.key); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s = %#v, want %#v", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadJobsFromFlags(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	dir := t.TempDir()
+	source := filepath.Join(dir, "in.csv")
+	if err := os.WriteFile(source, []byte("id\n1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MIGRATER_TARGET_FILE_PATH", filepath.Join(dir, "out.xml"))
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	registerConfigFlags(flags)
+	err := flags.Parse([]string{
+		"-source-file-type", "csv", "-source-file-path", source, "-source-file-seperator", ",",
+		"-source-dedup-keys", "id", "-target-file-type", "xml",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs, err := loadJobs(flags, os.Stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].name != defaultJob {
+		t.Fatalf("got jobs %+v, want the default job", jobs)
+	}
+	j := jobs[0]
+	if j.source.FileType != "csv" || j.source.FilePath != source || !reflect.DeepEqual(j.source.DedupKeys, []string{"id"}) {
+		t.Errorf("source %+v", j.source)
+	}
+	if j.target.FileType != "xml" || j.target.FilePath != filepath.Join(dir, "out.xml") {
+		t.Errorf("target %+v", j.target)
+	}
+}
@@ -2,60 +2,64 @@ package main
 
 import (
 	"bufio"
+	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"io"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/PrakharSrivastav/sql-query-builder/qb"
 	"github.com/PrakharSrivastav/sql-query-builder/qb/core"
 
 	"github.com/PrakharSrivastav/migrater/config"
+	"github.com/PrakharSrivastav/migrater/fixedwidth"
 	"github.com/PrakharSrivastav/migrater/migrate"
 	"github.com/spf13/viper"
 )
 
+// initMode decides which side of the migration gets initialized
+type initMode int
+
+const (
+	// runMode initializes both the source and the target
+	runMode initMode = iota
+	// planMode leaves a target file untouched, a target database is still connected
+	planMode
+	// previewMode only initializes the source
+	previewMode
+)
+
 func main() {
+	os.Exit(execute(os.Args[1:]))
+}
+
+// newMigrater initializes the source and target and wires them into a Migrater printing its
+// progress to progress. Outside of runMode the target file is not opened so nothing gets
+// created or truncated.
+func newMigrater(source *config.Source, target *config.Target, mode initMode, progress io.Writer) (*migrate.Migrater, error) {
 	var err error
-	var source *config.Source
-	var target *config.Target
-	// Read all configurations
-	configPath := flag.String("configPath", "", "Path for the configuration file")
-	flag.Parse()
-
-	switch strings.TrimSpace(*configPath) {
-	case "":
-		if source, target, err = loadFromFlags(); err != nil {
-			panic(fmt.Sprintf("Error loding configurations from flags (%s)", err.Error()))
-		}
-	default:
-		var err error
-		fmt.Println("Loading from config path")
-		if source, target, err = loadFromConfigPath(*configPath); err != nil {
-			fmt.Printf("Error loding configurations from config file [%s]\n", err.Error())
-			os.Exit(1)
-		}
-	}
-	fmt.Println("Init source and target")
+	var targetFile *os.File
+	var targetDB *sql.DB
+	fmt.Fprintln(progress, "Init source and target")
 
 	// initialize source
 	sourceFile, sourceDB, err := source.Init()
 	if err != nil {
-		fmt.Printf("Error initializing source [%v]\n", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("Error initializing source [%v]", err)
 	}
 
 	// initialize target
-	targetFile, targetDB, err := target.Init()
-	if err != nil {
-		fmt.Printf("Error initializing target [%v]\n", err)
-		os.Exit(1)
+	if mode == runMode || (mode == planMode && target.SourceType == config.DBType) {
+		if targetFile, targetDB, err = target.Init(); err != nil {
+			return nil, fmt.Errorf("Error initializing target [%v]", err)
+		}
 	}
 	builder, err := qb.NewQueryBuilder(core.ANSI)
 	if err != nil {
-		fmt.Printf("Error creating a query builder[%v]\n", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("Error creating a query builder[%v]", err)
 	}
 
 	migrater := new(migrate.Migrater)
@@ -64,11 +68,49 @@ func main() {
 	migrater.SourceTable = source.DBTable
 	migrater.SourceSQL = source.DBSQL
 	migrater.SourceFileType = source.FileType
+	migrater.SourceFilePath = source.FilePath
+	migrater.SourceInclude = source.DBInclude
+	migrater.SourceExclude = source.DBExclude
+	migrater.SourceNamespace = source.DBNamespace
+	migrater.SourceFileColumns = source.FileColumns
+	migrater.SourceFileHeader = source.FileHeader
+	migrater.SourceFileFormat = source.FileFormat
+	migrater.SourceFileSheet = source.FileSheet
+	migrater.SourceFileRange = source.FileRange
+	migrater.SourceFileHeaderRow = source.FileHeaderRow
+	migrater.SourceFileLayout = source.FileLayout
+	migrater.SourceFileArrays = source.FileArrays
+	migrater.SourceFileRecordPath = source.FileRecordPath
+	migrater.SourceFileFields = source.FileFields
 	migrater.TargetDB = targetDB
 	migrater.TargetTable = target.DBTable
+	migrater.TargetRetry = target.DB.RetryPolicy()
+	migrater.TargetLedger = target.Ledger
+	migrater.TargetTransaction = target.Transaction
+	migrater.TargetDeferIndexes = target.DeferIndexes
+	migrater.TargetSchemaPolicy = target.SchemaPolicy
 	migrater.TargetFile = bufio.NewWriter(targetFile)
 	migrater.TargetFileType = target.FileType
+	migrater.TargetFileFormat = target.FileFormat
+	migrater.TargetFileHeader = target.FileHeader
+	migrater.TargetFileCompression = target.FileCompression
+	migrater.TargetFileRowGroupSize = target.FileRowGroupSize
+	migrater.TargetFileSheet = target.FileSheet
+	migrater.TargetFileLayout = target.FileLayout
+	if strings.EqualFold(target.FileType, "sql") {
+		migrater.TargetTable = dumpTable(source, target)
+		migrater.TargetFileDialect = target.FileDialect
+		migrater.TargetFileStatement = target.FileStatement
+	}
+	migrater.TargetFilePath = target.FilePath
 	migrater.QB = builder
+	migrater.DedupKeys = source.DedupKeys
+	migrater.DedupKeep = source.DedupKeep
+	migrater.DedupMemory = source.DedupMemory
+	migrater.Verify = target.Verify
+	migrater.VerifyKeys = target.VerifyKeys
+	migrater.VerifyMaxReport = target.VerifyReport
+	migrater.Progress = progress
 
 	if source.SourceType == config.FileType && target.SourceType == config.FileType {
 		migrater.Type = migrate.FileToFile
@@ -85,65 +127,315 @@ func main() {
 	if source.SourceType == config.DBType && target.SourceType == config.FileType {
 		migrater.Type = migrate.DBToFile
 	}
+	return migrater, nil
+}
 
-	migrater.Migrate()
+// job is one source and target pair read from the configuration
+type job struct {
+	name      string
+	dependsOn []string
+	source    *config.Source
+	target    *config.Target
 }
 
-func loadFromConfigPath(configPath string) (*config.Source, *config.Target, error) {
-	fmt.Println("Loading from the configuraion path")
+// defaultJob names the job of a configuration without a jobs list
+const defaultJob = "migration"
+
+// loadOptions carries what the jobs of a configuration share while they are loaded
+type loadOptions struct {
+	connections map[string]config.Connection
+	// produced maps the target files to the job writing them, job is the one being loaded
+	produced map[string]string
+	job      string
+	progress io.Writer
+}
+
+// loadFromConfigPath reads config.yaml in the configPath directory, flags and environment
+// variables override the values read from the file
+func loadFromConfigPath(configPath string, flags *flag.FlagSet, progress io.Writer) ([]job, error) {
+	fmt.Fprintln(progress, "Loading from the configuraion path")
 
 	var err error
+	// a file is read as if it was passed with -config
+	if stat, err := os.Stat(configPath); err == nil && !stat.IsDir() {
+		return loadFromConfigFiles([]string{configPath}, flags, progress)
+	}
+
 	// Load configuration files
 	viper.SetConfigName("config")
 	viper.SetConfigType("yaml")
 	viper.AddConfigPath(configPath)
 	if err = viper.ReadInConfig(); err != nil {
-		log.Panicf("Error reading configurations (%s)\n", err.Error())
-		return nil, nil, err
+		return nil, fmt.Errorf("Error reading configurations (%s)", err.Error())
+	}
+	if err = interpolateConfig(); err != nil {
+		return nil, err
+	}
+	if err = checkConfigKeys(); err != nil {
+		return nil, err
+	}
+	return loadJobs(flags, progress)
+}
+
+// loadFromConfigFiles merges the config files in order, flags and environment variables
+// override the values read from the files
+func loadFromConfigFiles(paths []string, flags *flag.FlagSet, progress io.Writer) ([]job, error) {
+	if err := readConfigFiles(paths, progress); err != nil {
+		return nil, err
+	}
+	return loadJobs(flags, progress)
+}
+
+// loadJobs builds the jobs from the loaded configuration. Without a jobs list the source and
+// target at the top level make up a single job.
+func loadJobs(flags *flag.FlagSet, progress io.Writer) ([]job, error) {
+	connections, err := loadConnections()
+	if err != nil {
+		return nil, err
+	}
+	opts := &loadOptions{connections: connections, produced: map[string]string{}, job: defaultJob, progress: progress}
+	if !viper.IsSet("jobs") {
+		source, target, err := loadFromFlags(viper.GetViper(), flags, opts)
+		if err != nil {
+			return nil, err
+		}
+		return []job{{name: defaultJob, source: source, target: target}}, nil
+	}
+	if viper.IsSet("source") || viper.IsSet("target") {
+		return nil, errors.New("Use either jobs OR source and target at the top level")
+	}
+
+	var items []map[string]interface{}
+	if err = viper.UnmarshalKey("jobs", &items); err != nil {
+		return nil, fmt.Errorf("Error reading jobs (%s)", err)
+	}
+	vipers := make([]*viper.Viper, len(items))
+	names := make([]string, len(items))
+	for i, item := range items {
+		v := viper.New()
+		if err = v.MergeConfigMap(item); err != nil {
+			return nil, err
+		}
+		names[i] = strings.TrimSpace(v.GetString("name"))
+		if names[i] == "" {
+			names[i] = fmt.Sprintf("job%d", i+1)
+		}
+		if err = checkKeys(v, isJobKey); err != nil {
+			return nil, fmt.Errorf("Job %s: %s", names[i], err)
+		}
+		if path := strings.TrimSpace(v.GetString("target.file.path")); path != "" {
+			opts.produced[filepath.Clean(path)] = names[i]
+		}
+		vipers[i] = v
+	}
+
+	var jobs []job
+	// workbooks maps the xlsx target files to the last job adding a sheet to them
+	workbooks := map[string]string{}
+	for i, v := range vipers {
+		fmt.Fprintln(progress, "Loading job", names[i])
+		opts.job = names[i]
+		source, target, err := loadFromFlags(v, flags, opts)
+		if err != nil {
+			return nil, fmt.Errorf("Job %s: %s", names[i], err)
+		}
+		j := job{name: names[i], dependsOn: v.GetStringSlice("depends_on"), source: source, target: target}
+		// reading the file written by another job waits for that job
+		if source.ProducedBy != "" && !contains(j.dependsOn, source.ProducedBy) {
+			j.dependsOn = append(j.dependsOn, source.ProducedBy)
+		}
+		// the jobs writing the same workbook take turns, each adding its sheet
+		if strings.EqualFold(target.FileType, "xlsx") {
+			path := filepath.Clean(target.FilePath)
+			if by, ok := workbooks[path]; ok && !contains(j.dependsOn, by) {
+				j.dependsOn = append(j.dependsOn, by)
+			}
+			workbooks[path] = j.name
+		}
+		jobs = append(jobs, j)
+	}
+	if len(jobs) == 0 {
+		return nil, errors.New("Please provide at least one job")
+	}
+	return jobs, nil
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+// dumpTable is the table of a sql dump target, by default the source table or the name of the
+// source file
+func dumpTable(source *config.Source, target *config.Target) string {
+	switch {
+	case target.FileTable != "":
+		return target.FileTable
+	case source.DBTable != "":
+		return source.DBTable[strings.LastIndex(source.DBTable, ".")+1:]
+	case source.FilePath != "":
+		name := filepath.Base(source.FilePath)
+		return strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	return "data"
+}
+
+// readConnection reads the database settings under prefix, shared by the named connections
+// and the db section of a source or target
+func readConnection(v *viper.Viper, prefix string) config.Connection {
+	get := func(key string) string {
+		return strings.TrimSpace(v.GetString(prefix + key))
+	}
+	return config.Connection{
+		Type:            get("type"),
+		URL:             get("url"),
+		User:            get("user"),
+		Pass:            get("pass"),
+		PassFile:        get("pass_file"),
+		PassCmd:         get("pass_cmd"),
+		Host:            get("host"),
+		Port:            get("port"),
+		Schema:          get("schema"),
+		SSLMode:         strings.ToLower(get("sslmode")),
+		SSLRootCert:     get("sslrootcert"),
+		SSLCert:         get("sslcert"),
+		SSLKey:          get("sslkey"),
+		ConnectTimeout:  get("connect_timeout"),
+		ApplicationName: get("application_name"),
+		SearchPath:      get("search_path"),
+		MaxOpenConns:    get("max_open_conns"),
+		MaxIdleConns:    get("max_idle_conns"),
+		ConnMaxLifetime: get("conn_max_lifetime"),
+		ConnMaxIdleTime: get("conn_max_idle_time"),
+		Retries:         get("retries"),
+		RetryBackoff:    get("retry_backoff"),
+	}
+}
+
+// loadConnections reads the named connections shared by the sources and targets
+func loadConnections() (map[string]config.Connection, error) {
+	connections := map[string]config.Connection{}
+	for name := range viper.GetStringMap("connections") {
+		c := readConnection(viper.GetViper(), "connections."+name+".")
+		c.Name = name
+		connections[name] = c
+	}
+	return connections, nil
+}
+
+// loadFromFlags builds the source and target from v, overridden by the command line flags and
+// the MIGRATER_ environment variables. Database settings not given are taken from the named
+// connection.
+func loadFromFlags(v *viper.Viper, flags *flag.FlagSet, opts *loadOptions) (*config.Source, *config.Target, error) {
+	var err error
+	applyOverrides(v, flags)
+
+	// the fields of a fixedwidth file are a list of settings, they are read before the rest
+	sourceLayout, err := fixedwidth.Parse(v.Get("source.file.layout"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error reading source.file.layout (%s)", err)
+	}
+	targetLayout, err := fixedwidth.Parse(v.Get("target.file.layout"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error reading target.file.layout (%s)", err)
 	}
 
 	// parse and validate source configs
 	source := config.Source{
-		FileType:      strings.TrimSpace(viper.GetString("source.file.type")),
-		FilePath:      strings.TrimSpace(viper.GetString("source.file.path")),
-		FileSeperator: strings.TrimSpace(viper.GetString("source.file.seperator")),
-		DBType:        strings.TrimSpace(viper.GetString("source.db.type")),
-		DBUser:        strings.TrimSpace(viper.GetString("source.db.user")),
-		DBPass:        strings.TrimSpace(viper.GetString("source.db.pass")),
-		DBPort:        strings.TrimSpace(viper.GetString("source.db.port")),
-		DBHost:        strings.TrimSpace(viper.GetString("source.db.host")),
-		DBSchema:      strings.TrimSpace(viper.GetString("source.db.schema")),
-		DBTable:       strings.TrimSpace(viper.GetString("source.db.table")),
-		DBSQL:         strings.TrimSpace(viper.GetString("source.db.sql")),
-	}
-
-	fmt.Println("Validating source")
+		FileType:       strings.TrimSpace(v.GetString("source.file.type")),
+		FilePath:       strings.TrimSpace(v.GetString("source.file.path")),
+		FileSeperator:  strings.TrimSpace(v.GetString("source.file.seperator")),
+		FileColumns:    v.GetStringSlice("source.file.columns"),
+		FileHeader:     v.GetBool("source.file.header"),
+		FileFormat:     strings.ToLower(strings.TrimSpace(v.GetString("source.file.format"))),
+		FileSheet:      strings.TrimSpace(v.GetString("source.file.sheet")),
+		FileRange:      strings.TrimSpace(v.GetString("source.file.range")),
+		FileHeaderRow:  v.GetInt("source.file.header_row"),
+		FileLayout:     sourceLayout,
+		FileArrays:     strings.ToLower(strings.TrimSpace(v.GetString("source.file.arrays"))),
+		FileRecordPath: strings.TrimSpace(v.GetString("source.file.record_path")),
+		FileFields:     v.GetStringSlice("source.file.fields"),
+		DBConnection:   strings.TrimSpace(v.GetString("source.db.connection")),
+		DB:             readConnection(v, "source.db."),
+		DBTable:        strings.TrimSpace(v.GetString("source.db.table")),
+		DBSQL:          strings.TrimSpace(v.GetString("source.db.sql")),
+		DBInclude:      v.GetStringSlice("source.db.include"),
+		DBExclude:      v.GetStringSlice("source.db.exclude"),
+		DBNamespace:    strings.TrimSpace(v.GetString("source.db.namespace")),
+		DedupKeys:      v.GetStringSlice("source.dedup.keys"),
+		DedupKeep:      strings.ToLower(strings.TrimSpace(v.GetString("source.dedup.keep"))),
+		DedupMemory:    v.GetInt("source.dedup.memory"),
+		Progress:       opts.progress,
+	}
+	if by, ok := opts.produced[filepath.Clean(source.FilePath)]; ok && source.FilePath != "" && by != opts.job {
+		source.ProducedBy = by
+	}
+	if source.DBConnection != "" {
+		c, ok := opts.connections[strings.ToLower(source.DBConnection)]
+		if !ok {
+			return nil, nil, fmt.Errorf("Unknown source connection (%s)", source.DBConnection)
+		}
+		source.ApplyConnection(c)
+	}
+	if err = source.ResolvePassword(); err != nil {
+		return nil, nil, err
+	}
+
+	fmt.Fprintln(opts.progress, "Validating source")
 	if _, err = source.Validate(); err != nil {
 		return nil, nil, err
 	}
 
 	// parse and validate target configs
 	target := config.Target{
-		FileType:      strings.TrimSpace(viper.GetString("target.file.type")),
-		FilePath:      strings.TrimSpace(viper.GetString("target.file.path")),
-		FileSeperator: strings.TrimSpace(viper.GetString("target.file.seperator")),
-		DBType:        strings.TrimSpace(viper.GetString("target.db.type")),
-		DBUser:        strings.TrimSpace(viper.GetString("target.db.user")),
-		DBPass:        strings.TrimSpace(viper.GetString("target.db.pass")),
-		DBPort:        strings.TrimSpace(viper.GetString("target.db.port")),
-		DBHost:        strings.TrimSpace(viper.GetString("target.db.host")),
-		DBSchema:      strings.TrimSpace(viper.GetString("target.db.schema")),
-		DBTable:       strings.TrimSpace(viper.GetString("target.db.table")),
-	}
-	fmt.Println("Validating target")
+		FileType:         strings.TrimSpace(v.GetString("target.file.type")),
+		FilePath:         strings.TrimSpace(v.GetString("target.file.path")),
+		FileSeperator:    strings.TrimSpace(v.GetString("target.file.seperator")),
+		DBConnection:     strings.TrimSpace(v.GetString("target.db.connection")),
+		DB:               readConnection(v, "target.db."),
+		DBTable:          strings.TrimSpace(v.GetString("target.db.table")),
+		Verify:           v.GetBool("target.verify.enabled"),
+		VerifyKeys:       v.GetStringSlice("target.verify.keys"),
+		VerifyReport:     v.GetInt("target.verify.report"),
+		SchemaCopy:       len(source.DBInclude) > 0,
+		Replay:           strings.EqualFold(source.FileType, "sql"),
+		FileTable:        strings.TrimSpace(v.GetString("target.file.table")),
+		FileDialect:      strings.ToLower(strings.TrimSpace(v.GetString("target.file.dialect"))),
+		FileStatement:    strings.ToLower(strings.TrimSpace(v.GetString("target.file.statement"))),
+		FileFormat:       strings.ToLower(strings.TrimSpace(v.GetString("target.file.format"))),
+		FileHeader:       v.GetBool("target.file.header"),
+		FileCompression:  strings.ToLower(strings.TrimSpace(v.GetString("target.file.compression"))),
+		FileRowGroupSize: v.GetInt("target.file.row_group_size"),
+		FileSheet:        strings.TrimSpace(v.GetString("target.file.sheet")),
+		FileLayout:       targetLayout,
+		Transaction:      strings.ToLower(strings.TrimSpace(v.GetString("target.transaction"))),
+		DeferIndexes:     v.GetBool("target.defer_indexes"),
+		Ledger:           v.GetBool("target.ledger"),
+		SchemaPolicy:     strings.ToLower(strings.TrimSpace(v.GetString("target.schema_policy"))),
+		Progress:         opts.progress,
+	}
+	// the sheet of an xlsx target is named after its job
+	if target.FileSheet == "" && strings.EqualFold(target.FileType, "xlsx") && opts.job != defaultJob {
+		target.FileSheet = opts.job
+	}
+	if target.DBConnection != "" {
+		c, ok := opts.connections[strings.ToLower(target.DBConnection)]
+		if !ok {
+			return nil, nil, fmt.Errorf("Unknown target connection (%s)", target.DBConnection)
+		}
+		target.ApplyConnection(c)
+	}
+	if err = target.ResolvePassword(); err != nil {
+		return nil, nil, err
+	}
+	fmt.Fprintln(opts.progress, "Validating target")
 
 	if _, err = target.Validate(); err != nil {
 		return nil, nil, err
 	}
 	return &source, &target, err
 }
-
-func loadFromFlags() (*config.Source, *config.Target, error) {
-	var err error
-	return nil, nil, err
-}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestLoadJobsList(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"in.csv": "id\n1\n"})
+	in, mid, out := filepath.Join(dir, "in.csv"), filepath.Join(dir, "mid.csv"), filepath.Join(dir, "out.xml")
+	tests := []struct {
+		name string
+		yaml string
+		deps map[string][]string
+		err  string
+	}{
+		{
+			// the second job reads the file written by the first one
+			name: "produced",
+			yaml: "jobs:\n" +
+				"  - name: extract\n    source: {file: {type: csv, path: " + in + ", seperator: \",\"}}\n" +
+				"    target: {file: {type: csv, path: " + mid + ", seperator: \",\"}}\n" +
+				"  - source: {file: {type: csv, path: " + mid + ", seperator: \",\"}}\n" +
+				"    target: {file: {type: xml, path: " + out + "}}\n",
+			deps: map[string][]string{"extract": nil, "job2": {"extract"}},
+		},
+		{
+			name: "both",
+			yaml: "source: {file: {type: csv}}\njobs:\n  - name: a\n",
+			err:  "Use either jobs OR source and target at the top level",
+		},
+		{
+			name: "unknown key",
+			yaml: "jobs:\n  - name: a\n    sourse: {db: {table: t}}\n",
+			err:  "Job a: Invalid configuration",
+		},
+	}
+	for _, tt := range tests {
+		viper.Reset()
+		viper.SetConfigType("yaml")
+		if err := viper.ReadConfig(strings.NewReader(tt.yaml)); err != nil {
+			t.Fatal(err)
+		}
+		flags := flag.NewFlagSet("test", flag.ContinueOnError)
+		registerConfigFlags(flags)
+		var jobs []job
+		var err error
+		captureStdout(t, func() { jobs, err = loadJobs(flags, os.Stdout) })
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		deps := map[string][]string{}
+		for _, j := range jobs {
+			deps[j.name] = j.dependsOn
+		}
+		if !reflect.DeepEqual(deps, tt.deps) {
+			t.Errorf("%s: dependencies %v, want %v", tt.name, deps, tt.deps)
+		}
+	}
+	viper.Reset()
+}
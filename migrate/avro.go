@@ -0,0 +1,408 @@
+package migrate
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+// avroCodecs are the block compressions of an avro target, deflate by default
+var avroCodecs = map[string]string{
+	"":        goavro.CompressionDeflateLabel,
+	"deflate": goavro.CompressionDeflateLabel,
+	"snappy":  goavro.CompressionSnappyLabel,
+	"none":    goavro.CompressionNullLabel,
+}
+
+// avroName matches the names avro allows for records and fields
+var avroName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// avroColumn is a field of an avro record and the postgres type it is read or written as
+type avroColumn struct {
+	name     string
+	datatype string
+	// member names the type in the union with null, empty for a field which is never null
+	member string
+	scale  int
+	// unit is that of a local timestamp, goavro reads them as plain longs
+	unit time.Duration
+}
+
+// avroType maps a postgres type to the avro type of the field and its name in a union
+func avroType(datatype string) (interface{}, string, int) {
+	switch t := binaryType(datatype); t {
+	case "smallint", "integer":
+		return "int", "int", 0
+	case "bigint":
+		return "long", "long", 0
+	case "real":
+		return "float", "float", 0
+	case "double precision":
+		return "double", "double", 0
+	case "boolean":
+		return "boolean", "boolean", 0
+	case "bytea":
+		return "bytes", "bytes", 0
+	case "date":
+		return map[string]interface{}{"type": "int", "logicalType": "date"}, "int.date", 0
+	case "timestamp with time zone":
+		return map[string]interface{}{"type": "long", "logicalType": "timestamp-micros"}, "long.timestamp-micros", 0
+	case "timestamp without time zone":
+		return map[string]interface{}{"type": "long", "logicalType": "local-timestamp-micros"}, "long", 0
+	case "uuid":
+		return map[string]interface{}{"type": "string", "logicalType": "uuid"}, "string", 0
+	case "numeric":
+		var precision, scale int
+		if n, _ := fmt.Sscanf(strings.ToLower(datatype), "numeric(%d,%d)", &precision, &scale); n < 1 {
+			break
+		}
+		return map[string]interface{}{"type": "bytes", "logicalType": "decimal", "precision": precision, "scale": scale},
+			"bytes.decimal", scale
+	}
+	return "string", "string", 0
+}
+
+// avroWriter writes the records to an avro object container file, the schema derived from
+// the source columns is embedded in the file
+type avroWriter struct {
+	writer  *bufio.Writer
+	ocf     *goavro.OCFWriter
+	columns []avroColumn
+}
+
+func (m *Migrater) newAvroWriter(cols []string) (*avroWriter, error) {
+	types, err := m.sourceTypeNames(cols)
+	if err != nil {
+		return nil, err
+	}
+	// columns of a source table keep their not null, any other column may be null
+	notNull := map[string]bool{}
+	if m.copyCatalog() {
+		columns, err := catalogColumns(m.SourceDB, m.SourceTable)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range columns {
+			notNull[c.name] = c.notNull
+		}
+	}
+
+	a := &avroWriter{writer: m.TargetFile}
+	var fields []map[string]interface{}
+	for _, col := range cols {
+		if !avroName.MatchString(col) {
+			return nil, fmt.Errorf("Column %s is not a valid avro field name", col)
+		}
+		typ, member, scale := avroType(types[col])
+		field := map[string]interface{}{"name": col, "type": typ}
+		c := avroColumn{name: col, datatype: types[col], scale: scale}
+		if !notNull[col] {
+			field["type"] = []interface{}{"null", typ}
+			field["default"] = nil
+			c.member = member
+		}
+		fields = append(fields, field)
+		a.columns = append(a.columns, c)
+	}
+	schema, err := json.Marshal(map[string]interface{}{"type": "record", "name": "record", "fields": fields})
+	if err != nil {
+		return nil, err
+	}
+
+	codec, ok := avroCodecs[m.TargetFileCompression]
+	if !ok {
+		return nil, fmt.Errorf("Unsupported avro compression (%s)", m.TargetFileCompression)
+	}
+	if a.ocf, err = goavro.NewOCFWriter(goavro.OCFConfig{W: m.TargetFile, Schema: string(schema), CompressionName: codec}); err != nil {
+		return nil, fmt.Errorf("Error creating avro file (%s)", err)
+	}
+	return a, nil
+}
+
+func (a *avroWriter) Write(records []map[string]interface{}) error {
+	data := make([]interface{}, len(records))
+	for i, record := range records {
+		datum := make(map[string]interface{}, len(a.columns))
+		for _, col := range a.columns {
+			v := record[col.name]
+			if v == nil {
+				if col.member == "" {
+					return fmt.Errorf("Column %s is null but not nullable in the avro schema", col.name)
+				}
+				datum[col.name] = nil
+				continue
+			}
+			native, err := col.native(v)
+			if err != nil {
+				return fmt.Errorf("Error (%s) writing column %s", err, col.name)
+			}
+			if col.member != "" {
+				native = goavro.Union(col.member, native)
+			}
+			datum[col.name] = native
+		}
+		data[i] = datum
+	}
+	if err := a.ocf.Append(data); err != nil {
+		return fmt.Errorf("Error writing avro (%s)", err)
+	}
+	return a.writer.Flush()
+}
+
+func (a *avroWriter) Close() error { return a.writer.Flush() }
+
+// native converts a source value to the go value goavro encodes for the field type
+func (c avroColumn) native(v interface{}) (interface{}, error) {
+	switch t := binaryType(c.datatype); t {
+	case "smallint", "integer":
+		n, err := binaryInt(v)
+		if err == nil && (n < math.MinInt32 || n > math.MaxInt32) {
+			err = fmt.Errorf("%d is out of range for %s", n, t)
+		}
+		return int32(n), err
+	case "bigint":
+		return binaryInt(v)
+	case "real":
+		f, err := binaryFloat(v)
+		return float32(f), err
+	case "double precision":
+		return binaryFloat(v)
+	case "boolean":
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+		b, err := strconv.ParseBool(toString(v))
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a boolean", toString(v))
+		}
+		return b, nil
+	case "bytea":
+		return []byte(toString(v)), nil
+	case "date", "timestamp without time zone", "timestamp with time zone":
+		tm, err := binaryTime(v)
+		if err != nil {
+			return nil, err
+		}
+		if t == "timestamp with time zone" {
+			return tm, nil
+		}
+		tm = wallClock(tm)
+		if t == "date" {
+			return tm, nil
+		}
+		return tm.Unix()*1e6 + int64(tm.Nanosecond()/1e3), nil
+	case "uuid":
+		return canonicalUUID(v)
+	case "numeric":
+		if !strings.HasPrefix(strings.ToLower(c.datatype), "numeric(") {
+			break
+		}
+		unscaled, err := unscaledDecimal(toString(v), c.scale)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Rat).SetFrac(unscaled, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(c.scale)), nil)), nil
+	}
+	return toString(v), nil
+}
+
+// canonicalUUID checks a uuid and formats it the canonical way
+func canonicalUUID(v interface{}) (string, error) {
+	b, err := encodeBinary("uuid", v)
+	if err != nil {
+		return "", err
+	}
+	id, err := decodeBinary("uuid", b)
+	return id.(string), err
+}
+
+// avroReader reads the records of an avro object container file with its embedded schema
+type avroReader struct {
+	ocf     *goavro.OCFReader
+	columns []avroColumn
+}
+
+func (m *Migrater) newAvroReader() (*avroReader, error) {
+	ocf, err := goavro.NewOCFReader(m.SourceFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading avro file (%s)", err)
+	}
+	var schema struct {
+		Type   string `json:"type"`
+		Fields []struct {
+			Name string          `json:"name"`
+			Type json.RawMessage `json:"type"`
+		} `json:"fields"`
+	}
+	if err = json.Unmarshal(ocf.MetaData()["avro.schema"], &schema); err != nil || schema.Type != "record" {
+		return nil, fmt.Errorf("The schema of an avro source must be a record")
+	}
+	a := &avroReader{ocf: ocf}
+	m.fileTypes = map[string]string{}
+	for _, f := range schema.Fields {
+		col, err := avroColumnOf(f.Name, f.Type)
+		if err != nil {
+			return nil, err
+		}
+		a.columns = append(a.columns, col)
+		m.fileTypes[col.name] = col.datatype
+	}
+	return a, nil
+}
+
+// avroColumnOf maps the avro type of a field to a postgres type. Nested records, arrays and
+// maps are read as json.
+func avroColumnOf(name string, raw json.RawMessage) (avroColumn, error) {
+	col := avroColumn{name: name, datatype: "text"}
+	var typ interface{}
+	if err := json.Unmarshal(raw, &typ); err != nil {
+		return col, err
+	}
+	if union, ok := typ.([]interface{}); ok {
+		var members []interface{}
+		for _, member := range union {
+			if member != "null" {
+				members = append(members, member)
+			}
+		}
+		if len(members) != 1 {
+			col.datatype = "jsonb"
+			return col, nil
+		}
+		typ = members[0]
+	}
+
+	switch t := typ.(type) {
+	case string:
+		col.datatype = avroPrimitives[t]
+	case map[string]interface{}:
+		logical, _ := t["logicalType"].(string)
+		switch logical {
+		case "date":
+			col.datatype = "date"
+		case "timestamp-millis", "timestamp-micros":
+			col.datatype = "timestamp with time zone"
+		case "local-timestamp-millis", "local-timestamp-micros":
+			col.datatype = "timestamp without time zone"
+			col.unit = time.Microsecond
+			if logical == "local-timestamp-millis" {
+				col.unit = time.Millisecond
+			}
+		case "time-millis", "time-micros":
+			col.datatype = "time without time zone"
+		case "uuid":
+			col.datatype = "uuid"
+		case "decimal":
+			precision, _ := t["precision"].(float64)
+			scale, _ := t["scale"].(float64)
+			col.datatype = fmt.Sprintf("numeric(%d,%d)", int(precision), int(scale))
+			col.scale = int(scale)
+		default:
+			base, _ := t["type"].(string)
+			switch base {
+			case "record", "array", "map":
+				col.datatype = "jsonb"
+			case "fixed":
+				col.datatype = "bytea"
+			default:
+				col.datatype = avroPrimitives[base]
+			}
+		}
+	}
+	if col.datatype == "" {
+		col.datatype = "text"
+	}
+	return col, nil
+}
+
+// avroPrimitives maps the primitive avro types to postgres types
+var avroPrimitives = map[string]string{
+	"int":     "integer",
+	"long":    "bigint",
+	"float":   "real",
+	"double":  "double precision",
+	"boolean": "boolean",
+	"string":  "text",
+	"bytes":   "bytea",
+	"enum":    "text",
+}
+
+func (a *avroReader) Columns() []string {
+	cols := make([]string, len(a.columns))
+	for i, c := range a.columns {
+		cols[i] = c.name
+	}
+	return cols
+}
+
+func (a *avroReader) Read() (map[string]interface{}, error) {
+	if !a.ocf.Scan() {
+		if err := a.ocf.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	datum, err := a.ocf.Read()
+	if err != nil {
+		return nil, err
+	}
+	record, ok := datum.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Avro datum is not a record")
+	}
+	mp := make(map[string]interface{}, len(a.columns))
+	for _, col := range a.columns {
+		if mp[col.name], err = col.value(record[col.name]); err != nil {
+			return nil, fmt.Errorf("Error (%s) reading column %s", err, col.name)
+		}
+	}
+	return mp, nil
+}
+
+func (a *avroReader) Close() error { return nil }
+
+// value converts a value decoded by goavro to the go value of the column type
+func (c avroColumn) value(v interface{}) (interface{}, error) {
+	// a union value is keyed by the name of its member type
+	if union, ok := v.(map[string]interface{}); ok && len(union) == 1 && c.datatype != "jsonb" {
+		for _, member := range union {
+			v = member
+		}
+	}
+	switch val := v.(type) {
+	case nil:
+		return nil, nil
+	case int32:
+		return int64(val), nil
+	case int:
+		return int64(val), nil
+	case int64:
+		if c.unit != 0 {
+			return unixTime(val, c.unit), nil
+		}
+		return val, nil
+	case float32:
+		return float64(val), nil
+	case *big.Rat:
+		return val.FloatString(c.scale), nil
+	case time.Time:
+		return val.UTC(), nil
+	case time.Duration:
+		return time.Unix(0, 0).UTC().Add(val).Format("15:04:05.999999"), nil
+	case []byte:
+		return string(val), nil
+	case map[string]interface{}, []interface{}:
+		b, err := json.Marshal(val)
+		return string(b), err
+	}
+	return v, nil
+}
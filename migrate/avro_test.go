@@ -0,0 +1,141 @@
+package migrate
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAvroRoundTrip(t *testing.T) {
+	cols := []string{"name", "id", "small", "total", "ratio", "ok", "raw", "amount", "day", "at", "local", "uid"}
+	types := map[string]string{
+		"id": "bigint", "small": "integer", "total": "double precision", "ratio": "real", "ok": "boolean",
+		"raw": "bytea", "amount": "numeric(12,3)", "day": "date", "at": "timestamp with time zone",
+		"local": "timestamp without time zone", "uid": "uuid",
+	}
+	records := []map[string]interface{}{
+		{"name": "a", "id": "9007199254740993", "small": int64(-7), "total": "1.25", "ratio": 0.5, "ok": "true",
+			"raw": "\x00\xff", "amount": "-12.3456", "day": "2024-02-29", "at": "2024-03-01 10:30:00.123456+02",
+			"local": "2024-03-01 10:30:00.5", "uid": "A0EEBC99-9C0B-4EF8-BB6D-6BB9BD380A11"},
+		{"name": nil, "id": nil, "small": nil, "total": nil, "ratio": nil, "ok": nil, "raw": nil, "amount": nil,
+			"day": nil, "at": nil, "local": nil, "uid": nil},
+		// the far dates are out of the range of a time.Duration
+		{"name": "z", "id": nil, "small": nil, "total": nil, "ratio": nil, "ok": nil, "raw": nil, "amount": nil,
+			"day": "9999-12-31", "at": nil, "local": "9999-12-31 23:59:59.999999", "uid": nil},
+		{"name": "y", "id": nil, "small": nil, "total": nil, "ratio": nil, "ok": nil, "raw": nil, "amount": nil,
+			"day": "0001-01-01", "at": nil, "local": "0001-01-01 00:00:00", "uid": nil},
+	}
+	want := []map[string]interface{}{
+		{"name": "a", "id": int64(9007199254740993), "small": int64(-7), "total": 1.25, "ratio": 0.5, "ok": true,
+			"raw": "\x00\xff", "amount": "-12.346", "day": time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+			"at": time.Date(2024, 3, 1, 8, 30, 0, 123456000, time.UTC), "local": time.Date(2024, 3, 1, 10, 30, 0, 500000000, time.UTC),
+			"uid": "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"},
+		{"name": nil, "id": nil, "small": nil, "total": nil, "ratio": nil, "ok": nil, "raw": nil, "amount": nil,
+			"day": nil, "at": nil, "local": nil, "uid": nil},
+		{"name": "z", "id": nil, "small": nil, "total": nil, "ratio": nil, "ok": nil, "raw": nil, "amount": nil,
+			"day": time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC), "at": nil,
+			"local": time.Date(9999, 12, 31, 23, 59, 59, 999999000, time.UTC), "uid": nil},
+		{"name": "y", "id": nil, "small": nil, "total": nil, "ratio": nil, "ok": nil, "raw": nil, "amount": nil,
+			"day": time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC), "at": nil, "local": time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC), "uid": nil},
+	}
+
+	for _, compression := range []string{"", "snappy", "none"} {
+		var out bytes.Buffer
+		m := &Migrater{TargetFile: bufio.NewWriter(&out), TargetFileCompression: compression, fileTypes: types}
+		w, err := m.newAvroWriter(cols)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = w.Write(records); err != nil {
+			t.Fatalf("%q: %s", compression, err)
+		}
+		w.Close()
+
+		source := &Migrater{SourceFile: bufio.NewReader(&out)}
+		r, err := source.newAvroReader()
+		if err != nil {
+			t.Fatalf("%q: %s", compression, err)
+		}
+		if !reflect.DeepEqual(r.Columns(), cols) {
+			t.Errorf("%q: columns %v, want %v", compression, r.Columns(), cols)
+		}
+		for col, typ := range types {
+			if col != "total" && col != "ratio" && source.fileTypes[col] != typ {
+				t.Errorf("%q: %s is read as %s, want %s", compression, col, source.fileTypes[col], typ)
+			}
+		}
+		for i, w := range want {
+			got, err := r.Read()
+			if err != nil || !reflect.DeepEqual(got, w) {
+				t.Errorf("%q: record %d: %#v %v, want %#v", compression, i, got, err, w)
+			}
+		}
+		if _, err = r.Read(); err != io.EOF {
+			t.Errorf("%q: got %v after the last record, want io.EOF", compression, err)
+		}
+	}
+}
+
+func TestAvroWriteErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		cols        []string
+		types       map[string]string
+		compression string
+		record      map[string]interface{}
+		want        string
+	}{
+		{name: "field name", cols: []string{"first name"}, want: "not a valid avro field name"},
+		{name: "compression", cols: []string{"id"}, compression: "lzo", want: "Unsupported avro compression"},
+		{name: "int range", cols: []string{"id"}, types: map[string]string{"id": "integer"},
+			record: map[string]interface{}{"id": int64(1) << 33}, want: "out of range"},
+		{name: "boolean", cols: []string{"ok"}, types: map[string]string{"ok": "boolean"},
+			record: map[string]interface{}{"ok": "maybe"}, want: "not a boolean"},
+		{name: "uuid", cols: []string{"uid"}, types: map[string]string{"uid": "uuid"},
+			record: map[string]interface{}{"uid": "nope"}, want: "uid"},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		m := &Migrater{TargetFile: bufio.NewWriter(&out), TargetFileCompression: tt.compression, fileTypes: tt.types}
+		w, err := m.newAvroWriter(tt.cols)
+		if err == nil {
+			err = w.Write([]map[string]interface{}{tt.record})
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestAvroColumnOf(t *testing.T) {
+	tests := []struct {
+		typ  string
+		want string
+	}{
+		{`"int"`, "integer"},
+		{`["null", "long"]`, "bigint"},
+		{`["null", "string", "long"]`, "jsonb"},
+		{`{"type": "int", "logicalType": "date"}`, "date"},
+		{`{"type": "long", "logicalType": "timestamp-millis"}`, "timestamp with time zone"},
+		{`{"type": "long", "logicalType": "local-timestamp-millis"}`, "timestamp without time zone"},
+		{`{"type": "int", "logicalType": "time-millis"}`, "time without time zone"},
+		{`{"type": "string", "logicalType": "uuid"}`, "uuid"},
+		{`{"type": "bytes", "logicalType": "decimal", "precision": 10, "scale": 2}`, "numeric(10,2)"},
+		{`{"type": "fixed", "name": "f", "size": 4}`, "bytea"},
+		{`{"type": "enum", "name": "e", "symbols": ["a"]}`, "text"},
+		{`{"type": "record", "name": "r", "fields": []}`, "jsonb"},
+		{`{"type": "array", "items": "int"}`, "jsonb"},
+		{`{"type": "map", "values": "int"}`, "jsonb"},
+	}
+	for _, tt := range tests {
+		col, err := avroColumnOf("c", json.RawMessage(tt.typ))
+		if err != nil || col.datatype != tt.want {
+			t.Errorf("%s: %s %v, want %s", tt.typ, col.datatype, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package migrate
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// maxIdentifier is the longest name postgres keeps
+const maxIdentifier = 63
+
+// indexKind marks a tableObject which is a plain index, the constraints use their pg_constraint contype
+const indexKind = "i"
+
+// tableObject is a constraint or an index of a table, recreated on another table
+type tableObject struct {
+	name       string
+	definition string
+	// kind is the contype of a constraint (p , u , x , c , f) or indexKind
+	kind string
+	// referenced is the table a foreign key points to, schema qualified when it is not on the search
+	// path, selfReference is set when it is the table itself
+	referenced    string
+	selfReference bool
+}
+
+// catalogColumn is a column of a source table as described by the postgres catalog
+type catalogColumn struct {
+	name     string
+	datatype string
+	notNull  bool
+	def      string
+	comment  string
+	// serial columns take their values from a sequence, reset to the loaded values afterwards
+	serial bool
+}
+
+// catalogObjects lists the constraints of table, keys before checks and foreign keys, followed
+// by the indexes not backing a constraint
+func catalogObjects(db querier, table string) ([]tableObject, error) {
+	var objects []tableObject
+	rows, err := db.Query(`SELECT conname, pg_get_constraintdef(oid), contype::text,
+			CASE WHEN contype = 'f' THEN confrelid::regclass::text ELSE '' END, confrelid = conrelid
+		FROM pg_constraint
+		WHERE conrelid = $1::regclass AND contype IN ('p', 'u', 'x', 'c', 'f')
+		ORDER BY position(contype IN 'puxcf'), conname`, table)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var o tableObject
+		if err = rows.Scan(&o.name, &o.definition, &o.kind, &o.referenced, &o.selfReference); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		objects = append(objects, o)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(`SELECT c.relname, pg_get_indexdef(i.indexrelid) FROM pg_index i
+		JOIN pg_class c ON c.oid = i.indexrelid
+		WHERE i.indrelid = $1::regclass AND NOT EXISTS (
+			SELECT 1 FROM pg_constraint k WHERE k.conrelid = i.indrelid AND k.conindid = i.indexrelid)
+		ORDER BY c.relname`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		o := tableObject{kind: indexKind}
+		if err = rows.Scan(&o.name, &o.definition); err != nil {
+			return nil, err
+		}
+		objects = append(objects, o)
+	}
+	return objects, rows.Err()
+}
+
+// catalogColumns describes the columns of table in their order, with the types, defaults and
+// comments of the catalog
+func catalogColumns(db querier, table string) ([]catalogColumn, error) {
+	rows, err := db.Query(`SELECT a.attname, format_type(a.atttypid, a.atttypmod), a.attnotnull,
+			CASE WHEN a.attgenerated = '' THEN COALESCE(pg_get_expr(d.adbin, d.adrelid), '') ELSE '' END,
+			COALESCE(col_description(a.attrelid, a.attnum), ''), a.attidentity <> ''
+		FROM pg_attribute a
+		LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+		WHERE a.attrelid = $1::regclass AND a.attnum > 0 AND NOT a.attisdropped
+		ORDER BY a.attnum`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var columns []catalogColumn
+	for rows.Next() {
+		var c catalogColumn
+		var identity bool
+		if err = rows.Scan(&c.name, &c.datatype, &c.notNull, &c.def, &c.comment, &identity); err != nil {
+			return nil, err
+		}
+		// the sequence of the source does not exist on the target, the column gets its own
+		if identity || strings.HasPrefix(c.def, "nextval(") {
+			c.serial = true
+			c.def = ""
+		}
+		columns = append(columns, c)
+	}
+	return columns, rows.Err()
+}
+
+// definition is the column as written in CREATE TABLE, a serial column is an identity column
+// which still accepts the loaded values
+func (c catalogColumn) definition() string {
+	parts := []string{c.name, c.datatype}
+	if c.serial {
+		parts = append(parts, "GENERATED BY DEFAULT AS IDENTITY")
+	}
+	if c.notNull {
+		parts = append(parts, "NOT NULL")
+	}
+	if c.def != "" {
+		parts = append(parts, "DEFAULT "+c.def)
+	}
+	return strings.Join(parts, " ")
+}
+
+// references matches the referenced table of a foreign key definition
+var references = regexp.MustCompile(`REFERENCES \S+?\(`)
+
+// indexDefinition splits CREATE [UNIQUE] INDEX name ON [ONLY] table USING ... around the names
+var indexDefinition = regexp.MustCompile(`^(CREATE (?:UNIQUE )?INDEX )\S+( ON (?:ONLY )?)\S+( .*)$`)
+
+// createSQL builds the object on table under name. A foreign key is pointed at referenced
+// instead of the table of its definition unless referenced is empty.
+func (o tableObject) createSQL(table, name, referenced string) string {
+	if o.kind == indexKind {
+		return indexDefinition.ReplaceAllString(o.definition, "${1}"+name+"${2}"+table+"${3}")
+	}
+	definition := o.definition
+	if referenced != "" {
+		definition = references.ReplaceAllLiteralString(definition, "REFERENCES "+referenced+"(")
+	}
+	return fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s %s", table, name, definition)
+}
+
+// renamed gives an object of the source table a name for the target table, the source table
+// name in it is replaced by the target one
+func renamed(name, source, target string) string {
+	if source == target {
+		return name
+	}
+	if strings.HasPrefix(name, source) {
+		name = target + strings.TrimPrefix(name, source)
+	} else {
+		name = target + "_" + name
+	}
+	if len(name) > maxIdentifier {
+		name = name[:maxIdentifier]
+	}
+	return name
+}
+
+// quoteLiteral quotes a string for a sql statement
+func quoteLiteral(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// copyCatalog reports whether the target table is created from the catalog of the source
+// table. A sql source has no catalog to copy from.
+func (m *Migrater) copyCatalog() bool {
+	return m.SourceDB != nil && m.SourceSQL == "" && m.SourceTable != ""
+}
+
+// catalogTableSQL builds the statements creating tableName like the source table. The second
+// list holds what runs after the load: the deferred keys and indexes and the sequence resets.
+func (m *Migrater) catalogTableSQL(tableName string) ([]string, []string, error) {
+	columns, err := catalogColumns(m.SourceDB, m.SourceTable)
+	if err != nil {
+		return nil, nil, err
+	}
+	objects, err := catalogObjects(m.SourceDB, m.SourceTable)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var defs, create, after []string
+	for _, c := range columns {
+		defs = append(defs, c.definition())
+	}
+	create = append(create, fmt.Sprintf("CREATE TABLE %s (%s)", tableName, strings.Join(defs, ", ")))
+
+	var comment string
+	if err = m.SourceDB.QueryRow("SELECT COALESCE(obj_description($1::regclass, 'pg_class'), '')",
+		m.SourceTable).Scan(&comment); err != nil {
+		return nil, nil, err
+	}
+	if comment != "" {
+		create = append(create, fmt.Sprintf("COMMENT ON TABLE %s IS %s", tableName, quoteLiteral(comment)))
+	}
+	for _, c := range columns {
+		if c.comment != "" {
+			create = append(create, fmt.Sprintf("COMMENT ON COLUMN %s.%s IS %s", tableName, c.name, quoteLiteral(c.comment)))
+		}
+	}
+
+	source, target := unqualified(m.SourceTable), unqualified(tableName)
+	for _, o := range objects {
+		referenced := ""
+		if o.kind == "f" {
+			referenced = o.referenced
+			if o.selfReference {
+				referenced = tableName
+			} else if m.TargetDB == nil {
+				fmt.Fprintf(m.progress(), "Skipping foreign key %s to table %s in the dump\n", o.name, o.referenced)
+				continue
+			} else if !m.tableExists(o.referenced) {
+				fmt.Fprintf(m.progress(), "Skipping foreign key %s, table %s does not exist on the target\n", o.name, o.referenced)
+				continue
+			}
+		}
+		stmt := o.createSQL(tableName, renamed(o.name, source, target), referenced)
+		if m.TargetDeferIndexes {
+			after = append(after, stmt)
+		} else {
+			create = append(create, stmt)
+		}
+	}
+
+	for _, c := range columns {
+		if c.serial {
+			after = append(after, fmt.Sprintf(
+				"SELECT setval(pg_get_serial_sequence('%s', '%s'), COALESCE(MAX(%s), 0) + 1, false) FROM %s",
+				tableName, c.name, c.name, tableName))
+		}
+	}
+	return create, after, nil
+}
@@ -0,0 +1,55 @@
+package migrate
+
+import (
+	"io"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestCatalogTableSQLForeignKeys(t *testing.T) {
+	source, sourceMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+	target, targetMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+
+	sourceMock.ExpectQuery("FROM pg_attribute").WithArgs("orders").WillReturnRows(
+		catalogRows([2]string{"id", "integer"}, [2]string{"customer", "integer"}, [2]string{"region", "integer"}))
+	// the table of another schema is qualified as confrelid::regclass::text gives it
+	sourceMock.ExpectQuery(regexp.QuoteMeta("confrelid::regclass::text")).WithArgs("orders").WillReturnRows(
+		sqlmock.NewRows([]string{"conname", "def", "contype", "referenced", "self"}).
+			AddRow("orders_customer_fkey", "FOREIGN KEY (customer) REFERENCES sales.customers(id)", "f", "sales.customers", false).
+			AddRow("orders_region_fkey", "FOREIGN KEY (region) REFERENCES regions(id)", "f", "regions", false))
+	sourceMock.ExpectQuery("FROM pg_index").WithArgs("orders").WillReturnRows(sqlmock.NewRows([]string{"relname", "def"}))
+	sourceMock.ExpectQuery("obj_description").WithArgs("orders").WillReturnRows(sqlmock.NewRows([]string{"comment"}).AddRow(""))
+	targetMock.ExpectQuery(regexp.QuoteMeta("SELECT to_regclass('sales.customers')")).WillReturnRows(
+		sqlmock.NewRows([]string{"to_regclass"}).AddRow("sales.customers"))
+	targetMock.ExpectQuery(regexp.QuoteMeta("SELECT to_regclass('regions')")).WillReturnRows(
+		sqlmock.NewRows([]string{"to_regclass"}).AddRow(nil))
+
+	m := &Migrater{SourceDB: source, SourceTable: "orders", TargetDB: target, Progress: io.Discard}
+	create, _, err := m.catalogTableSQL("orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"CREATE TABLE orders (id integer, customer integer, region integer)",
+		"ALTER TABLE orders ADD CONSTRAINT orders_customer_fkey FOREIGN KEY (customer) REFERENCES sales.customers(id)",
+	}
+	if !reflect.DeepEqual(create, want) {
+		t.Errorf("got %q, want %q", create, want)
+	}
+	for _, mock := range []sqlmock.Sqlmock{sourceMock, targetMock} {
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	}
+}
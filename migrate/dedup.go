@@ -0,0 +1,291 @@
+package migrate
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	// KeepFirst keeps the first record seen for a key and discards the later ones
+	KeepFirst = "first"
+	// KeepLast keeps the last record seen for a key and discards the earlier ones
+	KeepLast = "last"
+
+	// keySize is the size of a hashed key on disk and in memory
+	keySize = 16
+	// keyOverhead is the approximate memory used by one key held in the in memory set
+	keyOverhead = 48
+)
+
+func init() {
+	// values scanned from a database are carried through the spool for KeepLast
+	gob.Register(time.Time{})
+}
+
+// Deduper discards records whose key columns repeat another record. Keys are held in memory
+// until the memory budget is exceeded, after which they are spilled to sorted files on disk.
+type Deduper struct {
+	keys      []string
+	keep      string
+	seen      *keySet
+	discarded int
+
+	// spool holds every record for KeepLast, hashes holds their keys in the same order
+	spool   *os.File
+	encoder *gob.Encoder
+	hashes  *os.File
+	hashBuf *bufio.Writer
+	count   int64
+}
+
+// NewDeduper creates a Deduper for the key columns. memoryMB is the budget for the in memory key set.
+func NewDeduper(keys []string, keep string, memoryMB int) (*Deduper, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("Please provide the key columns for deduplication")
+	}
+	if keep == "" {
+		keep = KeepFirst
+	}
+	if keep != KeepFirst && keep != KeepLast {
+		return nil, fmt.Errorf("Invalid dedup keep (%s), use %s or %s", keep, KeepFirst, KeepLast)
+	}
+	if memoryMB <= 0 {
+		memoryMB = 64
+	}
+	d := &Deduper{
+		keys: keys,
+		keep: keep,
+		seen: &keySet{limit: memoryMB << 20 / keyOverhead, mem: map[[keySize]byte]struct{}{}, progress: os.Stdout},
+	}
+	return d, nil
+}
+
+// Discarded returns the number of duplicate records dropped so far
+func (d *Deduper) Discarded() int { return d.discarded }
+
+// Add passes a record through the dedup stage. For KeepFirst the record is emitted straight away
+// when its key is new, for KeepLast records are held back until Flush.
+func (d *Deduper) Add(record map[string]interface{}, emit func(map[string]interface{}) error) error {
+	key := d.hash(record)
+	if d.keep == KeepFirst {
+		added, err := d.seen.add(key)
+		if err != nil {
+			return err
+		}
+		if !added {
+			d.discarded++
+			return nil
+		}
+		return emit(record)
+	}
+
+	if d.spool == nil {
+		if err := d.openSpool(); err != nil {
+			return err
+		}
+	}
+	if err := d.encoder.Encode(record); err != nil {
+		return fmt.Errorf("Error spooling record for dedup (%s)", err)
+	}
+	if _, err := d.hashBuf.Write(key[:]); err != nil {
+		return err
+	}
+	d.count++
+	return nil
+}
+
+// Flush emits the records held back for KeepLast, in their original order
+func (d *Deduper) Flush(emit func(map[string]interface{}) error) error {
+	if d.keep == KeepFirst || d.spool == nil {
+		return nil
+	}
+	if err := d.hashBuf.Flush(); err != nil {
+		return err
+	}
+
+	// walk the keys backwards, the first time a key is seen is its last occurrence
+	kept := make([]uint64, (d.count+63)/64)
+	var key [keySize]byte
+	for i := d.count - 1; i >= 0; i-- {
+		if _, err := d.hashes.ReadAt(key[:], i*keySize); err != nil {
+			return err
+		}
+		added, err := d.seen.add(key)
+		if err != nil {
+			return err
+		}
+		if added {
+			kept[i/64] |= 1 << uint(i%64)
+		} else {
+			d.discarded++
+		}
+	}
+
+	if _, err := d.spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	decoder := gob.NewDecoder(bufio.NewReader(d.spool))
+	for i := int64(0); i < d.count; i++ {
+		var record map[string]interface{}
+		if err := decoder.Decode(&record); err != nil {
+			return fmt.Errorf("Error reading spooled record (%s)", err)
+		}
+		if kept[i/64]&(1<<uint(i%64)) == 0 {
+			continue
+		}
+		if err := emit(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close removes any temporary files created by the Deduper
+func (d *Deduper) Close() error {
+	for _, f := range []*os.File{d.spool, d.hashes} {
+		if f != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}
+	d.spool, d.hashes = nil, nil
+	return d.seen.close()
+}
+
+func (d *Deduper) openSpool() error {
+	var err error
+	if d.spool, err = ioutil.TempFile("", "migrater-dedup-spool"); err != nil {
+		return err
+	}
+	if d.hashes, err = ioutil.TempFile("", "migrater-dedup-keys"); err != nil {
+		return err
+	}
+	d.encoder = gob.NewEncoder(d.spool)
+	d.hashBuf = bufio.NewWriter(d.hashes)
+	return nil
+}
+
+// hash returns a fixed size hash of the key columns of the record
+func (d *Deduper) hash(record map[string]interface{}) [keySize]byte {
+	h := fnv.New128a()
+	var length [8]byte
+	for _, col := range d.keys {
+		val, ok := record[col]
+		if !ok || val == nil {
+			h.Write([]byte{0})
+			continue
+		}
+		str := toString(val)
+		binary.BigEndian.PutUint64(length[:], uint64(len(str)))
+		h.Write([]byte{1})
+		h.Write(length[:])
+		h.Write([]byte(str))
+	}
+	var key [keySize]byte
+	copy(key[:], h.Sum(nil))
+	return key
+}
+
+// keySet is a set of hashed keys which spills to sorted files once it holds more than limit keys
+type keySet struct {
+	limit  int
+	mem    map[[keySize]byte]struct{}
+	spills []*os.File
+	// progress is told when the keys are spilled
+	progress io.Writer
+}
+
+// add inserts the key and reports whether it was not already present
+func (s *keySet) add(key [keySize]byte) (bool, error) {
+	if _, ok := s.mem[key]; ok {
+		return false, nil
+	}
+	for _, f := range s.spills {
+		found, err := searchSpill(f, key)
+		if err != nil {
+			return false, err
+		}
+		if found {
+			return false, nil
+		}
+	}
+	s.mem[key] = struct{}{}
+	if len(s.mem) >= s.limit {
+		if err := s.spill(); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (s *keySet) spill() error {
+	fmt.Fprintf(s.progress, "Spilling %d dedup keys to disk\n", len(s.mem))
+	keys := make([][keySize]byte, 0, len(s.mem))
+	for k := range s.mem {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i][:], keys[j][:]) < 0 })
+
+	f, err := ioutil.TempFile("", "migrater-dedup-spill")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, k := range keys {
+		if _, err = w.Write(k[:]); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err = w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	s.spills = append(s.spills, f)
+	s.mem = map[[keySize]byte]struct{}{}
+	return nil
+}
+
+func (s *keySet) close() error {
+	for _, f := range s.spills {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	s.spills = nil
+	return nil
+}
+
+// searchSpill does a binary search for the key in a sorted spill file
+func searchSpill(f *os.File, key [keySize]byte) (bool, error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	var buf [keySize]byte
+	lo, hi := int64(0), stat.Size()/keySize
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if _, err = f.ReadAt(buf[:], mid*keySize); err != nil {
+			return false, err
+		}
+		switch c := bytes.Compare(buf[:], key[:]); {
+		case c == 0:
+			return true, nil
+		case c < 0:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+	return false, nil
+}
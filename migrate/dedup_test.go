@@ -0,0 +1,97 @@
+package migrate
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDeduper(t *testing.T) {
+	records := []map[string]interface{}{
+		{"id": "1", "name": "a"},
+		{"id": "2", "name": "b"},
+		{"id": "1", "name": "c"},
+		{"id": nil, "name": "d"},
+		{"id": "2", "name": "e"},
+		{"id": nil, "name": "f"},
+		// a missing key hashes like NULL
+		{"name": "g"},
+	}
+	tests := []struct {
+		keep  string
+		limit int
+		want  []string
+	}{
+		{KeepFirst, 0, []string{"a", "b", "d"}},
+		{KeepLast, 0, []string{"c", "e", "g"}},
+		// a limit of 1 spills every key to disk
+		{KeepFirst, 1, []string{"a", "b", "d"}},
+		{KeepLast, 1, []string{"c", "e", "g"}},
+	}
+	for _, tt := range tests {
+		d, err := NewDeduper([]string{"id"}, tt.keep, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.limit > 0 {
+			d.seen.limit = tt.limit
+		}
+		var got []string
+		emit := func(record map[string]interface{}) error {
+			got = append(got, record["name"].(string))
+			return nil
+		}
+		for _, record := range records {
+			if err = d.Add(record, emit); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = d.Flush(emit); err != nil {
+			t.Fatal(err)
+		}
+		d.Close()
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("keep %s, limit %d: got %v, want %v", tt.keep, tt.limit, got, tt.want)
+		}
+		if want := len(records) - len(tt.want); d.Discarded() != want {
+			t.Errorf("keep %s, limit %d: discarded %d, want %d", tt.keep, tt.limit, d.Discarded(), want)
+		}
+	}
+}
+
+func TestDeduperCompositeKey(t *testing.T) {
+	d, err := NewDeduper([]string{"a", "b"}, KeepFirst, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	// the values are length prefixed, ab+c is not a+bc
+	records := []map[string]interface{}{
+		{"a": "ab", "b": "c"},
+		{"a": "a", "b": "bc"},
+		{"a": "ab", "b": "c"},
+		{"a": 1, "b": "2"},
+		{"a": "1", "b": 2},
+	}
+	kept := 0
+	for _, record := range records {
+		d.Add(record, func(map[string]interface{}) error { kept++; return nil })
+	}
+	if kept != 3 || d.Discarded() != 2 {
+		t.Errorf("kept %d, discarded %d, want 3 and 2", kept, d.Discarded())
+	}
+}
+
+func TestNewDeduperErrors(t *testing.T) {
+	tests := []struct {
+		keys []string
+		keep string
+	}{
+		{nil, KeepFirst},
+		{[]string{"id"}, "middle"},
+	}
+	for _, tt := range tests {
+		if _, err := NewDeduper(tt.keys, tt.keep, 0); err == nil {
+			t.Errorf("keys %v, keep %s: expected an error", tt.keys, tt.keep)
+		}
+	}
+}
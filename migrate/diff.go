@@ -0,0 +1,299 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// TableDiff is the difference between a source table and its target table. DDL holds the
+// statements bringing the target in line with the source.
+type TableDiff struct {
+	Source       string
+	Target       string
+	MissingTable bool
+	Changes      []string
+	DDL          []string
+}
+
+// Diff compares the source tables with the target tables of the same names: the columns with
+// their types and nullability, the keys, constraints and indexes. A file source only has
+// column names to compare.
+func (m *Migrater) Diff() ([]TableDiff, error) {
+	defer m.cleanUp()
+	if m.TargetDB == nil {
+		return nil, errors.New("Comparing needs a database target")
+	}
+	if len(m.SourceInclude) == 0 {
+		d, err := m.diffTable()
+		if err != nil {
+			return nil, err
+		}
+		return []TableDiff{d}, nil
+	}
+
+	tables, err := m.schemaTables()
+	if err != nil {
+		return nil, err
+	}
+	var diffs []TableDiff
+	for _, table := range tables {
+		m.SourceTable = m.qualified(table)
+		m.TargetTable = table
+		d, err := m.diffTable()
+		if err != nil {
+			return nil, fmt.Errorf("table %s: %s", table, err)
+		}
+		diffs = append(diffs, d)
+	}
+	return diffs, nil
+}
+
+// diffTable compares the current source with the target table
+func (m *Migrater) diffTable() (TableDiff, error) {
+	d := TableDiff{Source: m.describeSource(), Target: m.TargetTable}
+	if !m.tableExists(m.TargetTable) {
+		d.MissingTable = true
+		d.Changes = append(d.Changes, "table is missing on the target")
+		create, after, err := m.diffCreateSQL()
+		if err != nil {
+			return d, err
+		}
+		d.DDL = append(create, after...)
+		return d, nil
+	}
+
+	sourceColumns, typed, err := m.diffSourceColumns()
+	if err != nil {
+		return d, err
+	}
+	targetColumns, err := catalogColumns(m.TargetDB, m.TargetTable)
+	if err != nil {
+		return d, err
+	}
+	m.diffColumns(&d, sourceColumns, targetColumns, typed)
+	if m.copyCatalog() {
+		if err = m.diffObjects(&d); err != nil {
+			return d, err
+		}
+	}
+	return d, nil
+}
+
+func (m *Migrater) describeSource() string {
+	switch {
+	case m.SourceDB == nil:
+		return fmt.Sprintf("%s file %s", m.SourceFileType, m.SourceFilePath)
+	case m.SourceSQL != "":
+		return "source sql"
+	}
+	return m.SourceTable
+}
+
+// diffCreateSQL is the script creating a missing target table, without the sequence resets
+// which only matter after a load
+func (m *Migrater) diffCreateSQL() ([]string, []string, error) {
+	if !m.copyCatalog() {
+		columns, _, err := m.diffSourceColumns()
+		if err != nil {
+			return nil, nil, err
+		}
+		var cols []string
+		for _, c := range columns {
+			cols = append(cols, c.name)
+		}
+		create, _, err := m.createTableStatements(m.TargetTable, cols)
+		return create, nil, err
+	}
+	create, after, err := m.createTableStatements(m.TargetTable, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	var deferred []string
+	for _, stmt := range after {
+		if !strings.HasPrefix(stmt, "SELECT setval") {
+			deferred = append(deferred, stmt)
+		}
+	}
+	return create, deferred, nil
+}
+
+// diffSourceColumns describes the source columns. typed is false for a file source, its
+// columns have names only.
+func (m *Migrater) diffSourceColumns() ([]catalogColumn, bool, error) {
+	if m.copyCatalog() {
+		columns, err := catalogColumns(m.SourceDB, m.SourceTable)
+		return columns, true, err
+	}
+	var columns []catalogColumn
+	if m.SourceDB != nil {
+		types, err := m.sourceColumnTypes()
+		if err != nil {
+			return nil, false, err
+		}
+		for _, t := range types {
+			c := catalogColumn{name: t.Name(), datatype: typeName(t.DatabaseTypeName(), t)}
+			if nullable, ok := t.Nullable(); ok {
+				c.notNull = !nullable
+			}
+			columns = append(columns, c)
+		}
+		return columns, true, nil
+	}
+	reader, err := m.newReader()
+	if err != nil {
+		return nil, false, err
+	}
+	defer reader.Close()
+	for _, col := range reader.Columns() {
+		columns = append(columns, catalogColumn{name: col, datatype: "text"})
+	}
+	return columns, false, nil
+}
+
+func (m *Migrater) diffColumns(d *TableDiff, source, target []catalogColumn, typed bool) {
+	targetByName := map[string]catalogColumn{}
+	for _, c := range target {
+		targetByName[strings.ToLower(c.name)] = c
+	}
+	seen := map[string]bool{}
+	for _, s := range source {
+		name := strings.ToLower(s.name)
+		seen[name] = true
+		t, ok := targetByName[name]
+		if !ok {
+			d.Changes = append(d.Changes, fmt.Sprintf("column %s %s is missing on the target", s.name, s.datatype))
+			column := s.name + " " + s.datatype
+			if s.def != "" {
+				column += " DEFAULT " + s.def
+			}
+			d.DDL = append(d.DDL, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", m.TargetTable, column))
+			continue
+		}
+		if !typed {
+			continue
+		}
+		if s.datatype != t.datatype {
+			d.Changes = append(d.Changes, fmt.Sprintf("column %s is %s in the source and %s on the target", s.name, s.datatype, t.datatype))
+			d.DDL = append(d.DDL, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s USING %s::%s",
+				m.TargetTable, t.name, s.datatype, t.name, s.datatype))
+		}
+		if s.notNull != t.notNull {
+			change, action := "nullable", "DROP NOT NULL"
+			if s.notNull {
+				change, action = "not null", "SET NOT NULL"
+			}
+			d.Changes = append(d.Changes, fmt.Sprintf("column %s is %s in the source only", s.name, change))
+			d.DDL = append(d.DDL, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s %s", m.TargetTable, t.name, action))
+		}
+	}
+	for _, t := range target {
+		if !seen[strings.ToLower(t.name)] {
+			d.Changes = append(d.Changes, fmt.Sprintf("column %s %s exists on the target only", t.name, t.datatype))
+		}
+	}
+}
+
+// diffObjects compares the keys, constraints and indexes by their definition, the names may
+// differ between the source and the target
+func (m *Migrater) diffObjects(d *TableDiff) error {
+	sourceObjects, err := catalogObjects(m.SourceDB, m.SourceTable)
+	if err != nil {
+		return err
+	}
+	targetObjects, err := catalogObjects(m.TargetDB, m.TargetTable)
+	if err != nil {
+		return err
+	}
+	targetDefs := map[string]bool{}
+	for _, o := range targetObjects {
+		targetDefs[o.comparable()] = true
+	}
+	sourceDefs := map[string]bool{}
+	source, target := unqualified(m.SourceTable), unqualified(m.TargetTable)
+	for _, o := range sourceObjects {
+		sourceDefs[o.comparable()] = true
+		if targetDefs[o.comparable()] {
+			continue
+		}
+		d.Changes = append(d.Changes, fmt.Sprintf("%s %s is missing on the target: %s", o.kindName(), o.name, o.definition))
+		referenced := ""
+		if o.kind == "f" {
+			referenced = o.referenced
+			if o.selfReference {
+				referenced = m.TargetTable
+			}
+		}
+		d.DDL = append(d.DDL, o.createSQL(m.TargetTable, renamed(o.name, source, target), referenced))
+	}
+	for _, o := range targetObjects {
+		if !sourceDefs[o.comparable()] {
+			d.Changes = append(d.Changes, fmt.Sprintf("%s %s exists on the target only: %s", o.kindName(), o.name, o.definition))
+		}
+	}
+	return nil
+}
+
+// comparable is the definition of the object without its name and table
+func (o tableObject) comparable() string {
+	if o.kind == indexKind {
+		return indexDefinition.ReplaceAllString(o.definition, "${1}${2}${3}")
+	}
+	definition := o.definition
+	if o.selfReference {
+		definition = references.ReplaceAllLiteralString(definition, "REFERENCES (")
+	}
+	return o.kind + " " + definition
+}
+
+func (o tableObject) kindName() string {
+	switch o.kind {
+	case "p":
+		return "primary key"
+	case "u":
+		return "unique constraint"
+	case "x":
+		return "exclusion constraint"
+	case "c":
+		return "check constraint"
+	case "f":
+		return "foreign key"
+	}
+	return "index"
+}
+
+// WriteDiff prints the differences per table, tables without differences are listed as equal
+func WriteDiff(w io.Writer, diffs []TableDiff) error {
+	for _, d := range diffs {
+		if len(d.Changes) == 0 {
+			if _, err := fmt.Fprintf(w, "%s -> %s: no differences\n", d.Source, d.Target); err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Fprintf(w, "%s -> %s:\n", d.Source, d.Target)
+		for _, change := range d.Changes {
+			if _, err := fmt.Fprintf(w, "  %s\n", change); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WriteDDL prints the statements bringing the target tables in line with the source
+func WriteDDL(w io.Writer, diffs []TableDiff) error {
+	for _, d := range diffs {
+		if len(d.DDL) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "-- %s -> %s\n", d.Source, d.Target)
+		for _, stmt := range d.DDL {
+			if _, err := fmt.Fprintf(w, "%s;\n", stmt); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
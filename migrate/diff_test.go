@@ -0,0 +1,111 @@
+package migrate
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestDiffColumns(t *testing.T) {
+	source := []catalogColumn{
+		{name: "id", datatype: "bigint", notNull: true},
+		{name: "Name", datatype: "text"},
+		{name: "status", datatype: "text", def: "'new'::text"},
+	}
+	target := []catalogColumn{
+		{name: "id", datatype: "integer"},
+		{name: "name", datatype: "text"},
+		{name: "legacy", datatype: "text"},
+	}
+	tests := []struct {
+		typed   bool
+		changes []string
+		ddl     []string
+	}{
+		{
+			typed: true,
+			changes: []string{
+				"column id is bigint in the source and integer on the target",
+				"column id is not null in the source only",
+				"column status text is missing on the target",
+				"column legacy text exists on the target only",
+			},
+			ddl: []string{
+				"ALTER TABLE t ALTER COLUMN id TYPE bigint USING id::bigint",
+				"ALTER TABLE t ALTER COLUMN id SET NOT NULL",
+				"ALTER TABLE t ADD COLUMN status text DEFAULT 'new'::text",
+			},
+		},
+		{
+			// a file source only has names to compare
+			typed: false,
+			changes: []string{
+				"column status text is missing on the target",
+				"column legacy text exists on the target only",
+			},
+			ddl: []string{"ALTER TABLE t ADD COLUMN status text DEFAULT 'new'::text"},
+		},
+	}
+	for _, tt := range tests {
+		m := &Migrater{TargetTable: "t"}
+		var d TableDiff
+		m.diffColumns(&d, source, target, tt.typed)
+		if !reflect.DeepEqual(d.Changes, tt.changes) || !reflect.DeepEqual(d.DDL, tt.ddl) {
+			t.Errorf("typed %v: changes %q ddl %q, want %q and %q", tt.typed, d.Changes, d.DDL, tt.changes, tt.ddl)
+		}
+	}
+}
+
+func TestComparable(t *testing.T) {
+	tests := []struct {
+		a, b tableObject
+		same bool
+	}{
+		{
+			a:    tableObject{name: "a_idx", kind: indexKind, definition: "CREATE INDEX a_idx ON public.a USING btree (x)"},
+			b:    tableObject{name: "b_idx", kind: indexKind, definition: "CREATE INDEX b_idx ON sales.b USING btree (x)"},
+			same: true,
+		},
+		{
+			a:    tableObject{name: "a_idx", kind: indexKind, definition: "CREATE UNIQUE INDEX a_idx ON a USING btree (x)"},
+			b:    tableObject{name: "b_idx", kind: indexKind, definition: "CREATE INDEX b_idx ON b USING btree (x)"},
+			same: false,
+		},
+		{
+			a:    tableObject{kind: "f", definition: "FOREIGN KEY (p) REFERENCES a(id)", selfReference: true},
+			b:    tableObject{kind: "f", definition: "FOREIGN KEY (p) REFERENCES b(id)", selfReference: true},
+			same: true,
+		},
+		{
+			a:    tableObject{kind: "u", definition: "UNIQUE (x)"},
+			b:    tableObject{kind: "p", definition: "UNIQUE (x)"},
+			same: false,
+		},
+	}
+	for _, tt := range tests {
+		if same := tt.a.comparable() == tt.b.comparable(); same != tt.same {
+			t.Errorf("%q and %q: same %v, want %v", tt.a.definition, tt.b.definition, same, tt.same)
+		}
+	}
+}
+
+func TestWriteDiffAndDDL(t *testing.T) {
+	diffs := []TableDiff{
+		{Source: "a", Target: "a"},
+		{Source: "b", Target: "b", Changes: []string{"column x text is missing on the target"}, DDL: []string{"ALTER TABLE b ADD COLUMN x text"}},
+	}
+	var out bytes.Buffer
+	if err := WriteDiff(&out, diffs); err != nil {
+		t.Fatal(err)
+	}
+	if want := "a -> a: no differences\nb -> b:\n  column x text is missing on the target\n"; out.String() != want {
+		t.Errorf("WriteDiff %q, want %q", out.String(), want)
+	}
+	out.Reset()
+	if err := WriteDDL(&out, diffs); err != nil {
+		t.Fatal(err)
+	}
+	if want := "-- b -> b\nALTER TABLE b ADD COLUMN x text;\n"; out.String() != want {
+		t.Errorf("WriteDDL %q, want %q", out.String(), want)
+	}
+}
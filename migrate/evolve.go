@@ -0,0 +1,333 @@
+package migrate
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Policies for a target table whose columns differ from the source
+const (
+	// SchemaFail stops with the differences when the table lacks source columns or has columns
+	// of another type
+	SchemaFail = "fail"
+	// SchemaAdd adds the missing columns to the table
+	SchemaAdd = "add"
+	// SchemaWiden adds the missing columns and widens the columns too narrow for the source
+	SchemaWiden = "widen"
+	// SchemaIgnore leaves the source columns missing from the table out of the migration
+	SchemaIgnore = "ignore"
+)
+
+// schemaDiff is the difference between the source columns and an existing target table
+type schemaDiff struct {
+	// missing are the source columns the table does not have, with their source type
+	missing []string
+	types   map[string]string
+	// narrow maps the columns of the table narrower than the source to the wider type
+	narrow map[string]string
+	// mismatched describes the columns of a different type which cannot be widened
+	mismatched []string
+}
+
+// reconcileSchema compares the source columns with the existing target table and applies the
+// schema policy. It returns the columns to write.
+func (m *Migrater) reconcileSchema(table string, cols []string) ([]string, error) {
+	diff, err := m.diffSchema(table, cols)
+	if err != nil {
+		return nil, err
+	}
+	if m.schemaPolicy() == SchemaFail {
+		if problems := diff.problems(); len(problems) > 0 {
+			return nil, fmt.Errorf("Table %s does not match the source (%s), set target.schema_policy to add , widen or ignore",
+				table, strings.Join(problems, "; "))
+		}
+		return cols, nil
+	}
+	for _, msg := range diff.mismatched {
+		fmt.Fprintln(m.progress(), "Column type differs:", msg)
+	}
+	if m.schemaPolicy() != SchemaWiden {
+		for _, col := range sortedKeys(diff.narrow) {
+			fmt.Fprintf(m.progress(), "Column %s of %s is narrower than the source %s\n", col, table, diff.narrow[col])
+		}
+	}
+	if len(diff.missing) == 0 && len(diff.narrow) == 0 {
+		return cols, nil
+	}
+
+	if m.schemaPolicy() == SchemaIgnore {
+		fmt.Fprintf(m.progress(), "Ignoring the columns missing from %s: %s\n", table, strings.Join(diff.missing, ", "))
+		return without(cols, diff.missing), nil
+	}
+	for _, stmt := range m.evolveSQL(table, diff) {
+		fmt.Fprintln(m.progress(), "Running", stmt)
+		if _, err = m.target().Exec(stmt); err != nil {
+			return nil, err
+		}
+	}
+	return cols, nil
+}
+
+// problems lists the differences the fail policy stops on: the missing, narrower and
+// mismatched columns
+func (d schemaDiff) problems() []string {
+	var problems []string
+	if len(d.missing) > 0 {
+		problems = append(problems, "no column "+strings.Join(d.missing, ", "))
+	}
+	for _, col := range sortedKeys(d.narrow) {
+		problems = append(problems, fmt.Sprintf("%s is narrower than the source %s", col, d.narrow[col]))
+	}
+	return append(problems, d.mismatched...)
+}
+
+func (m *Migrater) schemaPolicy() string {
+	if m.TargetSchemaPolicy == "" {
+		return SchemaFail
+	}
+	return m.TargetSchemaPolicy
+}
+
+// evolveSQL lists the statements bringing the table in line with the source under the policy
+func (m *Migrater) evolveSQL(table string, diff schemaDiff) []string {
+	var stmts []string
+	switch m.schemaPolicy() {
+	case SchemaAdd, SchemaWiden:
+		for _, col := range diff.missing {
+			stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, col, diff.types[col]))
+		}
+	}
+	if m.schemaPolicy() == SchemaWiden {
+		for _, col := range sortedKeys(diff.narrow) {
+			stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s", table, col, diff.narrow[col]))
+		}
+	}
+	return stmts
+}
+
+// diffSchema compares the source column types with the columns of table. Names are compared
+// without case as the unquoted names are folded by postgres.
+func (m *Migrater) diffSchema(table string, cols []string) (schemaDiff, error) {
+	diff := schemaDiff{types: map[string]string{}, narrow: map[string]string{}}
+	sourceTypes, err := m.sourceTypeNames(cols)
+	if err != nil {
+		return diff, err
+	}
+	targetColumns, err := catalogColumns(m.target(), table)
+	if err != nil {
+		return diff, err
+	}
+	targetTypes := map[string]string{}
+	for _, c := range targetColumns {
+		targetTypes[strings.ToLower(c.name)] = c.datatype
+	}
+
+	for _, col := range cols {
+		sourceType := sourceTypes[col]
+		targetType, ok := targetTypes[strings.ToLower(col)]
+		if !ok {
+			diff.missing = append(diff.missing, col)
+			diff.types[col] = sourceType
+			continue
+		}
+		// a file source has no types, anything is loaded as text
+		if m.SourceDB == nil || sourceType == targetType {
+			continue
+		}
+		if wide := widerType(sourceType, targetType); wide != "" {
+			diff.narrow[col] = wide
+		} else if !fits(sourceType, targetType) {
+			diff.mismatched = append(diff.mismatched, fmt.Sprintf("%s is %s in the source and %s in %s", col, sourceType, targetType, table))
+		}
+	}
+	return diff, nil
+}
+
+// sourceTypeNames returns the postgres type of every source column, text for a file source
+func (m *Migrater) sourceTypeNames(cols []string) (map[string]string, error) {
+	types := map[string]string{}
+	switch {
+	case m.copyCatalog():
+		columns, err := catalogColumns(m.SourceDB, m.SourceTable)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range columns {
+			types[c.name] = c.datatype
+		}
+	case m.SourceDB != nil:
+		columns, err := m.sourceColumnTypes()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range columns {
+			types[c.Name()] = typeName(c.DatabaseTypeName(), c)
+		}
+	default:
+		// the columns of typed files keep their type
+		for _, col := range cols {
+			types[col] = "text"
+			if t, ok := m.fileTypes[col]; ok {
+				types[col] = t
+			}
+		}
+	}
+	return types, nil
+}
+
+// driverTypes maps the type names of the driver to those of format_type
+var driverTypes = map[string]string{
+	"INT2":        "smallint",
+	"INT4":        "integer",
+	"INT8":        "bigint",
+	"FLOAT4":      "real",
+	"FLOAT8":      "double precision",
+	"BOOL":        "boolean",
+	"TEXT":        "text",
+	"DATE":        "date",
+	"TIMESTAMP":   "timestamp without time zone",
+	"TIMESTAMPTZ": "timestamp with time zone",
+	"JSON":        "json",
+	"JSONB":       "jsonb",
+	"UUID":        "uuid",
+	"BYTEA":       "bytea",
+}
+
+// columnSizes is the part of *sql.ColumnType telling the length and precision of a column
+type columnSizes interface {
+	Length() (int64, bool)
+	DecimalSize() (int64, int64, bool)
+}
+
+func typeName(name string, sizes columnSizes) string {
+	switch name {
+	case "VARCHAR":
+		if n, ok := sizes.Length(); ok {
+			return fmt.Sprintf("character varying(%d)", n)
+		}
+		return "character varying"
+	case "BPCHAR":
+		if n, ok := sizes.Length(); ok {
+			return fmt.Sprintf("character(%d)", n)
+		}
+		return "character"
+	case "NUMERIC":
+		if p, s, ok := sizes.DecimalSize(); ok {
+			return fmt.Sprintf("numeric(%d,%d)", p, s)
+		}
+		return "numeric"
+	}
+	if t, ok := driverTypes[name]; ok {
+		return t
+	}
+	return strings.ToLower(name)
+}
+
+// typeRanks orders the types of a family from narrow to wide
+var typeRanks = map[string]int{
+	"smallint":         1,
+	"integer":          2,
+	"bigint":           3,
+	"numeric":          4,
+	"real":             11,
+	"double precision": 12,
+}
+
+// sizedType splits character varying(20) or numeric(10,2) into its name and sizes
+var sizedType = regexp.MustCompile(`^([a-z ]+)(?:\((\d+)(?:,(\d+))?\))?$`)
+
+// widerType returns the type the target column is widened to for the source values, empty
+// when the target is wide enough or the types are not of the same family
+func widerType(source, target string) string {
+	sr, sok := typeRanks[source]
+	tr, tok := typeRanks[target]
+	if sok && tok {
+		if sr/10 == tr/10 && sr > tr {
+			return source
+		}
+		return ""
+	}
+
+	s, t := sizedType.FindStringSubmatch(source), sizedType.FindStringSubmatch(target)
+	if s == nil || t == nil {
+		return ""
+	}
+	switch {
+	case t[1] == "character varying" && source == "text":
+		return "text"
+	case t[1] == "character varying" && s[1] == "character varying":
+		if s[2] == "" {
+			return source
+		}
+		if t[2] != "" && atoi(s[2]) > atoi(t[2]) {
+			return source
+		}
+	case t[1] == "numeric" && s[1] == "numeric" && t[2] != "":
+		if s[2] == "" {
+			return "numeric"
+		}
+		// keep room for the integer digits and the scale of both
+		scale := maxInt(atoi(s[3]), atoi(t[3]))
+		digits := maxInt(atoi(s[2])-atoi(s[3]), atoi(t[2])-atoi(t[3]))
+		if wide := fmt.Sprintf("numeric(%d,%d)", digits+scale, scale); wide != target {
+			return wide
+		}
+	}
+	return ""
+}
+
+// fits tells if the source values load into the target column without widening it
+func fits(source, target string) bool {
+	if target == "text" {
+		return true
+	}
+	sr, sok := typeRanks[source]
+	tr, tok := typeRanks[target]
+	if sok && tok && sr <= tr && (sr/10 == tr/10 || tr == typeRanks["double precision"]) {
+		return true
+	}
+	s, t := sizedType.FindStringSubmatch(source), sizedType.FindStringSubmatch(target)
+	return s != nil && t != nil && s[1] == t[1]
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// without returns cols without the excluded columns
+func without(cols, excluded []string) []string {
+	var kept []string
+	for _, col := range cols {
+		if !contains(excluded, col) {
+			kept = append(kept, col)
+		}
+	}
+	return kept
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return sortedCopy(keys)
+}
@@ -0,0 +1,117 @@
+package migrate
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWiderType(t *testing.T) {
+	tests := []struct {
+		source, target, want string
+	}{
+		{"bigint", "integer", "bigint"},
+		{"integer", "bigint", ""},
+		{"double precision", "real", "double precision"},
+		// integers and floats are not widened into each other
+		{"integer", "real", ""},
+		{"text", "character varying(20)", "text"},
+		{"character varying(50)", "character varying(20)", "character varying(50)"},
+		{"character varying", "character varying(20)", "character varying"},
+		{"character varying(10)", "character varying(20)", ""},
+		{"numeric", "numeric(10,2)", "numeric"},
+		{"numeric(12,4)", "numeric(10,2)", "numeric(12,4)"},
+		// room for the integer digits of the target and the scale of the source
+		{"numeric(10,4)", "numeric(10,2)", "numeric(12,4)"},
+		{"numeric(8,2)", "numeric(10,2)", ""},
+		{"date", "timestamp without time zone", ""},
+	}
+	for _, tt := range tests {
+		if got := widerType(tt.source, tt.target); got != tt.want {
+			t.Errorf("widerType(%s, %s) = %q, want %q", tt.source, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestFits(t *testing.T) {
+	tests := []struct {
+		source, target string
+		want           bool
+	}{
+		{"integer", "text", true},
+		{"smallint", "bigint", true},
+		{"bigint", "double precision", true},
+		{"real", "integer", false},
+		{"character varying(10)", "character varying(20)", true},
+		{"date", "integer", false},
+	}
+	for _, tt := range tests {
+		if got := fits(tt.source, tt.target); got != tt.want {
+			t.Errorf("fits(%s, %s) = %v, want %v", tt.source, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestEvolveSQL(t *testing.T) {
+	diff := schemaDiff{
+		missing: []string{"email"},
+		types:   map[string]string{"email": "text"},
+		narrow:  map[string]string{"id": "bigint", "amount": "numeric(12,2)"},
+	}
+	tests := []struct {
+		policy string
+		want   []string
+	}{
+		{SchemaFail, nil},
+		{SchemaIgnore, nil},
+		{SchemaAdd, []string{"ALTER TABLE t ADD COLUMN email text"}},
+		{SchemaWiden, []string{
+			"ALTER TABLE t ADD COLUMN email text",
+			"ALTER TABLE t ALTER COLUMN amount TYPE numeric(12,2)",
+			"ALTER TABLE t ALTER COLUMN id TYPE bigint",
+		}},
+	}
+	for _, tt := range tests {
+		m := &Migrater{TargetSchemaPolicy: tt.policy}
+		if got := m.evolveSQL("t", diff); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %q, want %q", tt.policy, got, tt.want)
+		}
+	}
+	if got := without([]string{"id", "email", "name"}, diff.missing); !reflect.DeepEqual(got, []string{"id", "name"}) {
+		t.Errorf("without = %v", got)
+	}
+}
+
+func TestSchemaProblems(t *testing.T) {
+	tests := []struct {
+		name string
+		diff schemaDiff
+		want []string
+	}{
+		{name: "same"},
+		{
+			name: "all",
+			diff: schemaDiff{
+				missing:    []string{"email", "phone"},
+				narrow:     map[string]string{"id": "bigint", "amount": "numeric(12,2)"},
+				mismatched: []string{"born is date in the source and text in t"},
+			},
+			want: []string{
+				"no column email, phone",
+				"amount is narrower than the source numeric(12,2)",
+				"id is narrower than the source bigint",
+				"born is date in the source and text in t",
+			},
+		},
+		// a type mismatch alone fails too
+		{
+			name: "mismatched",
+			diff: schemaDiff{mismatched: []string{"born is date in the source and text in t"}},
+			want: []string{"born is date in the source and text in t"},
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.diff.problems(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package migrate
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/PrakharSrivastav/migrater/fixedwidth"
+)
+
+// fixedWidthNewlines keeps the values of a fixed width target on their line
+var fixedWidthNewlines = strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ")
+
+// fixedWidthReader reads a record from every line of a fixed width file, the fields are sliced
+// at the positions of the layout. A blank field is NULL.
+type fixedWidthReader struct {
+	reader *bufio.Reader
+	layout fixedwidth.Layout
+	line   int
+}
+
+func (m *Migrater) newFixedWidthReader() *fixedWidthReader {
+	m.fileTypes = map[string]string{}
+	for _, f := range m.SourceFileLayout {
+		m.fileTypes[f.Name] = f.Type
+	}
+	return &fixedWidthReader{reader: m.SourceFile, layout: m.SourceFileLayout}
+}
+
+func (f *fixedWidthReader) Columns() []string { return f.layout.Names() }
+
+func (f *fixedWidthReader) Read() (map[string]interface{}, error) {
+	for {
+		line, err := f.reader.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return nil, io.EOF
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		f.line++
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		// blank lines, such as a trailing one, hold no record
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		values, ok := f.layout.Split(line)
+		mp := make(map[string]interface{}, len(values))
+		for i, field := range f.layout {
+			mp[field.Name] = nil
+			if ok[i] {
+				mp[field.Name] = values[i]
+			}
+		}
+		return mp, nil
+	}
+}
+
+func (f *fixedWidthReader) Close() error { return nil }
+
+// fixedWidthWriter writes a line for every record with the values padded to the fields of the
+// layout. Source columns missing from the layout are left out.
+type fixedWidthWriter struct {
+	writer *bufio.Writer
+	layout fixedwidth.Layout
+}
+
+func (m *Migrater) newFixedWidthWriter(cols []string) (*fixedWidthWriter, error) {
+	for _, f := range m.TargetFileLayout {
+		if !contains(cols, f.Name) {
+			return nil, fmt.Errorf("Field %s of the target layout is not a source column", f.Name)
+		}
+	}
+	return &fixedWidthWriter{writer: m.TargetFile, layout: m.TargetFileLayout}, nil
+}
+
+func (f *fixedWidthWriter) Write(records []map[string]interface{}) error {
+	for _, record := range records {
+		values := make([]*string, len(f.layout))
+		for i, field := range f.layout {
+			if v := record[field.Name]; v != nil {
+				s := fixedWidthText(field.Type, v)
+				values[i] = &s
+			}
+		}
+		line, err := f.layout.Join(values)
+		if err != nil {
+			return err
+		}
+		if _, err = fmt.Fprintln(f.writer, line); err != nil {
+			return err
+		}
+	}
+	return f.writer.Flush()
+}
+
+func (f *fixedWidthWriter) Close() error { return f.writer.Flush() }
+
+// fixedWidthText formats a value for a field of the type, dates and timestamps in the ISO
+// format postgres reads back
+func fixedWidthText(datatype string, v interface{}) string {
+	switch val := v.(type) {
+	case bool:
+		if val {
+			return "t"
+		}
+		return "f"
+	case time.Time:
+		switch binaryType(datatype) {
+		case "date":
+			return val.Format("2006-01-02")
+		case "timestamp without time zone":
+			return val.Format("2006-01-02 15:04:05.999999")
+		}
+		return val.Format("2006-01-02 15:04:05.999999Z07:00")
+	}
+	return fixedWidthNewlines.Replace(toString(v))
+}
@@ -0,0 +1,84 @@
+package migrate
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PrakharSrivastav/migrater/fixedwidth"
+)
+
+func TestFixedWidthRoundTrip(t *testing.T) {
+	layout, err := fixedwidth.Parse([]interface{}{
+		"id:4:integer",
+		map[string]interface{}{"name": "balance", "length": 8, "type": "numeric(10,2)", "pad": "0"},
+		"name:6",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, out := fileMigrater("csv", "id,name,balance,extra\n1,alice,-12.50,x\n22,bob,,y\n333,\"a\nb\",0.5,z\n", "fixedwidth")
+	m.TargetFileLayout = layout
+	if err = m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	// the extra column is left out, the empty balance is blank and the newline of a value
+	// becomes a space
+	want := "   1-0012.50alice \n  22        bob   \n 333000000.5a b   \n"
+	if out.String() != want {
+		t.Fatalf("got %q, want %q", out.String(), want)
+	}
+
+	m, back := fileMigrater("fixedwidth", out.String()+"\n", "csv")
+	m.SourceFileLayout = layout
+	if err = m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if want := "id,balance,name\n1,-12.50,alice\n22,,bob\n333,0.5,a b\n"; back.String() != want {
+		t.Errorf("got %q, want %q", back.String(), want)
+	}
+}
+
+func TestFixedWidthWriterErrors(t *testing.T) {
+	tests := []struct {
+		layout []string
+		source string
+		want   string
+	}{
+		{[]string{"id:4", "missing:3"}, "id\n1\n", "Field missing of the target layout is not a source column"},
+		{[]string{"id:2:integer"}, "id\n123\n", "Value 123 does not fit"},
+	}
+	for _, tt := range tests {
+		layout, err := fixedwidth.Parse(tt.layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, _ := fileMigrater("csv", tt.source, "fixedwidth")
+		m.TargetFileLayout = layout
+		if err = m.Migrate(); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%v: got %v, want an error containing %q", tt.layout, err, tt.want)
+		}
+	}
+}
+
+func TestFixedWidthText(t *testing.T) {
+	at := time.Date(2024, 3, 1, 10, 30, 0, 500000000, time.FixedZone("", 2*3600))
+	tests := []struct {
+		datatype string
+		v        interface{}
+		want     string
+	}{
+		{"boolean", true, "t"},
+		{"boolean", false, "f"},
+		{"date", at, "2024-03-01"},
+		{"timestamp without time zone", at, "2024-03-01 10:30:00.5"},
+		{"timestamp with time zone", at, "2024-03-01 10:30:00.5+02:00"},
+		{"text", "a\r\nb\nc\rd", "a b c d"},
+		{"bigint", int64(-7), "-7"},
+	}
+	for _, tt := range tests {
+		if got := fixedWidthText(tt.datatype, tt.v); got != tt.want {
+			t.Errorf("fixedWidthText(%s, %v) = %q, want %q", tt.datatype, tt.v, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package migrate
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Handling of the arrays of a hierarchical record when it is flattened
+const (
+	// ArraysIndex turns the elements of an array into columns numbered from 0, items.0.sku
+	ArraysIndex = "index"
+	// ArraysExplode turns the elements of an array into rows repeating the other columns
+	ArraysExplode = "explode"
+)
+
+const (
+	// pathSeparator joins the element names of a flattened column, address.city
+	pathSeparator = "."
+	// attrPrefix marks the attributes of an xml element, @id
+	attrPrefix = "@"
+	// textKey holds the text of an xml element which also has attributes
+	textKey = "#text"
+)
+
+// flatten turns a hierarchical record into rows of path named columns. The text of an element
+// with attributes keeps the path of the element. Every row of an exploded array repeats the
+// columns outside of it, several arrays give every combination of their elements.
+func flatten(record map[string]interface{}, arrays string) []map[string]interface{} {
+	// no record has no rows, an empty one is a row without columns
+	if record == nil {
+		return nil
+	}
+	return flattenValue("", record, arrays)
+}
+
+func flattenValue(path string, v interface{}, arrays string) []map[string]interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		if len(val) == 0 && path == "" {
+			return []map[string]interface{}{{}}
+		}
+		if len(val) == 0 {
+			return []map[string]interface{}{{path: nil}}
+		}
+		rows := []map[string]interface{}{{}}
+		for _, key := range sortedFields(val) {
+			child := joinPath(path, key)
+			if key == textKey && path != "" {
+				child = path
+			}
+			rows = crossRows(rows, flattenValue(child, val[key], arrays))
+		}
+		return rows
+	case []interface{}:
+		if len(val) == 0 {
+			return []map[string]interface{}{{path: nil}}
+		}
+		if arrays == ArraysExplode {
+			var rows []map[string]interface{}
+			for _, item := range val {
+				rows = append(rows, flattenValue(path, item, arrays)...)
+			}
+			return rows
+		}
+		rows := []map[string]interface{}{{}}
+		for i, item := range val {
+			rows = crossRows(rows, flattenValue(joinPath(path, strconv.Itoa(i)), item, arrays))
+		}
+		return rows
+	}
+	return []map[string]interface{}{{path: v}}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + pathSeparator + key
+}
+
+// crossRows combines every row of a with every row of b
+func crossRows(a, b []map[string]interface{}) []map[string]interface{} {
+	if len(b) == 1 {
+		for _, row := range a {
+			for k, v := range b[0] {
+				row[k] = v
+			}
+		}
+		return a
+	}
+	rows := make([]map[string]interface{}, 0, len(a)*len(b))
+	for _, left := range a {
+		for _, right := range b {
+			row := make(map[string]interface{}, len(left)+len(right))
+			for k, v := range left {
+				row[k] = v
+			}
+			for k, v := range right {
+				row[k] = v
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+func sortedFields(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// unflatten is the inverse of flatten with indexed arrays: path named columns become nested
+// elements, numbered elements become arrays and @ columns attributes. A column which is also
+// the parent of other columns becomes the text of its element.
+func unflatten(record map[string]interface{}) map[string]interface{} {
+	root := map[string]interface{}{}
+	for _, col := range sortedFields(record) {
+		parts := strings.Split(col, pathSeparator)
+		node := root
+		for _, part := range parts[:len(parts)-1] {
+			child, ok := node[part].(map[string]interface{})
+			if !ok {
+				child = map[string]interface{}{}
+				if v, set := node[part]; set {
+					child[textKey] = v
+				}
+				node[part] = child
+			}
+			node = child
+		}
+		last := parts[len(parts)-1]
+		if child, ok := node[last].(map[string]interface{}); ok {
+			child[textKey] = record[col]
+			continue
+		}
+		node[last] = record[col]
+	}
+	for k, child := range root {
+		root[k] = toArrays(child)
+	}
+	return root
+}
+
+// toArrays turns the maps numbered from 0 without gaps into arrays
+func toArrays(v interface{}) interface{} {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return v
+	}
+	for k, child := range m {
+		m[k] = toArrays(child)
+	}
+	items := make([]interface{}, len(m))
+	for k, child := range m {
+		i, err := strconv.Atoi(k)
+		if err != nil || i < 0 || i >= len(m) || strconv.Itoa(i) != k {
+			return m
+		}
+		items[i] = child
+	}
+	if len(items) == 0 {
+		return m
+	}
+	return items
+}
+
+// flatRows holds the flattened rows of every record of a file in a spool file. The records of a
+// hierarchical file need not have the same elements, they are read ahead so the columns are
+// those of all the records and not only of the first one.
+type flatRows struct {
+	file    *os.File
+	decoder *gob.Decoder
+	columns []string
+}
+
+// spoolRows reads the rows of the records until next returns io.EOF. A file without records
+// has no rows and no columns.
+func spoolRows(next func() ([]map[string]interface{}, error)) (*flatRows, error) {
+	file, err := ioutil.TempFile("", "migrater-records")
+	if err != nil {
+		return nil, err
+	}
+	f := &flatRows{file: file}
+	w := bufio.NewWriter(file)
+	encoder := gob.NewEncoder(w)
+	seen := map[string]bool{}
+	for {
+		rows, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		for _, row := range rows {
+			for k := range row {
+				if !seen[k] {
+					seen[k] = true
+					f.columns = append(f.columns, k)
+				}
+			}
+			if err = encoder.Encode(row); err != nil {
+				f.Close()
+				return nil, fmt.Errorf("Error spooling record (%s)", err)
+			}
+		}
+	}
+	if err = w.Flush(); err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	sort.Strings(f.columns)
+	f.decoder = gob.NewDecoder(bufio.NewReader(file))
+	return f, nil
+}
+
+// Read returns the next row, a column missing from the record of the row is NULL
+func (f *flatRows) Read() (map[string]interface{}, error) {
+	var row map[string]interface{}
+	if err := f.decoder.Decode(&row); err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, fmt.Errorf("Error reading spooled record (%s)", err)
+	}
+	return row, nil
+}
+
+// Close removes the spool file
+func (f *flatRows) Close() error {
+	f.file.Close()
+	return os.Remove(f.file.Name())
+}
@@ -0,0 +1,178 @@
+package migrate
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFlatten(t *testing.T) {
+	tests := []struct {
+		name   string
+		record map[string]interface{}
+		arrays string
+		want   []map[string]interface{}
+	}{
+		{name: "nil", record: nil, want: nil},
+		{name: "empty", record: map[string]interface{}{}, want: []map[string]interface{}{{}}},
+		{
+			name: "nested",
+			record: map[string]interface{}{
+				"id":      "1",
+				"address": map[string]interface{}{"city": "Oslo", "@type": "home", "zip": map[string]interface{}{}},
+				"note":    map[string]interface{}{"#text": "hi", "@lang": "en"},
+			},
+			want: []map[string]interface{}{{
+				"id": "1", "address.city": "Oslo", "address.@type": "home", "address.zip": nil,
+				"note": "hi", "note.@lang": "en",
+			}},
+		},
+		{
+			name:   "index",
+			record: map[string]interface{}{"id": "1", "items": []interface{}{map[string]interface{}{"sku": "a"}, "b"}, "tags": []interface{}{}},
+			arrays: ArraysIndex,
+			want:   []map[string]interface{}{{"id": "1", "items.0.sku": "a", "items.1": "b", "tags": nil}},
+		},
+		{
+			name: "explode",
+			record: map[string]interface{}{
+				"id":    "1",
+				"items": []interface{}{map[string]interface{}{"sku": "a"}, map[string]interface{}{"sku": "b"}},
+				"tags":  []interface{}{"x", "y"},
+			},
+			arrays: ArraysExplode,
+			want: []map[string]interface{}{
+				{"id": "1", "items.sku": "a", "tags": "x"},
+				{"id": "1", "items.sku": "a", "tags": "y"},
+				{"id": "1", "items.sku": "b", "tags": "x"},
+				{"id": "1", "items.sku": "b", "tags": "y"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		if got := flatten(tt.record, tt.arrays); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUnflatten(t *testing.T) {
+	tests := []struct {
+		name   string
+		record map[string]interface{}
+		want   map[string]interface{}
+	}{
+		{name: "flat", record: map[string]interface{}{"id": 1}, want: map[string]interface{}{"id": 1}},
+		{
+			name:   "nested",
+			record: map[string]interface{}{"address.city": "Oslo", "address.@type": "home"},
+			want:   map[string]interface{}{"address": map[string]interface{}{"city": "Oslo", "@type": "home"}},
+		},
+		{
+			name:   "arrays",
+			record: map[string]interface{}{"items.0.sku": "a", "items.1.sku": "b", "tags.0": "x"},
+			want: map[string]interface{}{
+				"items": []interface{}{map[string]interface{}{"sku": "a"}, map[string]interface{}{"sku": "b"}},
+				"tags":  []interface{}{"x"},
+			},
+		},
+		{
+			// numbers with a gap or not from 0 stay elements
+			name:   "not arrays",
+			record: map[string]interface{}{"a.1": "x", "b.0": "y", "b.2": "z"},
+			want: map[string]interface{}{
+				"a": map[string]interface{}{"1": "x"},
+				"b": map[string]interface{}{"0": "y", "2": "z"},
+			},
+		},
+		{
+			name:   "text and attributes",
+			record: map[string]interface{}{"note": "hi", "note.@lang": "en"},
+			want:   map[string]interface{}{"note": map[string]interface{}{"#text": "hi", "@lang": "en"}},
+		},
+	}
+	for _, tt := range tests {
+		if got := unflatten(tt.record); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// a record flattened with indexed arrays comes back as it was
+	record := map[string]interface{}{
+		"id":    "1",
+		"items": []interface{}{map[string]interface{}{"sku": "a", "@qty": "2"}, map[string]interface{}{"sku": "b"}},
+	}
+	rows := flatten(record, ArraysIndex)
+	if got := unflatten(rows[0]); len(rows) != 1 || !reflect.DeepEqual(got, record) {
+		t.Errorf("round trip: got %v, want %v", got, record)
+	}
+}
+
+func TestHierarchicalReaders(t *testing.T) {
+	tests := []struct {
+		name       string
+		sourceType string
+		source     string
+		arrays     string
+		cols       []string
+		want       []map[string]interface{}
+	}{
+		{name: "empty xml", sourceType: "xml", source: ""},
+		{name: "empty json", sourceType: "json", source: "  "},
+		{name: "empty json array", sourceType: "json", source: "[]"},
+		{
+			// the columns are those of all the records, not only of the first one
+			name:       "xml columns",
+			sourceType: "xml",
+			source:     "<Root><id>1</id></Root><Root><id>2</id><name>b</name></Root>",
+			cols:       []string{"id", "name"},
+			want:       []map[string]interface{}{{"id": "1"}, {"id": "2", "name": "b"}},
+		},
+		{
+			name:       "json columns",
+			sourceType: "json",
+			source:     `[{"id": 1}, {"id": 2.5, "address": {"city": "Oslo"}}, {}]`,
+			cols:       []string{"address.city", "id"},
+			want: []map[string]interface{}{
+				{"id": int64(1)}, {"id": 2.5, "address.city": "Oslo"}, nil,
+			},
+		},
+		{
+			name:       "json lines exploded",
+			sourceType: "json",
+			source:     "{\"id\": 1, \"tags\": [\"x\", \"y\"]}\n{\"id\": 2, \"ok\": true}\n",
+			arrays:     ArraysExplode,
+			cols:       []string{"id", "ok", "tags"},
+			want: []map[string]interface{}{
+				{"id": int64(1), "tags": "x"}, {"id": int64(1), "tags": "y"}, {"id": int64(2), "ok": true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		m := &Migrater{SourceFileType: tt.sourceType, SourceFile: bufio.NewReader(strings.NewReader(tt.source)), SourceFileArrays: tt.arrays}
+		r, err := m.newReader()
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if len(r.Columns()) != len(tt.cols) || (len(tt.cols) > 0 && !reflect.DeepEqual(r.Columns(), tt.cols)) {
+			t.Errorf("%s: columns %v, want %v", tt.name, r.Columns(), tt.cols)
+		}
+		var got []map[string]interface{}
+		for {
+			record, err := r.Read()
+			if err != nil {
+				break
+			}
+			if len(record) == 0 {
+				record = nil
+			}
+			got = append(got, record)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+		r.Close()
+	}
+}
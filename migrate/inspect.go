@@ -0,0 +1,51 @@
+package migrate
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// Inspect prints the columns of the source. Database columns are listed with their type,
+// nullability and size, file columns are text unless the file is typed.
+func (m *Migrater) Inspect(w io.Writer) error {
+	defer m.cleanUp()
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "COLUMN\tTYPE\tNULLABLE\tSIZE")
+
+	if m.SourceDB == nil {
+		reader, err := m.newReader()
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		for _, col := range reader.Columns() {
+			datatype := "text"
+			if t, ok := m.fileTypes[col]; ok {
+				datatype = t
+			}
+			fmt.Fprintf(tw, "%s\t%s\tunknown\t\n", col, datatype)
+		}
+		return tw.Flush()
+	}
+
+	columns, err := m.sourceColumnTypes()
+	if err != nil {
+		return err
+	}
+	for _, col := range columns {
+		nullable := "unknown"
+		if null, ok := col.Nullable(); ok {
+			nullable = fmt.Sprint(null)
+		}
+		size := ""
+		if length, ok := col.Length(); ok {
+			size = fmt.Sprint(length)
+		}
+		if precision, scale, ok := col.DecimalSize(); ok {
+			size = fmt.Sprintf("%d,%d", precision, scale)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", col.Name(), col.DatabaseTypeName(), nullable, size)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,189 @@
+package migrate
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Job is a named migration which runs once all the jobs it depends on have succeeded
+type Job struct {
+	Name      string
+	DependsOn []string
+	Run       func() (Summary, error)
+}
+
+// JobResult is the outcome of a single job. A job is skipped when a job it depends on failed.
+type JobResult struct {
+	Name     string
+	Summary  Summary
+	Err      error
+	Skipped  bool
+	Duration time.Duration
+}
+
+// RunJobs runs the jobs in dependency order, running up to concurrency independent jobs at a
+// time. The results are returned in the order of the jobs.
+func RunJobs(jobs []Job, concurrency int) ([]JobResult, error) {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	index, err := jobIndex(jobs)
+	if err != nil {
+		return nil, err
+	}
+
+	// pending counts the unfinished dependencies, dependents is the reverse of DependsOn
+	pending := make([]int, len(jobs))
+	dependents := make([][]int, len(jobs))
+	for i, job := range jobs {
+		pending[i] = len(job.DependsOn)
+		for _, dep := range job.DependsOn {
+			dependents[index[dep]] = append(dependents[index[dep]], i)
+		}
+	}
+
+	results := make([]JobResult, len(jobs))
+	done := make(chan int)
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	start := func(i int) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			fmt.Printf("Starting job %s\n", jobs[i].Name)
+			began := time.Now()
+			summary, err := jobs[i].Run()
+			results[i] = JobResult{Name: jobs[i].Name, Summary: summary, Err: err, Duration: time.Since(began)}
+			done <- i
+		}()
+	}
+
+	// skip marks a job and everything depending on it as skipped
+	var skip func(i int, reason string)
+	skip = func(i int, reason string) {
+		if results[i].Skipped {
+			return
+		}
+		results[i] = JobResult{Name: jobs[i].Name, Skipped: true, Err: fmt.Errorf("skipped, %s", reason)}
+		for _, d := range dependents[i] {
+			skip(d, fmt.Sprintf("job %s did not run", jobs[i].Name))
+		}
+	}
+
+	running := 0
+	for i := range jobs {
+		if pending[i] == 0 {
+			start(i)
+			running++
+		}
+	}
+	for running > 0 {
+		i := <-done
+		running--
+		failed := results[i].Err != nil
+		for _, d := range dependents[i] {
+			if failed {
+				skip(d, fmt.Sprintf("job %s failed", jobs[i].Name))
+				continue
+			}
+			pending[d]--
+			if pending[d] == 0 && !results[d].Skipped {
+				start(d)
+				running++
+			}
+		}
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// ValidateJobs checks the job names are unique and the dependencies exist and do not form a cycle
+func ValidateJobs(jobs []Job) error {
+	_, err := jobIndex(jobs)
+	return err
+}
+
+// jobIndex maps the job names to their position, checking the names are unique and the
+// dependencies exist and do not form a cycle
+func jobIndex(jobs []Job) (map[string]int, error) {
+	index := map[string]int{}
+	for i, job := range jobs {
+		if job.Name == "" {
+			return nil, fmt.Errorf("Job %d has no name", i+1)
+		}
+		if _, ok := index[job.Name]; ok {
+			return nil, fmt.Errorf("Job %s is defined more than once", job.Name)
+		}
+		index[job.Name] = i
+	}
+	for _, job := range jobs {
+		for _, dep := range job.DependsOn {
+			if _, ok := index[dep]; !ok {
+				return nil, fmt.Errorf("Job %s depends on unknown job %s", job.Name, dep)
+			}
+		}
+	}
+
+	// depth first search, a job seen again while still on the path closes a cycle
+	const (
+		unvisited = iota
+		onPath
+		visited
+	)
+	state := make([]int, len(jobs))
+	var path []string
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch state[i] {
+		case onPath:
+			return fmt.Errorf("Jobs depend on each other: %s -> %s", strings.Join(path, " -> "), jobs[i].Name)
+		case visited:
+			return nil
+		}
+		state[i] = onPath
+		path = append(path, jobs[i].Name)
+		for _, dep := range jobs[i].DependsOn {
+			if err := visit(index[dep]); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[i] = visited
+		return nil
+	}
+	for i := range jobs {
+		if err := visit(i); err != nil {
+			return nil, err
+		}
+	}
+	return index, nil
+}
+
+// WriteJobResults prints one line per job with its outcome and counters
+func WriteJobResults(w io.Writer, results []JobResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "JOB\tSTATUS\tREAD\tWRITTEN\tDUPLICATES\tDURATION\tERROR")
+	for _, r := range results {
+		status := "ok"
+		switch {
+		case r.Skipped:
+			status = "skipped"
+		case r.Err != nil:
+			status = "failed"
+		}
+		msg := ""
+		if r.Err != nil {
+			msg = r.Err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%s\t%s\n", r.Name, status, r.Summary.RecordsRead,
+			r.Summary.RecordsWritten, r.Summary.DuplicatesDropped, r.Duration.Round(time.Millisecond), msg)
+	}
+	return tw.Flush()
+}
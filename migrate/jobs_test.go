@@ -0,0 +1,114 @@
+package migrate
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestValidateJobs(t *testing.T) {
+	tests := []struct {
+		name string
+		jobs []Job
+		err  string
+	}{
+		{name: "ok", jobs: []Job{{Name: "a"}, {Name: "b", DependsOn: []string{"a"}}}},
+		{name: "no name", jobs: []Job{{Name: "a"}, {}}, err: "Job 2 has no name"},
+		{name: "twice", jobs: []Job{{Name: "a"}, {Name: "a"}}, err: "Job a is defined more than once"},
+		{name: "unknown", jobs: []Job{{Name: "a", DependsOn: []string{"b"}}}, err: "Job a depends on unknown job b"},
+		{
+			name: "cycle",
+			jobs: []Job{{Name: "a", DependsOn: []string{"c"}}, {Name: "b", DependsOn: []string{"a"}}, {Name: "c", DependsOn: []string{"b"}}},
+			err:  "Jobs depend on each other: a -> c -> b -> a",
+		},
+		{name: "self", jobs: []Job{{Name: "a", DependsOn: []string{"a"}}}, err: "Jobs depend on each other: a -> a"},
+	}
+	for _, tt := range tests {
+		err := ValidateJobs(tt.jobs)
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestRunJobs(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	job := func(name string, fail bool, deps ...string) Job {
+		return Job{Name: name, DependsOn: deps, Run: func() (Summary, error) {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			if fail {
+				return Summary{}, errors.New("boom")
+			}
+			return Summary{RecordsRead: 1, RecordsWritten: 1}, nil
+		}}
+	}
+	jobs := []Job{
+		job("load", false, "extract"),
+		job("extract", false),
+		job("broken", true),
+		job("after_broken", false, "broken"),
+		job("after_after", false, "after_broken", "load"),
+	}
+	results, err := RunJobs(jobs, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ran := strings.Join(order, ",")
+	if len(order) != 3 || strings.Index(ran, "extract") > strings.Index(ran, "load") || !strings.Contains(ran, "broken") {
+		t.Errorf("ran %s, want extract before load and broken", ran)
+	}
+	want := []struct {
+		name    string
+		failed  bool
+		skipped bool
+	}{
+		{"load", false, false},
+		{"extract", false, false},
+		{"broken", true, false},
+		{"after_broken", true, true},
+		{"after_after", true, true},
+	}
+	for i, w := range want {
+		r := results[i]
+		if r.Name != w.name || (r.Err != nil) != w.failed || r.Skipped != w.skipped {
+			t.Errorf("result %d: %+v, want %+v", i, r, w)
+		}
+	}
+	if results[4].Err.Error() != "skipped, job after_broken did not run" {
+		t.Errorf("after_after: %s", results[4].Err)
+	}
+
+	if _, err = RunJobs([]Job{{Name: "a", DependsOn: []string{"a"}}}, 1); err == nil {
+		t.Error("a cycle ran")
+	}
+}
+
+func TestRunJobsConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	running, peak := 0, 0
+	var jobs []Job
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		jobs = append(jobs, Job{Name: name, Run: func() (Summary, error) {
+			mu.Lock()
+			running++
+			if running > peak {
+				peak = running
+			}
+			mu.Unlock()
+			mu.Lock()
+			running--
+			mu.Unlock()
+			return Summary{}, nil
+		}})
+	}
+	if _, err := RunJobs(jobs, 2); err != nil {
+		t.Fatal(err)
+	}
+	if peak > 2 {
+		t.Errorf("%d jobs ran at the same time, want at most 2", peak)
+	}
+}
@@ -0,0 +1,155 @@
+package migrate
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// jsonReader reads the records of a json file, either an array of objects or objects one after
+// the other as in json lines. Every object is flattened into path named columns like an xml
+// document, the objects are read ahead to take the columns of all of them.
+type jsonReader struct {
+	decoder *json.Decoder
+	// inArray is set for a file holding an array of records
+	inArray bool
+	arrays  string
+	rows    *flatRows
+	record  int
+}
+
+func (m *Migrater) newJSONReader() (*jsonReader, error) {
+	j := &jsonReader{arrays: m.SourceFileArrays}
+	var err error
+	if j.inArray, err = isArray(m.SourceFile); err != nil {
+		return nil, fmt.Errorf("Error reading json file (%s)", err)
+	}
+	j.decoder = json.NewDecoder(m.SourceFile)
+	j.decoder.UseNumber()
+	if j.inArray {
+		if _, err = j.decoder.Token(); err != nil {
+			return nil, fmt.Errorf("Error reading json file (%s)", err)
+		}
+	}
+	j.rows, err = spoolRows(func() ([]map[string]interface{}, error) {
+		doc, err := j.decode()
+		if err != nil {
+			return nil, err
+		}
+		rows := flatten(doc, j.arrays)
+		for _, row := range rows {
+			for k, v := range row {
+				row[k] = jsonValue(v)
+			}
+		}
+		return rows, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// isArray tells if the file holds an array of records, the white space before it is skipped
+func isArray(r *bufio.Reader) (bool, error) {
+	for {
+		c, _, err := r.ReadRune()
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		// a byte order mark is skipped with the white space
+		if unicode.IsSpace(c) || c == '\uFEFF' {
+			continue
+		}
+		return c == '[', r.UnreadRune()
+	}
+}
+
+// decode reads the next object, io.EOF after the last one
+func (j *jsonReader) decode() (map[string]interface{}, error) {
+	if j.inArray && !j.decoder.More() {
+		return nil, io.EOF
+	}
+	var v interface{}
+	if err := j.decoder.Decode(&v); err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, fmt.Errorf("Error reading json record %d (%s)", j.record+1, err)
+	}
+	j.record++
+	mp, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Json record %d is not an object", j.record)
+	}
+	return mp, nil
+}
+
+func (j *jsonReader) Columns() []string { return j.rows.columns }
+
+func (j *jsonReader) Read() (map[string]interface{}, error) { return j.rows.Read() }
+
+func (j *jsonReader) Close() error { return j.rows.Close() }
+
+// jsonValue converts a json number to an int64 or a float64, an integer too large for an
+// int64 is kept as a string
+func jsonValue(v interface{}) interface{} {
+	n, ok := v.(json.Number)
+	if !ok {
+		return v
+	}
+	if i, err := n.Int64(); err == nil {
+		return i
+	}
+	if strings.ContainsAny(n.String(), ".eE") {
+		if f, err := n.Float64(); err == nil {
+			return f
+		}
+	}
+	return n.String()
+}
+
+// jsonWriter writes the records as a json array, one object per line. Path named columns
+// become nested objects and numbered ones arrays.
+type jsonWriter struct {
+	writer  *bufio.Writer
+	started bool
+}
+
+func (j *jsonWriter) Write(records []map[string]interface{}) error {
+	for _, record := range records {
+		values := make(map[string]interface{}, len(record))
+		for k, v := range record {
+			// []byte would be written as base64
+			if b, ok := v.([]byte); ok {
+				v = string(b)
+			}
+			values[k] = v
+		}
+		line, err := json.Marshal(unflatten(values))
+		if err != nil {
+			return fmt.Errorf("Error writing json (%s)", err)
+		}
+		sep := ",\n"
+		if !j.started {
+			sep, j.started = "[\n", true
+		}
+		j.writer.WriteString(sep)
+		j.writer.Write(line)
+	}
+	return j.writer.Flush()
+}
+
+func (j *jsonWriter) Close() error {
+	if !j.started {
+		j.writer.WriteString("[")
+	}
+	j.writer.WriteString("\n]\n")
+	return j.writer.Flush()
+}
@@ -0,0 +1,75 @@
+package migrate
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ledgerTable records the batches committed by a run, a batch is written in the same
+// transaction as its ledger row so a retried batch is never loaded twice
+const ledgerTable = "migrater_batches"
+
+type ledger struct {
+	db       *sql.DB
+	run      string
+	table    string
+	progress io.Writer
+}
+
+// newLedger creates the ledger table when missing and starts a new run for the target table
+func newLedger(db *sql.DB, table string, progress io.Writer) (*ledger, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS ` + ledgerTable + ` (
+		run_id TEXT NOT NULL,
+		target_table TEXT NOT NULL,
+		batch INTEGER NOT NULL,
+		records INTEGER NOT NULL,
+		loaded_at TIMESTAMP NOT NULL DEFAULT now(),
+		PRIMARY KEY (run_id, target_table, batch))`)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating the batch ledger (%s)", err)
+	}
+	id := make([]byte, 4)
+	if _, err = rand.Read(id); err != nil {
+		return nil, err
+	}
+	run := time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(id)
+	return &ledger{db: db, run: run, table: table, progress: progress}, nil
+}
+
+// write executes the insert of a batch unless the ledger shows it was committed already, which
+// happens when the connection dropped after the commit of an earlier attempt
+func (l *ledger) write(batch int, insert string, records int) error {
+	tx, err := l.db.Begin()
+	if err != nil {
+		return err
+	}
+	var loaded int
+	err = tx.QueryRow(`SELECT count(*) FROM `+ledgerTable+` WHERE run_id = $1 AND target_table = $2 AND batch = $3`,
+		l.run, l.table, batch).Scan(&loaded)
+	if err == nil && loaded > 0 {
+		fmt.Fprintf(l.progress, "Batch %d was already loaded\n", batch)
+		return tx.Rollback()
+	}
+	if err == nil {
+		_, err = tx.Exec(insert)
+	}
+	if err == nil {
+		_, err = tx.Exec(`INSERT INTO `+ledgerTable+` (run_id, target_table, batch, records) VALUES ($1, $2, $3, $4)`,
+			l.run, l.table, batch, records)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// done removes the rows of the finished run
+func (l *ledger) done() error {
+	_, err := l.db.Exec(`DELETE FROM `+ledgerTable+` WHERE run_id = $1 AND target_table = $2`, l.run, l.table)
+	return err
+}
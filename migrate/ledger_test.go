@@ -0,0 +1,89 @@
+package migrate
+
+import (
+	"errors"
+	"io"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/PrakharSrivastav/migrater/retry"
+)
+
+func TestLedgerIsOptIn(t *testing.T) {
+	tests := []struct {
+		name   string
+		ledger bool
+		create bool
+	}{
+		{name: "default", ledger: false, create: false},
+		{name: "enabled", ledger: true, create: true},
+	}
+	for _, tt := range tests {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.create {
+			mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE IF NOT EXISTS migrater_batches")).WillReturnResult(sqlmock.NewResult(0, 0))
+		}
+		m := &Migrater{TargetDB: db, TargetTable: "t", TargetLedger: tt.ledger, TargetRetry: retry.Policy{Retries: 3, Backoff: time.Millisecond}}
+		w, err := m.newWriter([]string{"id"})
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+		} else if got := w.(*dbWriter).ledger != nil; got != tt.create {
+			t.Errorf("%s: ledger %v, want %v", tt.name, got, tt.create)
+		} else {
+			// a batch whose connection dropped is only retried when the ledger skips it if committed
+			errs := []error{io.EOF, nil}
+			err = w.(*dbWriter).retryPolicy().Do("Batch 1", func() error {
+				err, errs = errs[0], errs[1:]
+				return err
+			})
+			if (err == nil) != tt.create {
+				t.Errorf("%s: retrying a dropped connection gives %v", tt.name, err)
+			}
+		}
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+		}
+		db.Close()
+	}
+}
+
+func TestLedgerSkipsLoadedBatch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	count := regexp.QuoteMeta("SELECT count(*) FROM migrater_batches")
+	// batch 1 is new, batch 2 was committed by an attempt whose connection dropped
+	mock.ExpectBegin()
+	mock.ExpectQuery(count).WithArgs("run", "t", 1).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO t")).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO migrater_batches")).WithArgs("run", "t", 1, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery(count).WithArgs("run", "t", 2).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectQuery(count).WithArgs("run", "t", 3).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO t")).WillReturnError(errors.New("value too long"))
+	mock.ExpectRollback()
+
+	l := &ledger{db: db, run: "run", table: "t", progress: io.Discard}
+	if err = l.write(1, "INSERT INTO t VALUES (1), (2)", 2); err != nil {
+		t.Error(err)
+	}
+	if err = l.write(2, "INSERT INTO t VALUES (3)", 1); err != nil {
+		t.Error(err)
+	}
+	if err = l.write(3, "INSERT INTO t VALUES (4)", 1); err == nil {
+		t.Error("the failing batch succeeded")
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -3,16 +3,14 @@ package migrate
 import (
 	"bufio"
 	"database/sql"
-	"encoding/csv"
 	"fmt"
 	"io"
 	"log"
 	"os"
-	"sort"
-	"strings"
 
+	"github.com/PrakharSrivastav/migrater/fixedwidth"
+	"github.com/PrakharSrivastav/migrater/retry"
 	"github.com/PrakharSrivastav/sql-query-builder/qb/builder"
-	"github.com/clbanning/mxj"
 
 	"github.com/PrakharSrivastav/sql-query-builder/qb/core"
 )
@@ -20,6 +18,21 @@ import (
 // MigrationType determines the type of migration to execute between source and target
 type MigrationType int
 
+func (t MigrationType) String() string {
+	switch t {
+	case FileToFile:
+		return "FileToFile"
+	case FileToDB:
+		return "FileToDB"
+	case DBToDB:
+		return "DBToDB"
+	case DBToFile:
+		return "DBToFile"
+	default:
+		return ""
+	}
+}
+
 const (
 	// FileToFile migrates from source file to target file
 	FileToFile MigrationType = iota + 1
@@ -31,6 +44,9 @@ const (
 	DBToFile
 )
 
+// batchSize is the number of records written to the target at once
+const batchSize = 1000
+
 // Migrater is the basic structure that holds the internal source and target information
 type Migrater struct {
 	SourceDB       *sql.DB
@@ -38,29 +54,141 @@ type Migrater struct {
 	SourceFile     *bufio.Reader
 	SourceTable    string
 	SourceFileType string
-	TargetDB       *sql.DB
-	TargetFile     *bufio.Writer
-	TargetTable    string
-	TargetFileType string
-	Type           MigrationType
-	QB             *core.SQL
+	SourceFilePath string
+	// SourceInclude copies all tables of SourceNamespace matching the patterns instead of SourceTable
+	SourceInclude   []string
+	SourceExclude   []string
+	SourceNamespace string
+	// SourceFileColumns names the columns of a pgcopy file without a header or of a pgbinary
+	// file, as name or name:type
+	SourceFileColumns []string
+	// SourceFileHeader is set when the first line of a pgcopy file holds the column names
+	SourceFileHeader bool
+	// SourceFileFormat is the format of a pgcopy file, CopyFormatText by default
+	SourceFileFormat string
+	// SourceFileSheet, SourceFileRange and SourceFileHeaderRow select the records of an xlsx file,
+	// the sheet by name or 1-based index
+	SourceFileSheet     string
+	SourceFileRange     string
+	SourceFileHeaderRow int
+	// SourceFileArrays flattens the arrays of an xml or json file, ArraysIndex by default
+	SourceFileArrays string
+	// SourceFileRecordPath selects the records of an xml file by xpath or of a json file by
+	// jsonpath, SourceFileFields the columns of a record as name=expression
+	SourceFileRecordPath string
+	SourceFileFields     []string
+	// SourceFileLayout and TargetFileLayout place the fields of a fixedwidth file
+	SourceFileLayout fixedwidth.Layout
+	TargetFileLayout fixedwidth.Layout
+	TargetDB         *sql.DB
+	TargetFile       *bufio.Writer
+	TargetTable      string
+	TargetFileType   string
+	TargetFilePath   string
+	// TargetFileDialect and TargetFileStatement shape a sql dump target, TargetTable names its table
+	TargetFileDialect   string
+	TargetFileStatement string
+	// TargetFileFormat and TargetFileHeader shape a pgcopy target
+	TargetFileFormat string
+	TargetFileHeader bool
+	// TargetFileCompression and TargetFileRowGroupSize shape a parquet target
+	TargetFileCompression  string
+	TargetFileRowGroupSize int
+	// TargetFileSheet names the sheet of an xlsx target
+	TargetFileSheet string
+	// TargetRetry retries the batches failing with a transient error, only the serialization
+	// failures and deadlocks without TargetLedger
+	TargetRetry retry.Policy
+	// TargetLedger records the committed batches in a ledger table of the target, which keeps a
+	// batch whose commit was lost with the connection from being loaded twice when retried
+	TargetLedger bool
+	// TargetTransaction is the commit granularity of a database target, TransactionBatch by default
+	TargetTransaction string
+	// TargetSchemaPolicy handles an existing target table whose columns differ from the source,
+	// one of SchemaFail (the default), SchemaAdd, SchemaWiden or SchemaIgnore
+	TargetSchemaPolicy string
+	// TargetDeferIndexes builds the keys and indexes copied from the source table after the load
+	TargetDeferIndexes bool
+	Type               MigrationType
+	QB                 *core.SQL
+	// DedupKeys enables the dedup stage on these columns, DedupKeep is either KeepFirst or KeepLast
+	DedupKeys   []string
+	DedupKeep   string
+	DedupMemory int
+	// Verify reconciles the target table against the written records once the migration completes
+	Verify          bool
+	VerifyKeys      []string
+	VerifyMaxReport int
+	Summary         Summary
+	// PlanExactCount counts the source rows of a plan instead of estimating them from the
+	// table statistics
+	PlanExactCount bool
+	// Progress receives the progress of the migration, stdout by default. Commands printing a
+	// plan or records to stdout send it elsewhere.
+	Progress io.Writer
+
+	// targetTx is the open transaction of a TransactionJob target
+	targetTx *sql.Tx
+	// afterLoad holds the statements to run on a created table once it is loaded
+	afterLoad []string
+	// fileTypes holds the column types of a typed source file, set when its reader is opened
+	fileTypes map[string]string
+	// sourceLimit limits the rows selected from a source database, set by Preview
+	sourceLimit int
+}
+
+// Summary holds the counters reported at the end of a migration
+type Summary struct {
+	RecordsRead       int
+	RecordsWritten    int
+	DuplicatesDropped int
+	Verified          bool
+}
+
+func (s Summary) String() string {
+	return fmt.Sprintf("records read: %d, records written: %d, duplicates discarded: %d, verified: %t",
+		s.RecordsRead, s.RecordsWritten, s.DuplicatesDropped, s.Verified)
+}
+
+// progress returns the writer the progress is printed to
+func (m *Migrater) progress() io.Writer {
+	if m.Progress == nil {
+		return os.Stdout
+	}
+	return m.Progress
 }
 
 // Migrate performs the migration based on the migration Type
-func (m *Migrater) Migrate() {
+func (m *Migrater) Migrate() error {
+	var err error
 	defer m.cleanUp()
 	switch m.Type {
 	case FileToFile:
-		m.migrateF2F()
+		err = m.migrateF2F()
 	case FileToDB:
-		m.migrateF2D()
+		err = m.migrateDB(m.migrateF2D)
 	case DBToFile:
-		m.migrateD2F()
+		err = m.migrateD2F()
 	case DBToDB:
-		m.migrateD2D()
+		if len(m.SourceInclude) > 0 {
+			err = m.migrateDB(m.migrateSchema)
+			break
+		}
+		err = m.migrateDB(m.migrateD2D)
 	default:
-		fmt.Println("Nothing to run")
+		fmt.Fprintln(m.progress(), "Nothing to run")
+		return nil
 	}
+	fmt.Fprintln(m.progress(), "Summary:", m.Summary)
+	return err
+}
+
+// migrateDB runs a migration to a database target, in one transaction for TransactionJob
+func (m *Migrater) migrateDB(migrate func() error) error {
+	if m.TargetTransaction != TransactionJob {
+		return migrate()
+	}
+	return m.inJobTransaction(migrate)
 }
 
 func (m *Migrater) cleanUp() {
@@ -77,213 +205,205 @@ func (m *Migrater) cleanUp() {
 		m.TargetFile = nil
 	}
 }
-func (m *Migrater) migrateF2F() {
-	fmt.Println("migrating F2F")
 
-	if m.SourceFileType == "csv" && m.TargetFileType == "xml" {
-		m.csvToXML()
-	}
+func (m *Migrater) migrateF2F() error {
+	fmt.Fprintf(m.progress(), "migrating F2F from %s to %s\n", m.SourceFileType, m.TargetFileType)
+	return m.run()
+}
 
-	if m.SourceFileType == "xml" && m.TargetFileType == "csv" {
-		m.xmlToCSV()
+func (m *Migrater) migrateF2D() error {
+	fmt.Fprintln(m.progress(), "migrating F2D")
+	if m.SourceFileType == "sql" {
+		return m.replay()
 	}
-
+	return m.run()
 }
 
-func (m *Migrater) xmlToCSV() {
-	fmt.Println("migrating to xml to csv")
-	var mp map[string]interface{}
-	var err error
-	// mp, err = mxj.NewMapXml(m.SourceFile)
-
-	// m.SourceFile.ReadLine(m.SourceFile){
-
-	// }
+func (m *Migrater) migrateD2F() error {
+	fmt.Fprintln(m.progress(), "migrating D2F")
+	return m.run()
+}
 
+// run copies all records from the source to the target in batches
+func (m *Migrater) run() error {
+	reader, err := m.newReader()
 	if err != nil {
-		fmt.Println(err)
+		return err
 	}
-	fmt.Println(mp)
-}
+	defer reader.Close()
 
-func (m *Migrater) csvToXML() {
-	fmt.Println("migrating from csv to xml")
-	reader := csv.NewReader(m.SourceFile)
-	var err error
-	record := []string{}
-	// read the header first
-	if record, err = reader.Read(); err != nil || err == io.EOF {
-		fmt.Println("Error reading from source")
-		panic(err.Error())
-	}
-	columns := record
-	// sort.Strings(columns)
-	xmlTemp := map[string]interface{}{}
-	xmlData := []map[string]interface{}{}
-	for {
-		record, err = reader.Read()
-		if err == io.EOF || err != nil {
-			fmt.Println("Error reading from csv")
-			if len(xmlData) > 0 {
-				m.writeXML(xmlData)
+	cols := reader.Columns()
+	if err = m.checkDedupKeys(cols); err != nil {
+		return err
+	}
+	table, staging := m.TargetTable, ""
+	if m.TargetDB != nil && m.TargetTransaction == TransactionStaging {
+		existed := m.tableExists(table)
+		if staging, err = m.prepareStaging(table, cols); err != nil {
+			return err
+		}
+		// the records go to the staging table, verified there before it is swapped in. The
+		// schema policy changes the staging copy, the live table is left alone until the swap.
+		m.TargetTable = staging
+		defer func() { m.TargetTable = table }()
+		if existed {
+			if cols, err = m.reconcileSchema(staging, cols); err != nil {
+				return err
 			}
-			break
 		}
-		for i := range record {
-			xmlTemp[columns[i]] = record[i]
+	} else if m.TargetDB != nil && m.tableExists(m.TargetTable) {
+		if cols, err = m.reconcileSchema(m.TargetTable, cols); err != nil {
+			return err
 		}
-		xmlData = append(xmlData, xmlTemp)
-		xmlTemp = map[string]interface{}{}
-		// check the length
-		if len(xmlData) == 1000 {
-			m.writeXML(xmlData)
-			xmlData = []map[string]interface{}{}
+	} else if m.TargetDB != nil {
+		fmt.Fprintln(m.progress(), "Table does not exist")
+		if _, err = m.createTable(m.TargetTable, cols); err != nil {
+			return err
 		}
 	}
-}
-func (m *Migrater) migrateF2D() { fmt.Println("migrating F2D") }
-func (m *Migrater) migrateD2F() {
-	var err error
-	var selectSQL string
-	var rows *sql.Rows
-	var cols []string
-	if cols, err = m.getColumnsFromSourceTable(); err != nil {
-		fmt.Println(err)
+	writer, err := m.newWriter(cols)
+	if err != nil {
+		return err
 	}
-	sort.Strings(cols)
 
-	switch m.SourceSQL {
-	case "":
-		selectSQL = m.QB.Reader.Select(strings.Join(cols, ",")).From(m.SourceTable).Build()
-	default:
-		selectSQL = m.SourceSQL
-	}
-	if rows, err = m.SourceDB.Query(selectSQL); err != nil {
-		fmt.Println(err)
+	var sums *checksum
+	if m.Verify {
+		if sums, err = m.startVerify(cols); err != nil {
+			return err
+		}
 	}
-	defer rows.Close()
-
-	var csvData [][]string
-	var csvTemp []string
-	csvData = append(csvData, cols)
 
-	xmlData := []map[string]interface{}{}
-	xmlTemp := map[string]interface{}{}
-	for rows.Next() {
-		columns := make([]interface{}, len(cols))
-		columnPointers := make([]interface{}, len(cols))
-		for i := range cols {
-			columnPointers[i] = &columns[i]
+	batch := make([]map[string]interface{}, 0, batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
 		}
-		if err := rows.Scan(columnPointers...); err != nil {
-			fmt.Println(err)
+		fmt.Fprintf(m.progress(), "Dumping %d records\n", len(batch))
+		if sums != nil {
+			for _, record := range batch {
+				sums.add(record)
+			}
 		}
+		if err := writer.Write(batch); err != nil {
+			return err
+		}
+		m.Summary.RecordsWritten += len(batch)
+		batch = make([]map[string]interface{}, 0, batchSize)
+		return nil
+	}
+	emit := func(record map[string]interface{}) error {
+		batch = append(batch, record)
+		if len(batch) == batchSize {
+			return flush()
+		}
+		return nil
+	}
 
-		switch m.TargetFileType {
-		case "csv":
-			for i := range cols {
-				val := columnPointers[i].(*interface{})
-				switch (*val).(type) {
-				case []uint8:
-					csvTemp = append(csvTemp, string((*val).([]byte)))
-				default:
-					csvTemp = append(csvTemp, (*val).(string))
-				}
-			}
-			csvData = append(csvData, csvTemp)
-			csvTemp = []string{}
-			if len(csvData) == 100 {
-				fmt.Println("Dumping 1000 records")
-				csv.NewWriter(m.TargetFile).WriteAll(csvData)
-				csvData = [][]string{}
-			}
-		case "xml":
-			for i := range cols {
-				// value := v
-				val := columnPointers[i].(*interface{})
-				switch (*val).(type) {
-				case []uint8:
-					xmlTemp[cols[i]] = string((*val).([]byte))
-				default:
-					xmlTemp[cols[i]] = (*val).(string)
-				}
-			}
-			xmlData = append(xmlData, xmlTemp)
-			xmlTemp = map[string]interface{}{}
-			if len(xmlData) == 100 {
-				fmt.Println("writing xml")
-				m.writeXML(xmlData)
-				xmlData = []map[string]interface{}{}
-			}
+	if err = m.pump(reader, 0, emit); err != nil {
+		return err
+	}
+	if err = flush(); err != nil {
+		return err
+	}
+	if err = writer.Close(); err != nil {
+		return err
+	}
+	if err = m.finishLoad(); err != nil {
+		return err
+	}
+	if sums != nil {
+		if err = m.verify(sums); err != nil {
+			return err
+		}
+	}
+	if staging != "" {
+		if err = m.finishStaging(staging, table); err != nil {
+			return err
 		}
+	}
+	fmt.Fprintln(m.progress(), "Complete")
+	return nil
+}
 
+// pump reads records from the source through the dedup stage into emit. At most limit records
+// are read from the source, a limit of 0 reads all of them.
+func (m *Migrater) pump(reader recordReader, limit int, emit func(map[string]interface{}) error) error {
+	var err error
+	var dedup *Deduper
+	if len(m.DedupKeys) > 0 {
+		if dedup, err = NewDeduper(m.DedupKeys, m.DedupKeep, m.DedupMemory); err != nil {
+			return err
+		}
+		dedup.seen.progress = m.progress()
+		defer dedup.Close()
 	}
-	if len(csvData) > 1 {
-		fmt.Println("Dumping remaining records")
-		csv.NewWriter(m.TargetFile).WriteAll(csvData)
+
+	for limit <= 0 || m.Summary.RecordsRead < limit {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading from source (%s)", err)
+		}
+		m.Summary.RecordsRead++
+		if dedup == nil {
+			err = emit(record)
+		} else {
+			err = dedup.Add(record, emit)
+		}
+		if err != nil {
+			return err
+		}
 	}
-	if len(xmlData) > 0 {
-		fmt.Println("Dumping remaining  xml")
-		m.writeXML(xmlData)
+	if dedup != nil {
+		if err = dedup.Flush(emit); err != nil {
+			return err
+		}
+		m.Summary.DuplicatesDropped = dedup.Discarded()
 	}
+	return nil
 }
-func (m *Migrater) writeXML(data []map[string]interface{}) {
 
-	for _, value := range data {
-		if err := mxj.Map(value).XmlIndentWriter(m.TargetFile, "", "  ", "Root"); err != nil {
-			fmt.Println("Error writing xml", err)
+// checkDedupKeys rejects dedup keys missing from the source columns, a misspelled key would
+// hash every record as NULL and keep only one of them
+func (m *Migrater) checkDedupKeys(cols []string) error {
+	for _, key := range m.DedupKeys {
+		if !contains(cols, key) {
+			return fmt.Errorf("Dedup key %s is not a source column", key)
 		}
 	}
-	m.TargetFile.Flush()
+	return nil
 }
 
 func (m *Migrater) getColumnsFromSourceTable() ([]string, error) {
 	var selectSQL string
 	var rows *sql.Rows
 	var err error
-	var cols []string
 	switch m.SourceSQL {
 	case "":
 		selectSQL = m.QB.Reader.Select("*").From(m.SourceTable).Limit(1).Build()
 	default:
-		selectSQL = m.SourceSQL + "LIMIT 1"
+		selectSQL = m.SourceSQL + " LIMIT 1"
 	}
 	if rows, err = m.SourceDB.Query(selectSQL); err != nil {
 		return nil, err
 	}
-	for rows.Next() {
-		if cols, err = rows.Columns(); err != nil {
-			return nil, err
-		}
-	}
-	rows.Close()
-	return cols, nil
+	defer rows.Close()
+	// the columns are known without a row, an empty table still has them
+	return rows.Columns()
 }
 
-func (m *Migrater) migrateD2D() {
-	// Source configuration
-	fmt.Println("migrating D2D")
-
-	if !m.tableExists(m.TargetTable) {
-		fmt.Println("Table does not exist")
-		if _, err := m.createTable(m.TargetTable); err != nil {
-			fmt.Println(err)
-			m.cleanUp()
-			os.Exit(1)
-		}
-	}
-	fmt.Println("Table exists")
-	if _, err := m.begingD2DImport(); err != nil {
-		fmt.Println(err)
-		m.cleanUp()
-		os.Exit(1)
-	}
+func (m *Migrater) migrateD2D() error {
+	fmt.Fprintln(m.progress(), "migrating D2D")
+	return m.run()
 }
 
 func (m *Migrater) tableExists(table string) bool {
-	rows, err := m.TargetDB.Query(fmt.Sprintf("SELECT to_regclass('%s')", table))
+	rows, err := m.target().Query(fmt.Sprintf("SELECT to_regclass('%s')", table))
 	if err != nil {
 		log.Printf("Error %s checking if the table (%s) exists", err, table)
+		return false
 	}
 	defer rows.Close()
 
@@ -298,116 +418,88 @@ func (m *Migrater) tableExists(table string) bool {
 	return false
 }
 
-func (m *Migrater) createTable(tableName string) (bool, error) {
-	var err error
-	var rows *sql.Rows
+// sourceColumnTypes returns the column types of the source table or sql
+func (m *Migrater) sourceColumnTypes() ([]*sql.ColumnType, error) {
 	var selectSQL string
-	var columns []*sql.ColumnType
-	var tableCols []builder.Columns
-
 	switch m.SourceSQL {
 	case "":
 		selectSQL = m.QB.Reader.Select("*").From(m.SourceTable).Limit(1).Build()
 	default:
-		selectSQL = m.SourceSQL + "LIMIT 1"
+		selectSQL = m.SourceSQL + " LIMIT 1"
 	}
 
-	if rows, err = m.SourceDB.Query(selectSQL); err != nil {
-		return false, err
+	rows, err := m.SourceDB.Query(selectSQL)
+	if err != nil {
+		return nil, err
 	}
 	defer rows.Close()
+	return rows.ColumnTypes()
+}
 
-	for rows.Next() {
-		if columns, err = rows.ColumnTypes(); err != nil {
+// createTable creates the target table. The keys and indexes deferred until after the load are
+// kept for finishLoad.
+func (m *Migrater) createTable(tableName string, cols []string) (bool, error) {
+	create, after, err := m.createTableStatements(tableName, cols)
+	if err != nil {
+		return false, err
+	}
+	for _, stmt := range create {
+		if _, err := m.target().Exec(stmt); err != nil {
 			return false, err
 		}
 	}
-	for _, item := range columns {
-		tableCols = append(tableCols, builder.Columns{Name: item.Name(), Datatype: item.DatabaseTypeName()})
-	}
-
-	createSQL := m.QB.Creater.
-		Table(m.TargetTable).
-		SetColumns(tableCols).
-		Build()
-
-	if _, err := m.TargetDB.Exec(createSQL); err != nil {
-		return false, err
-	}
+	m.afterLoad = append(m.afterLoad, after...)
 	return true, nil
 }
 
-func (m *Migrater) begingD2DImport() (bool, error) {
-	var err error
-	var selectSQL string
-	var rows *sql.Rows
-	var cols []string
-	switch m.SourceSQL {
-	case "":
-		selectSQL = m.QB.Reader.Select("*").From(m.SourceTable).Limit(1).Build()
-	default:
-		selectSQL = m.SourceSQL + "LIMIT 1"
+// createTableStatements returns the statements creating the target table and those to run once
+// it is loaded. A source table is copied with its keys, indexes, defaults and comments.
+func (m *Migrater) createTableStatements(tableName string, cols []string) ([]string, []string, error) {
+	if m.copyCatalog() {
+		return m.catalogTableSQL(tableName)
 	}
-	if rows, err = m.SourceDB.Query(selectSQL); err != nil {
-		return false, err
-	}
-	for rows.Next() {
-		if cols, err = rows.Columns(); err != nil {
-			return false, err
+	createSQL, err := m.createTableSQL(tableName, cols)
+	return []string{createSQL}, nil, err
+}
+
+// finishLoad builds the deferred keys and indexes and moves the sequences past the loaded values
+func (m *Migrater) finishLoad() error {
+	for _, stmt := range m.afterLoad {
+		fmt.Fprintln(m.progress(), "Running", stmt)
+		if _, err := m.target().Exec(stmt); err != nil {
+			return err
 		}
 	}
-	rows.Close()
-	sort.Strings(cols)
-	switch m.SourceSQL {
-	case "":
-		selectSQL = m.QB.Reader.Select(strings.Join(cols, ",")).From(m.SourceTable).Build()
-	default:
-		selectSQL = m.SourceSQL
-	}
-	if rows, err = m.SourceDB.Query(selectSQL); err != nil {
-		return false, err
-	}
-	defer rows.Close()
-	insertSQLBuilder := m.QB.Inserter.Table(m.TargetTable).Columns(cols)
-	insert := insertSQLBuilder
-	count := 0
-	for rows.Next() {
-		columns := make([]interface{}, len(cols))
-		columnPointers := make([]interface{}, len(cols))
-		for i := range cols {
-			columnPointers[i] = &columns[i]
-		}
-		if err := rows.Scan(columnPointers...); err != nil {
-			return false, err
-		}
-		mp := make(map[string]interface{})
-		for i, colName := range cols {
-			val := columnPointers[i].(*interface{})
-			switch (*val).(type) {
-			case []uint8:
-				str := string((*val).([]byte))
-				mp[colName] = str
-			default:
-				mp[colName] = *val
+	m.afterLoad = nil
+	return nil
+}
+
+// createTableSQL builds the CREATE TABLE for the target. The column types are taken from the
+// source table or a typed source file, other file columns are created as text.
+func (m *Migrater) createTableSQL(tableName string, cols []string) (string, error) {
+	var err error
+	var columns []*sql.ColumnType
+	var tableCols []builder.Columns
+
+	if m.SourceDB == nil {
+		for _, col := range cols {
+			datatype := "TEXT"
+			if t, ok := m.fileTypes[col]; ok {
+				datatype = t
 			}
+			tableCols = append(tableCols, builder.Columns{Name: col, Datatype: datatype})
 		}
-		insert.Values(mp)
-		count++
-		if count == 1000 {
-			fmt.Println("Dumping 1000 records")
-			if _, err := m.TargetDB.Exec(insert.Build()); err != nil {
-				fmt.Printf("Error (%s) in executing  \n", err)
-			}
-			insert = insertSQLBuilder
-			count = 0
+	} else {
+		if columns, err = m.sourceColumnTypes(); err != nil {
+			return "", err
 		}
-	}
-	if count > 0 {
-		fmt.Println("Dumping remaining records")
-		if _, err := m.TargetDB.Exec(insert.Build()); err != nil {
-			fmt.Printf("Error (%s) in executing  \n", err)
+		for _, item := range columns {
+			tableCols = append(tableCols, builder.Columns{Name: item.Name(), Datatype: item.DatabaseTypeName()})
 		}
 	}
-	fmt.Println("Complete")
-	return true, nil
+
+	return m.QB.Creater.
+		Table(tableName).
+		SetColumns(tableCols).
+		Build(), nil
 }
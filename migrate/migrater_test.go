@@ -0,0 +1,157 @@
+package migrate
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// sliceReader is a recordReader over records held in memory
+type sliceReader struct {
+	columns []string
+	records []map[string]interface{}
+}
+
+func (s *sliceReader) Columns() []string { return s.columns }
+
+func (s *sliceReader) Read() (map[string]interface{}, error) {
+	if len(s.records) == 0 {
+		return nil, io.EOF
+	}
+	record := s.records[0]
+	s.records = s.records[1:]
+	return record, nil
+}
+
+func (s *sliceReader) Close() error { return nil }
+
+// fileMigrater migrates the source text of the file type into a buffer of the target file type
+func fileMigrater(sourceType, source, targetType string) (*Migrater, *bytes.Buffer) {
+	var out bytes.Buffer
+	return &Migrater{
+		Type:           FileToFile,
+		SourceFileType: sourceType,
+		SourceFile:     bufio.NewReader(strings.NewReader(source)),
+		TargetFileType: targetType,
+		TargetFile:     bufio.NewWriter(&out),
+	}, &out
+}
+
+func TestMigrateFileToFile(t *testing.T) {
+	tests := []struct {
+		name       string
+		sourceType string
+		source     string
+		targetType string
+		want       string
+	}{
+		{
+			name:       "csv to csv",
+			sourceType: "csv",
+			source:     "id,name\n1,a\n2,\"b, c\"\n",
+			targetType: "csv",
+			want:       "id,name\n1,a\n2,\"b, c\"\n",
+		},
+		{
+			name:       "csv to xml",
+			sourceType: "csv",
+			source:     "id,name\n1,a & b\n",
+			targetType: "xml",
+			want:       "<Root>\n  <id>1</id>\n  <name>a &amp; b</name>\n</Root>",
+		},
+		{
+			name:       "xml to csv",
+			sourceType: "xml",
+			source:     "<Root><id>1</id><name>a</name></Root><Root><id>2</id><name>b</name></Root>",
+			targetType: "csv",
+			want:       "id,name\n1,a\n2,b\n",
+		},
+	}
+	for _, tt := range tests {
+		m, out := fileMigrater(tt.sourceType, tt.source, tt.targetType)
+		if err := m.Migrate(); err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if out.String() != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, out.String(), tt.want)
+		}
+	}
+}
+
+func TestMigrateDedup(t *testing.T) {
+	tests := []struct {
+		keys    []string
+		keep    string
+		want    string
+		wantErr bool
+	}{
+		{keys: []string{"id"}, keep: KeepFirst, want: "id,name\n1,a\n2,b\n"},
+		{keys: []string{"id"}, keep: KeepLast, want: "id,name\n2,b\n1,c\n"},
+		{keys: []string{"id", "name"}, want: "id,name\n1,a\n2,b\n1,c\n"},
+		// a misspelled key is an error rather than every record hashing as NULL
+		{keys: []string{"idd"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		m, out := fileMigrater("csv", "id,name\n1,a\n2,b\n1,c\n", "csv")
+		m.DedupKeys, m.DedupKeep = tt.keys, tt.keep
+		err := m.Migrate()
+		if tt.wantErr {
+			if err == nil || !strings.Contains(err.Error(), "idd") {
+				t.Errorf("keys %v: expected an error naming the key, got %v", tt.keys, err)
+			}
+			if m.Summary.RecordsWritten != 0 {
+				t.Errorf("keys %v: wrote %d records", tt.keys, m.Summary.RecordsWritten)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("keys %v: %s", tt.keys, err)
+			continue
+		}
+		if out.String() != tt.want {
+			t.Errorf("keys %v, keep %s: got %q, want %q", tt.keys, tt.keep, out.String(), tt.want)
+		}
+	}
+}
+
+func TestPumpLimit(t *testing.T) {
+	reader := &sliceReader{columns: []string{"id"}}
+	for i := 0; i < 5; i++ {
+		reader.records = append(reader.records, map[string]interface{}{"id": i})
+	}
+	m := &Migrater{}
+	var got []interface{}
+	err := m.pump(reader, 3, func(record map[string]interface{}) error {
+		got = append(got, record["id"])
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{0, 1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestToString(t *testing.T) {
+	tests := []struct {
+		in   interface{}
+		want string
+	}{
+		{nil, ""},
+		{"a", "a"},
+		{[]byte("b"), "b"},
+		{int64(-3), "-3"},
+		{1.5, "1.5"},
+		{true, "true"},
+	}
+	for _, tt := range tests {
+		if got := toString(tt.in); got != tt.want {
+			t.Errorf("toString(%#v) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
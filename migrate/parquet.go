@@ -0,0 +1,485 @@
+package migrate
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress"
+	"github.com/parquet-go/parquet-go/format"
+)
+
+// parquetCodecs are the compression codecs of a parquet target, snappy by default
+var parquetCodecs = map[string]compress.Codec{
+	"":       &parquet.Snappy,
+	"snappy": &parquet.Snappy,
+	"gzip":   &parquet.Gzip,
+	"zstd":   &parquet.Zstd,
+	"lz4":    &parquet.Lz4Raw,
+	"brotli": &parquet.Brotli,
+	"none":   &parquet.Uncompressed,
+}
+
+// julianUnixEpoch is the julian day of 1970-01-01
+const julianUnixEpoch = 2440588
+
+// maxInt64Precision is the largest decimal precision held in an int64
+const maxInt64Precision = 18
+
+// parquetColumnsKey is the key/value metadata keeping the order of the source columns, the
+// fields of a parquet group are sorted by name
+const parquetColumnsKey = "migrater.columns"
+
+// parquetColumn is a column of a parquet file and the postgres type it is read or written as
+type parquetColumn struct {
+	name     string
+	datatype string
+	index    int
+	// scale and size are those of a decimal, size is 0 when it is held in an int64
+	scale int
+	size  int
+	// unit is the unit of a timestamp read from the file
+	unit time.Duration
+}
+
+// parquetNode maps a postgres type to the parquet type of the column. Numerics without a
+// precision cannot be a parquet decimal and are written as strings.
+func parquetNode(datatype string) (parquet.Node, int, int) {
+	switch t := binaryType(datatype); t {
+	case "smallint":
+		return parquet.Int(16), 0, 0
+	case "integer":
+		return parquet.Int(32), 0, 0
+	case "bigint":
+		return parquet.Int(64), 0, 0
+	case "real":
+		return parquet.Leaf(parquet.FloatType), 0, 0
+	case "double precision":
+		return parquet.Leaf(parquet.DoubleType), 0, 0
+	case "boolean":
+		return parquet.Leaf(parquet.BooleanType), 0, 0
+	case "date":
+		return parquet.Date(), 0, 0
+	case "timestamp without time zone":
+		return parquet.TimestampAdjusted(parquet.Microsecond, false), 0, 0
+	case "timestamp with time zone":
+		return parquet.Timestamp(parquet.Microsecond), 0, 0
+	case "uuid":
+		return parquet.UUID(), 0, 0
+	case "json", "jsonb":
+		return parquet.JSON(), 0, 0
+	case "bytea":
+		return parquet.Leaf(parquet.ByteArrayType), 0, 0
+	case "numeric":
+		var precision, scale int
+		if n, _ := fmt.Sscanf(strings.ToLower(datatype), "numeric(%d,%d)", &precision, &scale); n < 1 {
+			break
+		}
+		if precision <= maxInt64Precision {
+			return parquet.Decimal(scale, precision, parquet.Int64Type), scale, 0
+		}
+		// the unscaled value in two's complement, sign bit included
+		size := int(math.Ceil((float64(precision)*math.Log2(10) + 1) / 8))
+		return parquet.Decimal(scale, precision, parquet.FixedLenByteArrayType(size)), scale, size
+	}
+	return parquet.String(), 0, 0
+}
+
+// pgTypeOf maps the type of a parquet column back to a postgres type
+func pgTypeOf(node parquet.Node) string {
+	typ := node.Type()
+	if logical := typ.LogicalType(); logical != nil && logical.Value != nil {
+		switch l := logical.Value.(type) {
+		case *format.IntType:
+			switch {
+			case l.BitWidth <= 16:
+				return "smallint"
+			case l.BitWidth <= 32:
+				return "integer"
+			}
+			return "bigint"
+		case *format.DecimalType:
+			return fmt.Sprintf("numeric(%d,%d)", l.Precision, l.Scale)
+		case *format.DateType:
+			return "date"
+		case *format.TimestampType:
+			if l.IsAdjustedToUTC {
+				return "timestamp with time zone"
+			}
+			return "timestamp without time zone"
+		case *format.UUIDType:
+			return "uuid"
+		case *format.JsonType:
+			return "jsonb"
+		case *format.StringType, *format.EnumType:
+			return "text"
+		}
+	}
+	switch typ.Kind() {
+	case parquet.Boolean:
+		return "boolean"
+	case parquet.Int32:
+		return "integer"
+	case parquet.Int64:
+		return "bigint"
+	case parquet.Int96:
+		return "timestamp without time zone"
+	case parquet.Float:
+		return "real"
+	case parquet.Double:
+		return "double precision"
+	case parquet.ByteArray, parquet.FixedLenByteArray:
+		if typ.LogicalType() == nil && typ.ConvertedType() == nil {
+			return "bytea"
+		}
+	}
+	return "text"
+}
+
+// parquetWriter writes the records to a parquet file whose columns keep the source types
+type parquetWriter struct {
+	file    *bufio.Writer
+	writer  *parquet.Writer
+	columns []parquetColumn
+}
+
+func (m *Migrater) newParquetWriter(cols []string) (*parquetWriter, error) {
+	types, err := m.sourceTypeNames(cols)
+	if err != nil {
+		return nil, err
+	}
+	group := parquet.Group{}
+	var columns []parquetColumn
+	for _, col := range cols {
+		node, scale, size := parquetNode(types[col])
+		group[col] = parquet.Optional(node)
+		columns = append(columns, parquetColumn{name: col, datatype: types[col], scale: scale, size: size})
+	}
+	schema := parquet.NewSchema("records", group)
+	for i := range columns {
+		leaf, _ := schema.Lookup(columns[i].name)
+		columns[i].index = leaf.ColumnIndex
+	}
+
+	codec, ok := parquetCodecs[m.TargetFileCompression]
+	if !ok {
+		return nil, fmt.Errorf("Unsupported parquet compression (%s)", m.TargetFileCompression)
+	}
+	order, err := json.Marshal(cols)
+	if err != nil {
+		return nil, err
+	}
+	options := []parquet.WriterOption{schema, parquet.Compression(codec), parquet.KeyValueMetadata(parquetColumnsKey, string(order))}
+	if m.TargetFileRowGroupSize > 0 {
+		options = append(options, parquet.MaxRowsPerRowGroup(int64(m.TargetFileRowGroupSize)))
+	}
+	return &parquetWriter{file: m.TargetFile, writer: parquet.NewWriter(m.TargetFile, options...), columns: columns}, nil
+}
+
+func (p *parquetWriter) Write(records []map[string]interface{}) error {
+	rows := make([]parquet.Row, len(records))
+	for i, record := range records {
+		row := make(parquet.Row, len(p.columns))
+		for _, col := range p.columns {
+			v := record[col.name]
+			if v == nil {
+				row[col.index] = parquet.NullValue().Level(0, 0, col.index)
+				continue
+			}
+			value, err := col.value(v)
+			if err != nil {
+				return fmt.Errorf("Error (%s) writing column %s", err, col.name)
+			}
+			row[col.index] = value.Level(0, 1, col.index)
+		}
+		rows[i] = row
+	}
+	if _, err := p.writer.WriteRows(rows); err != nil {
+		return fmt.Errorf("Error writing parquet (%s)", err)
+	}
+	return nil
+}
+
+func (p *parquetWriter) Close() error {
+	if err := p.writer.Close(); err != nil {
+		return err
+	}
+	return p.file.Flush()
+}
+
+// value converts a source value to the physical type of the column
+func (c parquetColumn) value(v interface{}) (parquet.Value, error) {
+	switch t := binaryType(c.datatype); t {
+	case "smallint", "integer":
+		n, err := binaryInt(v)
+		if err != nil {
+			return parquet.Value{}, err
+		}
+		if n < math.MinInt32 || n > math.MaxInt32 || (t == "smallint" && (n < math.MinInt16 || n > math.MaxInt16)) {
+			return parquet.Value{}, fmt.Errorf("%d is out of range for %s", n, t)
+		}
+		return parquet.Int32Value(int32(n)), nil
+	case "bigint":
+		n, err := binaryInt(v)
+		return parquet.Int64Value(n), err
+	case "real", "double precision":
+		f, err := binaryFloat(v)
+		if t == "real" {
+			return parquet.FloatValue(float32(f)), err
+		}
+		return parquet.DoubleValue(f), err
+	case "boolean":
+		b, ok := v.(bool)
+		if !ok {
+			var err error
+			if b, err = strconv.ParseBool(toString(v)); err != nil {
+				return parquet.Value{}, fmt.Errorf("%q is not a boolean", toString(v))
+			}
+		}
+		return parquet.BooleanValue(b), nil
+	case "date", "timestamp without time zone", "timestamp with time zone":
+		tm, err := binaryTime(v)
+		if err != nil {
+			return parquet.Value{}, err
+		}
+		if t != "timestamp with time zone" {
+			tm = wallClock(tm)
+		}
+		if t == "date" {
+			return parquet.Int32Value(int32(math.Floor(float64(tm.Unix()) / 86400))), nil
+		}
+		return parquet.Int64Value(tm.Unix()*1e6 + int64(tm.Nanosecond()/1e3)), nil
+	case "uuid":
+		b, err := encodeBinary("uuid", v)
+		return parquet.FixedLenByteArrayValue(b), err
+	case "bytea":
+		return parquet.ByteArrayValue([]byte(toString(v))), nil
+	case "numeric":
+		if !strings.HasPrefix(strings.ToLower(c.datatype), "numeric(") {
+			break
+		}
+		unscaled, err := unscaledDecimal(toString(v), c.scale)
+		if err != nil {
+			return parquet.Value{}, err
+		}
+		if c.size == 0 {
+			if !unscaled.IsInt64() {
+				return parquet.Value{}, fmt.Errorf("%s is out of range for %s", toString(v), c.datatype)
+			}
+			return parquet.Int64Value(unscaled.Int64()), nil
+		}
+		return parquet.FixedLenByteArrayValue(twosComplement(unscaled, c.size)), nil
+	}
+	return parquet.ByteArrayValue([]byte(toString(v))), nil
+}
+
+// unscaledDecimal reads a decimal as an integer of scale decimal places, more decimals are
+// rounded half away from zero
+func unscaledDecimal(s string, scale int) (*big.Int, error) {
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+	if !ok {
+		return nil, fmt.Errorf("%q is not a numeric", s)
+	}
+	r.Mul(r, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)))
+	half := big.NewRat(1, 2)
+	if r.Sign() < 0 {
+		half.Neg(half)
+	}
+	r.Add(r, half)
+	return new(big.Int).Quo(r.Num(), r.Denom()), nil
+}
+
+// twosComplement encodes n big endian in size bytes
+func twosComplement(n *big.Int, size int) []byte {
+	b := make([]byte, size)
+	if n.Sign() >= 0 {
+		return n.FillBytes(b)
+	}
+	// the complement of |n| - 1
+	m := new(big.Int).Sub(new(big.Int).Neg(n), big.NewInt(1)).FillBytes(b)
+	for i := range m {
+		m[i] = ^m[i]
+	}
+	return m
+}
+
+// parquetReader reads the rows of a parquet file as typed records
+type parquetReader struct {
+	file    *os.File
+	reader  *parquet.Reader
+	columns []parquetColumn
+	// names are the columns in the order of the source the file was written from
+	names []string
+	rows  []parquet.Row
+	next  int
+	done  bool
+}
+
+func (m *Migrater) newParquetReader() (*parquetReader, error) {
+	f, err := os.Open(m.SourceFilePath)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	pf, err := parquet.OpenFile(f, stat.Size())
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("Error reading parquet file (%s)", err)
+	}
+	p := &parquetReader{file: f, reader: parquet.NewReader(pf)}
+	schema := p.reader.Schema()
+	m.fileTypes = map[string]string{}
+	for i, path := range schema.Columns() {
+		leaf, _ := schema.Lookup(path...)
+		if len(path) > 1 || leaf.MaxRepetitionLevel > 0 {
+			f.Close()
+			return nil, fmt.Errorf("Nested parquet column %s is not supported", strings.Join(path, "."))
+		}
+		col := parquetColumn{name: path[0], datatype: pgTypeOf(leaf.Node), index: i}
+		if logical := leaf.Node.Type().LogicalType(); logical != nil {
+			switch l := logical.Value.(type) {
+			case *format.DecimalType:
+				col.scale = int(l.Scale)
+			case *format.TimestampType:
+				col.unit = l.Unit.Value.Duration()
+			}
+		}
+		p.columns = append(p.columns, col)
+		p.names = append(p.names, col.name)
+		m.fileTypes[col.name] = col.datatype
+	}
+	if order, ok := pf.Lookup(parquetColumnsKey); ok {
+		var names []string
+		if json.Unmarshal([]byte(order), &names) == nil && sameColumns(names, p.names) {
+			p.names = names
+		}
+	}
+	return p, nil
+}
+
+// sameColumns tells if a and b hold the same names in any order
+func sameColumns(a, b []string) bool {
+	return len(a) == len(b) && reflect.DeepEqual(sortedCopy(a), sortedCopy(b))
+}
+
+func (p *parquetReader) Columns() []string { return p.names }
+
+func (p *parquetReader) Read() (map[string]interface{}, error) {
+	if p.next == len(p.rows) {
+		if p.done {
+			return nil, io.EOF
+		}
+		if err := p.fill(); err != nil {
+			return nil, err
+		}
+	}
+	row := p.rows[p.next]
+	p.next++
+	mp := make(map[string]interface{}, len(p.columns))
+	for _, value := range row {
+		col := p.columns[value.Column()]
+		if value.IsNull() {
+			mp[col.name] = nil
+			continue
+		}
+		v, err := col.decode(value)
+		if err != nil {
+			return nil, fmt.Errorf("Error (%s) reading column %s", err, col.name)
+		}
+		mp[col.name] = v
+	}
+	return mp, nil
+}
+
+// fill reads the next rows of the file
+func (p *parquetReader) fill() error {
+	if p.rows == nil {
+		p.rows = make([]parquet.Row, batchSize)
+	}
+	n, err := p.reader.ReadRows(p.rows[:cap(p.rows)])
+	p.rows, p.next = p.rows[:n], 0
+	if err == io.EOF {
+		p.done = true
+		if n == 0 {
+			return io.EOF
+		}
+		return nil
+	}
+	return err
+}
+
+func (p *parquetReader) Close() error {
+	p.reader.Close()
+	return p.file.Close()
+}
+
+// decode converts a parquet value to the go value of the column type
+func (c parquetColumn) decode(value parquet.Value) (interface{}, error) {
+	switch t := binaryType(c.datatype); {
+	case t == "date":
+		return time.Unix(int64(value.Int32())*86400, 0).UTC(), nil
+	case strings.HasPrefix(t, "timestamp"):
+		if value.Kind() == parquet.Int96 {
+			// nanoseconds of the day and the julian day, as written by older tools
+			i := value.Int96()
+			nanos := int64(i[1])<<32 | int64(i[0])
+			return time.Unix((int64(i[2])-julianUnixEpoch)*86400, nanos).UTC(), nil
+		}
+		return unixTime(value.Int64(), c.timeUnit()), nil
+	case t == "numeric":
+		var unscaled *big.Int
+		switch value.Kind() {
+		case parquet.Int32:
+			unscaled = big.NewInt(int64(value.Int32()))
+		case parquet.Int64:
+			unscaled = big.NewInt(value.Int64())
+		default:
+			unscaled = fromTwosComplement(value.ByteArray())
+		}
+		return new(big.Rat).SetFrac(unscaled, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(c.scale)), nil)).FloatString(c.scale), nil
+	case t == "uuid":
+		return decodeBinary("uuid", value.ByteArray())
+	}
+	switch value.Kind() {
+	case parquet.Boolean:
+		return value.Boolean(), nil
+	case parquet.Int32:
+		return int64(value.Int32()), nil
+	case parquet.Int64:
+		return value.Int64(), nil
+	case parquet.Float:
+		return float64(value.Float()), nil
+	case parquet.Double:
+		return value.Double(), nil
+	}
+	return string(value.ByteArray()), nil
+}
+
+// timeUnit is the unit of a timestamp column, the writer uses microseconds
+func (c parquetColumn) timeUnit() time.Duration {
+	if c.unit != 0 {
+		return c.unit
+	}
+	return time.Microsecond
+}
+
+func fromTwosComplement(b []byte) *big.Int {
+	n := new(big.Int).SetBytes(b)
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(len(b)*8)))
+	}
+	return n
+}
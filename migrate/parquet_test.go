@@ -0,0 +1,144 @@
+package migrate
+
+import (
+	"bufio"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTwosComplement(t *testing.T) {
+	tests := []struct {
+		n    string
+		size int
+		want []byte
+	}{
+		{"0", 2, []byte{0x00, 0x00}},
+		{"1", 2, []byte{0x00, 0x01}},
+		{"-1", 2, []byte{0xff, 0xff}},
+		{"127", 1, []byte{0x7f}},
+		{"-128", 1, []byte{0x80}},
+		{"-129", 2, []byte{0xff, 0x7f}},
+		{"-12345678901234567890123", 10, nil},
+	}
+	for _, tt := range tests {
+		n, _ := new(big.Int).SetString(tt.n, 10)
+		b := twosComplement(n, tt.size)
+		if tt.want != nil && !reflect.DeepEqual(b, tt.want) {
+			t.Errorf("%s: %x, want %x", tt.n, b, tt.want)
+		}
+		if back := fromTwosComplement(b); back.Cmp(n) != 0 {
+			t.Errorf("%s: read back %s", tt.n, back)
+		}
+	}
+}
+
+func TestUnscaledDecimal(t *testing.T) {
+	tests := []struct {
+		s     string
+		scale int
+		want  int64
+	}{
+		{"12", 2, 1200},
+		{"1.5", 2, 150},
+		{"1.005", 2, 101},
+		{"-1.005", 2, -101},
+		{"-0.004", 2, 0},
+		{" 3.14159 ", 0, 3},
+	}
+	for _, tt := range tests {
+		n, err := unscaledDecimal(tt.s, tt.scale)
+		if err != nil || n.Int64() != tt.want {
+			t.Errorf("unscaledDecimal(%q, %d) = %v %v, want %d", tt.s, tt.scale, n, err, tt.want)
+		}
+	}
+	if _, err := unscaledDecimal("1,5", 2); err == nil {
+		t.Error("1,5 is read as a decimal")
+	}
+}
+
+func TestParquetRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.parquet")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the source order is not the sorted order of the parquet group
+	cols := []string{"name", "id", "amount", "big", "at", "day", "ok", "note"}
+	m := &Migrater{TargetFile: bufio.NewWriter(f), fileTypes: map[string]string{
+		"id": "integer", "amount": "numeric(10,2)", "big": "numeric(30,5)",
+		"at": "timestamp without time zone", "day": "date", "ok": "boolean",
+	}}
+	w, err := m.newParquetWriter(cols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := []map[string]interface{}{
+		{"name": "a", "id": "1", "amount": "1.5", "big": "-123456789012345678901.5", "at": "2024-03-01 10:30:00.5", "day": "2024-03-01", "ok": "true", "note": nil},
+		{"name": "b", "id": int64(-2), "amount": "-0.01", "big": "0", "at": nil, "day": nil, "ok": false, "note": "x"},
+		// the far dates are out of the range of a time.Duration
+		{"name": "c", "id": "3", "amount": "0", "big": "0", "at": "9999-12-31 23:59:59.999999", "day": "9999-12-31", "ok": true, "note": nil},
+		{"name": "d", "id": "4", "amount": "0", "big": "0", "at": "0001-01-01 00:00:00", "day": "0001-01-01", "ok": true, "note": nil},
+	}
+	if err = w.Write(records); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	source := &Migrater{SourceFilePath: path}
+	r, err := source.newParquetReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if !reflect.DeepEqual(r.Columns(), cols) {
+		t.Errorf("columns %v, want the source order %v", r.Columns(), cols)
+	}
+	if want := "numeric(30,5)"; source.fileTypes["big"] != want {
+		t.Errorf("big is read as %s, want %s", source.fileTypes["big"], want)
+	}
+	want := []map[string]interface{}{
+		{"name": "a", "id": int64(1), "amount": "1.50", "big": "-123456789012345678901.50000",
+			"at": time.Date(2024, 3, 1, 10, 30, 0, 500000000, time.UTC), "day": time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), "ok": true, "note": nil},
+		{"name": "b", "id": int64(-2), "amount": "-0.01", "big": "0.00000", "at": nil, "day": nil, "ok": false, "note": "x"},
+		{"name": "c", "id": int64(3), "amount": "0.00", "big": "0.00000", "at": time.Date(9999, 12, 31, 23, 59, 59, 999999000, time.UTC),
+			"day": time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC), "ok": true, "note": nil},
+		{"name": "d", "id": int64(4), "amount": "0.00", "big": "0.00000", "at": time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC),
+			"day": time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC), "ok": true, "note": nil},
+	}
+	for i, w := range want {
+		got, err := r.Read()
+		if err != nil || !reflect.DeepEqual(got, w) {
+			t.Errorf("record %d: %v %v, want %v", i, got, err, w)
+		}
+	}
+	if _, err = r.Read(); err != io.EOF {
+		t.Errorf("got %v after the last record, want io.EOF", err)
+	}
+}
+
+func TestParquetValueRange(t *testing.T) {
+	tests := []struct {
+		datatype string
+		v        interface{}
+	}{
+		{"smallint", "40000"},
+		{"integer", int64(1) << 40},
+		{"numeric(5,2)", "12345678901234567890"},
+		{"boolean", "maybe"},
+	}
+	for _, tt := range tests {
+		_, scale, size := parquetNode(tt.datatype)
+		c := parquetColumn{name: "c", datatype: tt.datatype, scale: scale, size: size}
+		if _, err := c.value(tt.v); err == nil {
+			t.Errorf("%s %v is written", tt.datatype, tt.v)
+		}
+	}
+}
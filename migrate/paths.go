@@ -0,0 +1,296 @@
+package migrate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/clbanning/mxj"
+	"github.com/ohler55/ojg/jp"
+)
+
+// recordField is a column taken from every record with an xpath or jsonpath expression
+type recordField struct {
+	name string
+	expr string
+}
+
+// parseRecordFields reads the fields given as name=expression, the expression may itself hold
+// an = as in Phone[@type='work']
+func parseRecordFields(list []string) ([]recordField, error) {
+	fields := make([]recordField, 0, len(list))
+	seen := map[string]bool{}
+	for _, item := range list {
+		i := strings.Index(item, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("Please provide the field %q as name=expression", item)
+		}
+		f := recordField{name: strings.TrimSpace(item[:i]), expr: strings.TrimSpace(item[i+1:])}
+		if f.name == "" || f.expr == "" {
+			return nil, fmt.Errorf("Please provide the field %q as name=expression", item)
+		}
+		if seen[f.name] {
+			return nil, fmt.Errorf("Field %s appears twice", f.name)
+		}
+		seen[f.name] = true
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+func recordFieldNames(fields []recordField) []string {
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = f.name
+	}
+	return names
+}
+
+// xpathReader streams the elements of an xml document matching the record xpath. Every field
+// is an xpath relative to the record, without fields the record is flattened like a <Root>
+// document and the records are read ahead to take the columns of all of them.
+type xpathReader struct {
+	parser  *xmlquery.StreamParser
+	fields  []recordField
+	exprs   []*xpath.Expr
+	columns []string
+	arrays  string
+	rows    *flatRows
+	record  int
+}
+
+func (m *Migrater) newXPathReader() (*xpathReader, error) {
+	fields, err := parseRecordFields(m.SourceFileFields)
+	if err != nil {
+		return nil, err
+	}
+	x := &xpathReader{fields: fields, arrays: m.SourceFileArrays}
+	for _, f := range fields {
+		expr, err := xpath.Compile(f.expr)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid xpath of field %s (%s)", f.name, err)
+		}
+		x.exprs = append(x.exprs, expr)
+	}
+	if x.parser, err = xmlquery.CreateStreamParser(m.SourceFile, m.SourceFileRecordPath); err != nil {
+		return nil, fmt.Errorf("Invalid xpath of source.file.record_path (%s)", err)
+	}
+	if len(fields) > 0 {
+		x.columns = recordFieldNames(fields)
+		return x, nil
+	}
+	if x.rows, err = spoolRows(x.decode); err != nil {
+		return nil, err
+	}
+	x.columns = x.rows.columns
+	return x, nil
+}
+
+// decode reads the rows of the next record, io.EOF after the last one
+func (x *xpathReader) decode() ([]map[string]interface{}, error) {
+	node, err := x.parser.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, fmt.Errorf("Error reading xml record %d (%s)", x.record+1, err)
+	}
+	x.record++
+	if len(x.fields) > 0 {
+		return []map[string]interface{}{x.selectFields(node)}, nil
+	}
+	doc, err := mxj.NewMapXml([]byte(node.OutputXML(true)))
+	if err != nil {
+		return nil, fmt.Errorf("Error reading xml record %d (%s)", x.record, err)
+	}
+	for _, v := range doc {
+		switch val := v.(type) {
+		case map[string]interface{}:
+			return flatten(val, x.arrays), nil
+		default:
+			// a record holding only text
+			return []map[string]interface{}{{node.Data: val}}, nil
+		}
+	}
+	return []map[string]interface{}{{}}, nil
+}
+
+// selectFields evaluates the fields on the record, the text of the first matching node is the
+// value and no match is NULL
+func (x *xpathReader) selectFields(node *xmlquery.Node) map[string]interface{} {
+	mp := make(map[string]interface{}, len(x.fields))
+	for i, expr := range x.exprs {
+		var value interface{}
+		switch v := expr.Evaluate(xmlquery.CreateXPathNavigator(node)).(type) {
+		case *xpath.NodeIterator:
+			if v.MoveNext() {
+				value = v.Current().Value()
+			}
+		case float64:
+			// count() and sum() give whole numbers as floats
+			value = v
+			if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+				value = int64(v)
+			}
+		default:
+			value = v
+		}
+		mp[x.fields[i].name] = value
+	}
+	return mp
+}
+
+func (x *xpathReader) Columns() []string { return x.columns }
+
+func (x *xpathReader) Read() (map[string]interface{}, error) {
+	if x.rows != nil {
+		return x.rows.Read()
+	}
+	// a record gives a single row with fields
+	rows, err := x.decode()
+	if err != nil {
+		return nil, err
+	}
+	return rows[0], nil
+}
+
+func (x *xpathReader) Close() error {
+	if x.rows != nil {
+		return x.rows.Close()
+	}
+	return nil
+}
+
+// jsonPathReader reads the values matching the record jsonpath of the json documents of a file,
+// a single document, json lines or documents one after the other. Every field is a jsonpath
+// evaluated on the record, without fields the record is flattened and the columns are those of
+// all the records. One document at a time is read into memory.
+type jsonPathReader struct {
+	decoder *json.Decoder
+	path    jp.Expr
+	// records are the records of the current document not read yet
+	records []interface{}
+	fields  []recordField
+	exprs   []jp.Expr
+	columns []string
+	arrays  string
+	rows    *flatRows
+	record  int
+}
+
+func (m *Migrater) newJSONPathReader() (*jsonPathReader, error) {
+	fields, err := parseRecordFields(m.SourceFileFields)
+	if err != nil {
+		return nil, err
+	}
+	j := &jsonPathReader{fields: fields, arrays: m.SourceFileArrays}
+	for _, f := range fields {
+		expr, err := jp.ParseString(f.expr)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid jsonpath of field %s (%s)", f.name, err)
+		}
+		j.exprs = append(j.exprs, expr)
+	}
+	path, err := jp.ParseString(m.SourceFileRecordPath)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid jsonpath of source.file.record_path (%s)", err)
+	}
+
+	j.path = path
+	j.decoder = json.NewDecoder(m.SourceFile)
+	j.decoder.UseNumber()
+
+	if len(fields) > 0 {
+		j.columns = recordFieldNames(fields)
+		return j, nil
+	}
+	if j.rows, err = spoolRows(j.decode); err != nil {
+		return nil, err
+	}
+	j.columns = j.rows.columns
+	return j, nil
+}
+
+// decode returns the rows of the next record, io.EOF after the last one of the last document
+func (j *jsonPathReader) decode() ([]map[string]interface{}, error) {
+	for len(j.records) == 0 {
+		var doc interface{}
+		if err := j.decoder.Decode(&doc); err == io.EOF {
+			return nil, io.EOF
+		} else if err != nil {
+			return nil, fmt.Errorf("Error reading json file (%s)", err)
+		}
+		j.records = j.path.Get(doc)
+		// a path to an array, $.orders, selects its elements
+		if len(j.records) == 1 {
+			if items, ok := j.records[0].([]interface{}); ok {
+				j.records = items
+			}
+		}
+	}
+	record := j.records[0]
+	j.records = j.records[1:]
+	j.record++
+	if len(j.fields) > 0 {
+		return []map[string]interface{}{j.selectFields(record)}, nil
+	}
+	mp, ok := record.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Json record %d is not an object, please provide source.file.fields", j.record)
+	}
+	rows := flatten(mp, j.arrays)
+	for _, row := range rows {
+		for k, v := range row {
+			row[k] = jsonValue(v)
+		}
+	}
+	return rows, nil
+}
+
+// selectFields evaluates the fields on the record, the first match is the value and no match
+// is NULL. Objects and arrays are kept as json.
+func (j *jsonPathReader) selectFields(record interface{}) map[string]interface{} {
+	mp := make(map[string]interface{}, len(j.fields))
+	for i, expr := range j.exprs {
+		var value interface{}
+		if found := expr.Get(record); len(found) > 0 {
+			value = found[0]
+		}
+		switch v := value.(type) {
+		case map[string]interface{}, []interface{}:
+			b, err := json.Marshal(v)
+			if err == nil {
+				value = string(b)
+			}
+		default:
+			value = jsonValue(v)
+		}
+		mp[j.fields[i].name] = value
+	}
+	return mp
+}
+
+func (j *jsonPathReader) Columns() []string { return j.columns }
+
+func (j *jsonPathReader) Read() (map[string]interface{}, error) {
+	if j.rows != nil {
+		return j.rows.Read()
+	}
+	// a record gives a single row with fields
+	rows, err := j.decode()
+	if err != nil {
+		return nil, err
+	}
+	return rows[0], nil
+}
+
+func (j *jsonPathReader) Close() error {
+	if j.rows != nil {
+		return j.rows.Close()
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package migrate
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRecordFields(t *testing.T) {
+	tests := []struct {
+		list    []string
+		want    []recordField
+		wantErr string
+	}{
+		{list: nil, want: []recordField{}},
+		{
+			list: []string{"id=@id", " phone = Phones/Phone[@type='work'] "},
+			want: []recordField{{"id", "@id"}, {"phone", "Phones/Phone[@type='work']"}},
+		},
+		{list: []string{"id"}, wantErr: "as name=expression"},
+		{list: []string{"=@id"}, wantErr: "as name=expression"},
+		{list: []string{"id= "}, wantErr: "as name=expression"},
+		{list: []string{"id=@id", "id=@key"}, wantErr: "Field id appears twice"},
+	}
+	for _, tt := range tests {
+		got, err := parseRecordFields(tt.list)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%v: got %v, want an error containing %q", tt.list, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: got %v %v, want %v", tt.list, got, err, tt.want)
+		}
+	}
+}
+
+func TestPathReaders(t *testing.T) {
+	const xmlSource = `<Export><Customers>
+<Customer id="1"><Name>a</Name><Phones><Phone type="home">1</Phone><Phone type="work">2</Phone></Phones></Customer>
+<Customer id="2"><Name>b</Name><Email>b@x</Email></Customer>
+</Customers></Export>`
+	const jsonSource = `{"export": {"customers": [
+{"id": 1, "name": "a", "address": {"city": "Oslo"}, "tags": ["x"]},
+{"id": 2, "name": "b", "email": "b@x", "score": 1.5}
+]}}`
+	tests := []struct {
+		name       string
+		sourceType string
+		source     string
+		path       string
+		fields     []string
+		cols       []string
+		want       []map[string]interface{}
+		wantErr    string
+	}{
+		{
+			name: "xpath fields", sourceType: "xml", source: xmlSource, path: "/Export/Customers/Customer",
+			fields: []string{"id=@id", "work=Phones/Phone[@type='work']", "phones=count(Phones/Phone)"},
+			cols:   []string{"id", "work", "phones"},
+			want: []map[string]interface{}{
+				{"id": "1", "work": "2", "phones": int64(2)},
+				{"id": "2", "work": nil, "phones": int64(0)},
+			},
+		},
+		{
+			// the columns are those of all the records, not only of the first one
+			name: "xpath flattened", sourceType: "xml", source: xmlSource, path: "/Export/Customers/Customer",
+			cols: []string{"@id", "Email", "Name", "Phones.Phone.0", "Phones.Phone.0.@type", "Phones.Phone.1", "Phones.Phone.1.@type"},
+			want: []map[string]interface{}{
+				{"@id": "1", "Name": "a", "Phones.Phone.0": "1", "Phones.Phone.0.@type": "home", "Phones.Phone.1": "2", "Phones.Phone.1.@type": "work"},
+				{"@id": "2", "Name": "b", "Email": "b@x"},
+			},
+		},
+		{name: "xpath no records", sourceType: "xml", source: xmlSource, path: "/Export/Orders/Order"},
+		{name: "xpath record path", sourceType: "xml", source: xmlSource, path: "/Export[", wantErr: "record_path"},
+		{name: "xpath field", sourceType: "xml", source: xmlSource, path: "/Export", fields: []string{"id=@id["}, wantErr: "field id"},
+		{
+			name: "jsonpath fields", sourceType: "json", source: jsonSource, path: "$.export.customers",
+			fields: []string{"id=$.id", "city=$.address.city", "tags=$.tags"},
+			cols:   []string{"id", "city", "tags"},
+			want: []map[string]interface{}{
+				{"id": int64(1), "city": "Oslo", "tags": `["x"]`},
+				{"id": int64(2), "city": nil, "tags": nil},
+			},
+		},
+		{
+			name: "jsonpath flattened", sourceType: "json", source: jsonSource, path: "$.export.customers",
+			cols: []string{"address.city", "email", "id", "name", "score", "tags.0"},
+			want: []map[string]interface{}{
+				{"id": int64(1), "name": "a", "address.city": "Oslo", "tags.0": "x"},
+				{"id": int64(2), "name": "b", "email": "b@x", "score": 1.5},
+			},
+		},
+		{name: "jsonpath no records", sourceType: "json", source: jsonSource, path: "$.export.orders"},
+		{
+			// the path is applied to every document of json lines or concatenated documents
+			name: "jsonpath json lines", sourceType: "json", path: "$.customer",
+			source: `{"customer": {"id": 1}}` + "\n" + `{"other": 2}` + "\n" + `{"customer": {"id": 2, "name": "b"}}{"customer": [{"id": 3}]}`,
+			cols:   []string{"id", "name"},
+			want:   []map[string]interface{}{{"id": int64(1)}, {"id": int64(2), "name": "b"}, {"id": int64(3)}},
+		},
+		{
+			name: "jsonpath fields of json lines", sourceType: "json", path: "$", fields: []string{"id=$.id"},
+			source: "{\"id\": 1}\n{\"id\": 2}\n",
+			cols:   []string{"id"},
+			want:   []map[string]interface{}{{"id": int64(1)}, {"id": int64(2)}},
+		},
+		{name: "jsonpath empty file", sourceType: "json", source: "", path: "$.export.customers"},
+		{name: "jsonpath not objects", sourceType: "json", source: `{"ids": [1, 2]}`, path: "$.ids", wantErr: "not an object"},
+	}
+	for _, tt := range tests {
+		m := &Migrater{SourceFileType: tt.sourceType, SourceFile: bufio.NewReader(strings.NewReader(tt.source)),
+			SourceFileRecordPath: tt.path, SourceFileFields: tt.fields}
+		r, err := m.newReader()
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: got %v, want an error containing %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if len(r.Columns()) != len(tt.cols) || (len(tt.cols) > 0 && !reflect.DeepEqual(r.Columns(), tt.cols)) {
+			t.Errorf("%s: columns %v, want %v", tt.name, r.Columns(), tt.cols)
+		}
+		var got []map[string]interface{}
+		for {
+			record, err := r.Read()
+			if err != nil {
+				break
+			}
+			got = append(got, record)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+		r.Close()
+	}
+}
@@ -0,0 +1,509 @@
+package migrate
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pgbinarySignature starts every file of the COPY binary format
+const pgbinarySignature = "PGCOPY\n\377\r\n\000"
+
+// pgbinaryEpoch is the zero of the dates and timestamps of the binary format
+var pgbinaryEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Signs of a binary numeric
+const (
+	numericPositive = 0x0000
+	numericNegative = 0x4000
+	numericNaN      = 0xC000
+	numericPosInf   = 0xD000
+	numericNegInf   = 0xF000
+)
+
+// typeModifier matches the length, precision or scale of a type name, e.g. (20) or (10,2)
+var typeModifier = regexp.MustCompile(`\s*\(\s*\d+\s*(,\s*\d+\s*)?\)`)
+
+// binaryTypeAliases maps the short type names to those of format_type
+var binaryTypeAliases = map[string]string{
+	"int2":        "smallint",
+	"int":         "integer",
+	"int4":        "integer",
+	"int8":        "bigint",
+	"float4":      "real",
+	"float8":      "double precision",
+	"bool":        "boolean",
+	"varchar":     "character varying",
+	"char":        "character",
+	"bpchar":      "character",
+	"decimal":     "numeric",
+	"timestamp":   "timestamp without time zone",
+	"timestamptz": "timestamp with time zone",
+}
+
+// binaryType reduces a postgres type name to the name the binary format is chosen by
+func binaryType(datatype string) string {
+	t := strings.Join(strings.Fields(typeModifier.ReplaceAllString(strings.ToLower(datatype), "")), " ")
+	if alias, ok := binaryTypeAliases[t]; ok {
+		return alias
+	}
+	return t
+}
+
+// pgbinaryReader reads the output of COPY ... TO with FORMAT binary. The file carries no column
+// names or types, they are taken from the configured columns.
+type pgbinaryReader struct {
+	reader  *bufio.Reader
+	columns []fileColumn
+	tuple   int
+}
+
+func (m *Migrater) newPGBinaryReader() (*pgbinaryReader, error) {
+	p := &pgbinaryReader{reader: m.SourceFile, columns: parseFileColumns(m.SourceFileColumns)}
+	signature := make([]byte, len(pgbinarySignature))
+	if _, err := io.ReadFull(p.reader, signature); err != nil || string(signature) != pgbinarySignature {
+		return nil, errors.New("Source file is not in the COPY binary format")
+	}
+	var flags, extension int32
+	if err := binary.Read(p.reader, binary.BigEndian, &flags); err != nil {
+		return nil, err
+	}
+	if flags&(1<<16) != 0 {
+		return nil, errors.New("COPY binary files WITH OIDS are not supported")
+	}
+	if err := binary.Read(p.reader, binary.BigEndian, &extension); err != nil {
+		return nil, err
+	}
+	if _, err := p.reader.Discard(int(extension)); err != nil {
+		return nil, err
+	}
+	m.fileTypes = map[string]string{}
+	for _, c := range p.columns {
+		m.fileTypes[c.name] = c.datatype
+	}
+	return p, nil
+}
+
+func (p *pgbinaryReader) Columns() []string { return fileColumnNames(p.columns) }
+
+func (p *pgbinaryReader) Read() (map[string]interface{}, error) {
+	var count int16
+	if err := binary.Read(p.reader, binary.BigEndian, &count); err != nil {
+		if err == io.EOF {
+			return nil, errors.New("COPY binary file ends without a trailer")
+		}
+		return nil, err
+	}
+	if count == -1 {
+		return nil, io.EOF
+	}
+	p.tuple++
+	if int(count) != len(p.columns) {
+		return nil, fmt.Errorf("Error (tuple %d) holds %d fields for %d columns", p.tuple, count, len(p.columns))
+	}
+	mp := make(map[string]interface{}, count)
+	for _, col := range p.columns {
+		var length int32
+		if err := binary.Read(p.reader, binary.BigEndian, &length); err != nil {
+			return nil, err
+		}
+		if length == -1 {
+			mp[col.name] = nil
+			continue
+		}
+		field := make([]byte, length)
+		if _, err := io.ReadFull(p.reader, field); err != nil {
+			return nil, err
+		}
+		v, err := decodeBinary(col.datatype, field)
+		if err != nil {
+			return nil, fmt.Errorf("Error (tuple %d) in column %s (%s)", p.tuple, col.name, err)
+		}
+		mp[col.name] = v
+	}
+	return mp, nil
+}
+
+func (p *pgbinaryReader) Close() error { return nil }
+
+// pgbinaryWriter writes the records in the COPY binary format. The fields are encoded for the
+// type of the source column, the file loads into a table with the same column types.
+type pgbinaryWriter struct {
+	writer  *bufio.Writer
+	columns []fileColumn
+}
+
+func (m *Migrater) newPGBinaryWriter(cols []string) (*pgbinaryWriter, error) {
+	types, err := m.sourceTypeNames(cols)
+	if err != nil {
+		return nil, err
+	}
+	p := &pgbinaryWriter{writer: m.TargetFile}
+	for _, col := range cols {
+		datatype := types[col]
+		if datatype == "" {
+			datatype = "text"
+		}
+		p.columns = append(p.columns, fileColumn{name: col, datatype: datatype})
+	}
+	p.writer.WriteString(pgbinarySignature)
+	// no flags and no header extension
+	binary.Write(p.writer, binary.BigEndian, [2]int32{0, 0})
+	return p, nil
+}
+
+func (p *pgbinaryWriter) Write(records []map[string]interface{}) error {
+	for _, record := range records {
+		binary.Write(p.writer, binary.BigEndian, int16(len(p.columns)))
+		for _, col := range p.columns {
+			v := record[col.name]
+			if v == nil {
+				binary.Write(p.writer, binary.BigEndian, int32(-1))
+				continue
+			}
+			field, err := encodeBinary(col.datatype, v)
+			if err != nil {
+				return fmt.Errorf("Error (%s) writing column %s", err, col.name)
+			}
+			binary.Write(p.writer, binary.BigEndian, int32(len(field)))
+			p.writer.Write(field)
+		}
+	}
+	return p.writer.Flush()
+}
+
+func (p *pgbinaryWriter) Close() error {
+	binary.Write(p.writer, binary.BigEndian, int16(-1))
+	return p.writer.Flush()
+}
+
+// encodeBinary encodes a value in the binary send format of datatype
+func encodeBinary(datatype string, v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	switch t := binaryType(datatype); t {
+	case "smallint", "integer", "bigint":
+		n, err := binaryInt(v)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case t == "smallint" && (n < math.MinInt16 || n > math.MaxInt16),
+			t == "integer" && (n < math.MinInt32 || n > math.MaxInt32):
+			return nil, fmt.Errorf("%d is out of range for %s", n, t)
+		case t == "smallint":
+			binary.Write(&buf, binary.BigEndian, int16(n))
+		case t == "integer":
+			binary.Write(&buf, binary.BigEndian, int32(n))
+		default:
+			binary.Write(&buf, binary.BigEndian, n)
+		}
+	case "real", "double precision":
+		f, err := binaryFloat(v)
+		if err != nil {
+			return nil, err
+		}
+		if t == "real" {
+			binary.Write(&buf, binary.BigEndian, float32(f))
+		} else {
+			binary.Write(&buf, binary.BigEndian, f)
+		}
+	case "boolean":
+		b, ok := v.(bool)
+		if !ok {
+			var err error
+			if b, err = strconv.ParseBool(toString(v)); err != nil {
+				return nil, fmt.Errorf("%q is not a boolean", toString(v))
+			}
+		}
+		if b {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	case "date", "timestamp without time zone", "timestamp with time zone":
+		tm, err := binaryTime(v)
+		if err != nil {
+			return nil, err
+		}
+		if t != "timestamp with time zone" {
+			tm = wallClock(tm)
+		}
+		micros := (tm.Unix()-pgbinaryEpoch.Unix())*1e6 + int64(tm.Nanosecond()/1e3)
+		if t == "date" {
+			binary.Write(&buf, binary.BigEndian, int32(math.Floor(float64(micros)/86400e6)))
+		} else {
+			binary.Write(&buf, binary.BigEndian, micros)
+		}
+	case "numeric":
+		return encodeNumeric(toString(v))
+	case "uuid":
+		id, err := hex.DecodeString(strings.Replace(strings.Trim(toString(v), "{}"), "-", "", -1))
+		if err != nil || len(id) != 16 {
+			return nil, fmt.Errorf("%q is not a uuid", toString(v))
+		}
+		buf.Write(id)
+	case "jsonb":
+		// version of the jsonb format
+		buf.WriteByte(1)
+		buf.WriteString(toString(v))
+	case "bytea", "text", "character varying", "character", "name", "json", "xml", "citext":
+		buf.WriteString(toString(v))
+	default:
+		return nil, fmt.Errorf("The COPY binary format does not support the type %s", datatype)
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeBinary decodes a field in the binary send format of datatype
+func decodeBinary(datatype string, field []byte) (interface{}, error) {
+	wrongLength := fmt.Errorf("A %s field cannot be %d bytes long", datatype, len(field))
+	switch t := binaryType(datatype); t {
+	case "smallint":
+		if len(field) != 2 {
+			return nil, wrongLength
+		}
+		return int64(int16(binary.BigEndian.Uint16(field))), nil
+	case "integer", "date":
+		if len(field) != 4 {
+			return nil, wrongLength
+		}
+		n := int64(int32(binary.BigEndian.Uint32(field)))
+		if t == "date" {
+			return pgbinaryEpoch.AddDate(0, 0, int(n)), nil
+		}
+		return n, nil
+	case "bigint", "timestamp without time zone", "timestamp with time zone":
+		if len(field) != 8 {
+			return nil, wrongLength
+		}
+		n := int64(binary.BigEndian.Uint64(field))
+		if t == "bigint" {
+			return n, nil
+		}
+		return time.Unix(pgbinaryEpoch.Unix()+n/1e6, n%1e6*1e3).UTC(), nil
+	case "real":
+		if len(field) != 4 {
+			return nil, wrongLength
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(field))), nil
+	case "double precision":
+		if len(field) != 8 {
+			return nil, wrongLength
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(field)), nil
+	case "boolean":
+		if len(field) != 1 {
+			return nil, wrongLength
+		}
+		return field[0] != 0, nil
+	case "numeric":
+		return decodeNumeric(field)
+	case "uuid":
+		if len(field) != 16 {
+			return nil, wrongLength
+		}
+		s := hex.EncodeToString(field)
+		return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:], nil
+	case "jsonb":
+		if len(field) == 0 || field[0] != 1 {
+			return nil, errors.New("Unsupported jsonb version")
+		}
+		return string(field[1:]), nil
+	case "bytea", "text", "character varying", "character", "name", "json", "xml", "citext":
+		return string(field), nil
+	}
+	return nil, fmt.Errorf("The COPY binary format does not support the type %s", datatype)
+}
+
+func binaryInt(v interface{}) (int64, error) {
+	switch val := v.(type) {
+	case int64:
+		return val, nil
+	case int:
+		return int64(val), nil
+	case int32:
+		return int64(val), nil
+	case float64:
+		if val == math.Trunc(val) {
+			return int64(val), nil
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(toString(v)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not an integer", toString(v))
+	}
+	return n, nil
+}
+
+func binaryFloat(v interface{}) (float64, error) {
+	switch val := v.(type) {
+	case float64:
+		return val, nil
+	case float32:
+		return float64(val), nil
+	case int64:
+		return float64(val), nil
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(toString(v)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", toString(v))
+	}
+	return f, nil
+}
+
+// binaryTimeLayouts are the layouts a date or timestamp given as text is parsed with
+var binaryTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999Z07",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+}
+
+// wallClock is tm in UTC with the same wall clock, whatever the zone of tm, as a date or a
+// timestamp without time zone keeps the wall clock
+func wallClock(tm time.Time) time.Time {
+	return time.Date(tm.Year(), tm.Month(), tm.Day(), tm.Hour(), tm.Minute(), tm.Second(), tm.Nanosecond(), time.UTC)
+}
+
+// unixTime is the time n units after the unix epoch, in seconds and nanoseconds as a
+// time.Duration only spans the years 1677 to 2262
+func unixTime(n int64, unit time.Duration) time.Time {
+	perSecond := int64(time.Second / unit)
+	return time.Unix(n/perSecond, n%perSecond*int64(unit)).UTC()
+}
+
+func binaryTime(v interface{}) (time.Time, error) {
+	if tm, ok := v.(time.Time); ok {
+		return tm, nil
+	}
+	s := strings.TrimSpace(toString(v))
+	for _, layout := range binaryTimeLayouts {
+		if tm, err := time.Parse(layout, s); err == nil {
+			return tm, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a date or timestamp", s)
+}
+
+// encodeNumeric encodes a decimal as base 10000 digits around the decimal point
+func encodeNumeric(s string) ([]byte, error) {
+	var buf bytes.Buffer
+	s = strings.TrimSpace(s)
+	header := func(ndigits, weight, sign, dscale int) {
+		binary.Write(&buf, binary.BigEndian, [4]uint16{uint16(ndigits), uint16(int16(weight)), uint16(sign), uint16(dscale)})
+	}
+	switch strings.ToLower(s) {
+	case "nan":
+		header(0, 0, numericNaN, 0)
+		return buf.Bytes(), nil
+	case "infinity", "+infinity":
+		header(0, 0, numericPosInf, 0)
+		return buf.Bytes(), nil
+	case "-infinity":
+		header(0, 0, numericNegInf, 0)
+		return buf.Bytes(), nil
+	}
+
+	sign := numericPositive
+	if strings.HasPrefix(s, "-") {
+		sign = numericNegative
+	}
+	number := strings.TrimLeft(s, "+-")
+	whole, fraction := number, ""
+	if i := strings.Index(number, "."); i >= 0 {
+		whole, fraction = number[:i], number[i+1:]
+	}
+	if whole+fraction == "" || strings.Trim(whole+fraction, "0123456789") != "" || len(s)-len(number) > 1 {
+		return nil, fmt.Errorf("%q is not a numeric", s)
+	}
+	dscale := len(fraction)
+	whole = strings.TrimLeft(whole, "0")
+	if r := len(whole) % 4; r != 0 {
+		whole = strings.Repeat("0", 4-r) + whole
+	}
+	if r := len(fraction) % 4; r != 0 {
+		fraction += strings.Repeat("0", 4-r)
+	}
+	weight := len(whole)/4 - 1
+	var digits []uint16
+	for i := 0; i < len(whole+fraction); i += 4 {
+		d, _ := strconv.Atoi((whole + fraction)[i : i+4])
+		digits = append(digits, uint16(d))
+	}
+	for len(digits) > 0 && digits[0] == 0 {
+		digits = digits[1:]
+		weight--
+	}
+	for len(digits) > 0 && digits[len(digits)-1] == 0 {
+		digits = digits[:len(digits)-1]
+	}
+	if len(digits) == 0 {
+		weight, sign = 0, numericPositive
+	}
+	header(len(digits), weight, sign, dscale)
+	binary.Write(&buf, binary.BigEndian, digits)
+	return buf.Bytes(), nil
+}
+
+// decodeNumeric formats a binary numeric as a decimal with its display scale
+func decodeNumeric(field []byte) (string, error) {
+	if len(field) < 8 {
+		return "", errors.New("A numeric field needs at least 8 bytes")
+	}
+	ndigits := int(binary.BigEndian.Uint16(field))
+	weight := int(int16(binary.BigEndian.Uint16(field[2:])))
+	sign := int(binary.BigEndian.Uint16(field[4:]))
+	dscale := int(binary.BigEndian.Uint16(field[6:]))
+	if len(field) != 8+2*ndigits {
+		return "", fmt.Errorf("A numeric of %d digits cannot be %d bytes long", ndigits, len(field))
+	}
+	switch sign {
+	case numericNaN:
+		return "NaN", nil
+	case numericPosInf:
+		return "Infinity", nil
+	case numericNegInf:
+		return "-Infinity", nil
+	}
+	digit := func(i int) int {
+		if i < 0 || i >= ndigits {
+			return 0
+		}
+		return int(binary.BigEndian.Uint16(field[8+2*i:]))
+	}
+
+	var b strings.Builder
+	if sign == numericNegative {
+		b.WriteByte('-')
+	}
+	if weight < 0 {
+		b.WriteByte('0')
+	}
+	for i := 0; i <= weight; i++ {
+		if i == 0 {
+			b.WriteString(strconv.Itoa(digit(i)))
+		} else {
+			fmt.Fprintf(&b, "%04d", digit(i))
+		}
+	}
+	if dscale > 0 {
+		var fraction strings.Builder
+		for i := weight + 1; fraction.Len() < dscale; i++ {
+			fmt.Fprintf(&fraction, "%04d", digit(i))
+		}
+		b.WriteByte('.')
+		b.WriteString(fraction.String()[:dscale])
+	}
+	return b.String(), nil
+}
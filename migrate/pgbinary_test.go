@@ -0,0 +1,251 @@
+package migrate
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncodeNumeric(t *testing.T) {
+	// ndigits 3, weight 1, positive, dscale 3, digits 1 2345 6780
+	field, err := encodeNumeric("12345.678")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := hex.EncodeToString(field), "0003000100000003000109291a7c"; got != want {
+		t.Errorf("12345.678 is encoded as %s, want %s", got, want)
+	}
+
+	tests := []struct {
+		in, want string
+	}{
+		{"0", "0"},
+		{"0.00", "0.00"},
+		{"-0.5", "-0.5"},
+		{"10000", "10000"},
+		{"0.0001", "0.0001"},
+		{"-98765432109876543210.123456789", "-98765432109876543210.123456789"},
+		{"007.10", "7.10"},
+		{"+3", "3"},
+		{"NaN", "NaN"},
+		{"infinity", "Infinity"},
+		{"-Infinity", "-Infinity"},
+	}
+	for _, tt := range tests {
+		field, err := encodeNumeric(tt.in)
+		if err != nil {
+			t.Errorf("%s: %s", tt.in, err)
+			continue
+		}
+		if got, err := decodeNumeric(field); err != nil || got != tt.want {
+			t.Errorf("%s: read back %q %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"", "1.2.3", "--1", "1e5", "abc"} {
+		if _, err := encodeNumeric(bad); err == nil {
+			t.Errorf("%q is encoded", bad)
+		}
+	}
+	if _, err := decodeNumeric([]byte{0, 2, 0, 0, 0, 0, 0, 0, 0, 1}); err == nil {
+		t.Error("a numeric missing a digit is decoded")
+	}
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	moment := time.Date(2024, 3, 1, 10, 30, 0, 123456000, time.UTC)
+	tests := []struct {
+		datatype string
+		in, want interface{}
+	}{
+		{"smallint", "-32768", int64(-32768)},
+		{"integer", int64(42), int64(42)},
+		{"int8", "9223372036854775807", int64(9223372036854775807)},
+		{"real", "1.5", 1.5},
+		{"double precision", math.Inf(-1), math.Inf(-1)},
+		{"boolean", "true", true},
+		{"bool", false, false},
+		{"date", "1999-12-31", time.Date(1999, 12, 31, 0, 0, 0, 0, time.UTC)},
+		{"timestamp", "2024-03-01 10:30:00.123456", moment},
+		{"timestamp with time zone", "2024-03-01T12:30:00.123456+02:00", moment},
+		{"numeric(10,2)", "1.50", "1.50"},
+		{"uuid", "{A0EEBC99-9C0B-4EF8-BB6D-6BB9BD380A11}", "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"},
+		{"jsonb", `{"a": 1}`, `{"a": 1}`},
+		{"character varying(20)", "héllo", "héllo"},
+		{"bytea", "\x00\xff", "\x00\xff"},
+	}
+	for _, tt := range tests {
+		field, err := encodeBinary(tt.datatype, tt.in)
+		if err != nil {
+			t.Errorf("%s %v: %s", tt.datatype, tt.in, err)
+			continue
+		}
+		got, err := decodeBinary(tt.datatype, field)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s %v: read back %#v %v, want %#v", tt.datatype, tt.in, got, err, tt.want)
+		}
+	}
+
+	errors := []struct {
+		datatype string
+		in       interface{}
+	}{
+		{"smallint", "40000"},
+		{"integer", int64(math.MaxInt32 + 1)},
+		{"integer", "1.5"},
+		{"boolean", "maybe"},
+		{"uuid", "abc"},
+		{"date", "yesterday"},
+		{"interval", "1 day"},
+	}
+	for _, tt := range errors {
+		if _, err := encodeBinary(tt.datatype, tt.in); err == nil {
+			t.Errorf("%s %v is encoded", tt.datatype, tt.in)
+		}
+	}
+	if _, err := decodeBinary("integer", []byte{1, 2}); err == nil {
+		t.Error("a 2 byte integer is decoded")
+	}
+}
+
+func TestPGBinaryFile(t *testing.T) {
+	records := []map[string]interface{}{
+		{"id": "1", "amount": "12.50", "note": "a\tb"},
+		{"id": "2", "amount": nil, "note": ""},
+	}
+	var out bytes.Buffer
+	m := &Migrater{TargetFile: bufio.NewWriter(&out), fileTypes: map[string]string{"id": "bigint", "amount": "numeric"}}
+	w, err := m.newPGBinaryWriter([]string{"id", "amount", "note"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Write(records); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	source := &Migrater{
+		SourceFile:        bufio.NewReader(bytes.NewReader(out.Bytes())),
+		SourceFileColumns: []string{"id:bigint", "amount:numeric", "note"},
+	}
+	r, err := source.newPGBinaryReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]interface{}{
+		{"id": int64(1), "amount": "12.50", "note": "a\tb"},
+		{"id": int64(2), "amount": nil, "note": ""},
+	}
+	for i, w := range want {
+		got, err := r.Read()
+		if err != nil || !reflect.DeepEqual(got, w) {
+			t.Errorf("record %d: %v %v, want %v", i, got, err, w)
+		}
+	}
+	if _, err = r.Read(); err != io.EOF {
+		t.Errorf("got %v after the last record, want io.EOF", err)
+	}
+
+	// a file cut before its trailer is an error, not the end of the records
+	cut := &Migrater{SourceFile: bufio.NewReader(bytes.NewReader(out.Bytes()[:out.Len()-2])), SourceFileColumns: source.SourceFileColumns}
+	if r, err = cut.newPGBinaryReader(); err != nil {
+		t.Fatal(err)
+	}
+	r.Read()
+	r.Read()
+	if _, err = r.Read(); err == nil || err == io.EOF {
+		t.Errorf("a file without trailer gives %v", err)
+	}
+	if _, err = (&Migrater{SourceFile: bufio.NewReader(strings.NewReader("id\n1\n"))}).newPGBinaryReader(); err == nil {
+		t.Error("a text file is read as COPY binary")
+	}
+}
+
+func TestPGCopyFile(t *testing.T) {
+	records := []map[string]interface{}{
+		{"id": "1", "note": "tab\there, \"quoted\"\nnext line"},
+		{"id": "2", "note": ""},
+		{"id": "3", "note": nil},
+		{"id": "4", "note": `\.`},
+	}
+	for _, format := range []string{CopyFormatText, CopyFormatCSV} {
+		var out bytes.Buffer
+		m := &Migrater{TargetFile: bufio.NewWriter(&out), TargetFileFormat: format, TargetFileHeader: true}
+		w, err := m.newPGCopyWriter([]string{"id", "note"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = w.Write(records); err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+
+		source := &Migrater{SourceFile: bufio.NewReader(&out), SourceFileFormat: format, SourceFileHeader: true}
+		r, err := source.newPGCopyReader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(r.Columns(), []string{"id", "note"}) {
+			t.Errorf("%s: columns %v", format, r.Columns())
+		}
+		for i, want := range records {
+			got, err := r.Read()
+			if err != nil || !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: record %d: %#v %v, want %#v", format, i, got, err, want)
+			}
+		}
+		if _, err = r.Read(); err != io.EOF {
+			t.Errorf("%s: got %v after the last record, want io.EOF", format, err)
+		}
+	}
+}
+
+func TestPGCopyBytea(t *testing.T) {
+	// not valid utf-8, with a tab, a newline and a backslash among the bytes
+	data := []byte{0x00, 0xff, 0xfe, '\t', '\n', '\\', 0x80}
+	tests := []struct {
+		format string
+		line   string
+	}{
+		{CopyFormatText, "1\t" + `\\x00fffe090a5c80`},
+		{CopyFormatCSV, `1,\x00fffe090a5c80`},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		m := &Migrater{TargetFile: bufio.NewWriter(&out), TargetFileFormat: tt.format, fileTypes: map[string]string{"data": "bytea"}}
+		w, err := m.newPGCopyWriter([]string{"id", "data"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = w.Write([]map[string]interface{}{{"id": "1", "data": data}, {"id": "2", "data": nil}}); err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+		if line := strings.SplitN(out.String(), "\n", 2)[0]; line != tt.line {
+			t.Errorf("%s: wrote %q, want %q", tt.format, line, tt.line)
+		}
+
+		// COPY FROM reads the hex format back into the bytes
+		source := &Migrater{SourceFile: bufio.NewReader(&out), SourceFileFormat: tt.format, SourceFileColumns: []string{"id", "data"}}
+		r, err := source.newPGCopyReader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		record, err := r.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		hexed, _ := record["data"].(string)
+		if got, err := hex.DecodeString(strings.TrimPrefix(hexed, `\x`)); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("%s: read back %q, want %q", tt.format, hexed, data)
+		}
+		if record, err = r.Read(); err != nil || record["data"] != nil {
+			t.Errorf("%s: NULL read back as %#v %v", tt.format, record["data"], err)
+		}
+	}
+}
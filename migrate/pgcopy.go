@@ -0,0 +1,283 @@
+package migrate
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Formats of a pgcopy file, those of COPY ... TO with FORMAT text or FORMAT csv
+const (
+	CopyFormatText = "text"
+	CopyFormatCSV  = "csv"
+)
+
+// copyEndOfData is the line ending the data of a COPY file
+const copyEndOfData = `\.`
+
+// fileColumn is a column of a file without a header, datatype is a postgres type name
+type fileColumn struct {
+	name     string
+	datatype string
+}
+
+// parseFileColumns reads the configured columns of a file, given as name or name:type. The
+// type defaults to text.
+func parseFileColumns(list []string) []fileColumn {
+	var cols []fileColumn
+	for _, item := range list {
+		c := fileColumn{name: strings.TrimSpace(item), datatype: "text"}
+		if i := strings.Index(item, ":"); i >= 0 {
+			c.name = strings.TrimSpace(item[:i])
+			c.datatype = strings.ToLower(strings.TrimSpace(item[i+1:]))
+		}
+		cols = append(cols, c)
+	}
+	return cols
+}
+
+func fileColumnNames(cols []fileColumn) []string {
+	names := make([]string, len(cols))
+	for i, c := range cols {
+		names[i] = c.name
+	}
+	return names
+}
+
+// pgcopyReader reads the output of COPY ... TO in the text or csv format. The columns come from
+// the header line or from the configured columns.
+type pgcopyReader struct {
+	reader  *bufio.Reader
+	format  string
+	columns []string
+	line    int
+}
+
+func (m *Migrater) newPGCopyReader() (*pgcopyReader, error) {
+	p := &pgcopyReader{reader: m.SourceFile, format: m.SourceFileFormat}
+	if p.format == "" {
+		p.format = CopyFormatText
+	}
+	p.columns = fileColumnNames(parseFileColumns(m.SourceFileColumns))
+	if !m.SourceFileHeader {
+		return p, nil
+	}
+	header, err := p.readFields()
+	if err != nil {
+		return nil, fmt.Errorf("Error reading pgcopy header (%s)", err)
+	}
+	// the configured columns win over the header
+	if len(p.columns) == 0 {
+		for _, field := range header {
+			p.columns = append(p.columns, toString(field))
+		}
+	}
+	return p, nil
+}
+
+func (p *pgcopyReader) Columns() []string { return p.columns }
+
+func (p *pgcopyReader) Read() (map[string]interface{}, error) {
+	fields, err := p.readFields()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) != len(p.columns) {
+		return nil, fmt.Errorf("Error (line %d) holds %d fields for %d columns", p.line, len(fields), len(p.columns))
+	}
+	mp := make(map[string]interface{}, len(fields))
+	for i, col := range p.columns {
+		mp[col] = fields[i]
+	}
+	return mp, nil
+}
+
+func (p *pgcopyReader) Close() error { return nil }
+
+// readFields reads the next line, io.EOF at the end of the file or at the end of data marker
+func (p *pgcopyReader) readFields() ([]interface{}, error) {
+	if p.format == CopyFormatCSV {
+		return p.readCSV()
+	}
+	line, err := p.reader.ReadString('\n')
+	if err == io.EOF && line == "" {
+		return nil, io.EOF
+	}
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	p.line++
+	// newlines and carriage returns in the values are escaped, these end the line
+	line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+	if line == copyEndOfData {
+		return nil, io.EOF
+	}
+	raw := strings.Split(line, "\t")
+	fields := make([]interface{}, len(raw))
+	for i, field := range raw {
+		fields[i] = copyUnescape(field)
+	}
+	return fields, nil
+}
+
+// readCSV reads a record of the COPY csv format. An unquoted empty field is NULL, a quoted one
+// is the empty string, and quoted fields may span lines.
+func (p *pgcopyReader) readCSV() ([]interface{}, error) {
+	var fields []interface{}
+	var field bytes.Buffer
+	quoted, inQuote, started := false, false, false
+	p.line++
+	endField := func() {
+		if !quoted && field.Len() == 0 {
+			fields = append(fields, nil)
+		} else {
+			fields = append(fields, field.String())
+		}
+		field.Reset()
+		quoted = false
+	}
+	for {
+		c, err := p.reader.ReadByte()
+		if err == io.EOF {
+			if !started {
+				return nil, io.EOF
+			}
+			if inQuote {
+				return nil, fmt.Errorf("Error (line %d) has an unterminated quoted field", p.line)
+			}
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		started = true
+		if inQuote {
+			if c != '"' {
+				if c == '\n' {
+					p.line++
+				}
+				field.WriteByte(c)
+				continue
+			}
+			if next, _ := p.reader.Peek(1); len(next) == 1 && next[0] == '"' {
+				p.reader.ReadByte()
+				field.WriteByte('"')
+				continue
+			}
+			inQuote = false
+			continue
+		}
+		if c == '"' {
+			inQuote, quoted = true, true
+			continue
+		}
+		if c == ',' {
+			endField()
+			continue
+		}
+		if c == '\r' {
+			if next, _ := p.reader.Peek(1); len(next) == 1 && next[0] == '\n' {
+				continue
+			}
+			break
+		}
+		if c == '\n' {
+			break
+		}
+		field.WriteByte(c)
+	}
+	if len(fields) == 0 && !quoted && field.String() == copyEndOfData {
+		return nil, io.EOF
+	}
+	endField()
+	return fields, nil
+}
+
+// pgcopyWriter writes the records in the text or csv format of COPY ... FROM, ready for \copy
+type pgcopyWriter struct {
+	writer      *bufio.Writer
+	format      string
+	columns     []string
+	header      bool
+	wroteHeader bool
+	// types are the source column types, the bytea columns are written as hex
+	types map[string]string
+}
+
+func (m *Migrater) newPGCopyWriter(cols []string) (*pgcopyWriter, error) {
+	p := &pgcopyWriter{writer: m.TargetFile, format: m.TargetFileFormat, columns: cols, header: m.TargetFileHeader}
+	if p.format == "" {
+		p.format = CopyFormatText
+	}
+	var err error
+	if p.types, err = m.sourceTypeNames(cols); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *pgcopyWriter) Write(records []map[string]interface{}) error {
+	if p.header && !p.wroteHeader {
+		header := make([]interface{}, len(p.columns))
+		for i, col := range p.columns {
+			header[i] = col
+		}
+		p.writeLine(header)
+		p.wroteHeader = true
+	}
+	for _, record := range records {
+		values := make([]interface{}, len(p.columns))
+		for i, col := range p.columns {
+			values[i] = record[col]
+			if p.types[col] == "bytea" && values[i] != nil {
+				values[i] = `\x` + hex.EncodeToString([]byte(toString(values[i])))
+			}
+		}
+		p.writeLine(values)
+	}
+	return p.writer.Flush()
+}
+
+func (p *pgcopyWriter) writeLine(values []interface{}) {
+	fields := make([]string, len(values))
+	sep := "\t"
+	for i, v := range values {
+		if p.format == CopyFormatCSV {
+			fields[i] = copyCSV(v)
+		} else {
+			fields[i] = copyText(v)
+		}
+	}
+	if p.format == CopyFormatCSV {
+		sep = ","
+	}
+	fmt.Fprintln(p.writer, strings.Join(fields, sep))
+}
+
+func (p *pgcopyWriter) Close() error { return p.writer.Flush() }
+
+// copyCSV formats a value of the postgres COPY csv format, NULL is an unquoted empty field
+func copyCSV(v interface{}) string {
+	var s string
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case bool:
+		if val {
+			return "t"
+		}
+		return "f"
+	case time.Time:
+		s = val.Format("2006-01-02 15:04:05.999999Z07:00")
+	default:
+		s = toString(v)
+	}
+	if s == "" || s == copyEndOfData || strings.ContainsAny(s, ",\"\r\n") {
+		return `"` + strings.Replace(s, `"`, `""`, -1) + `"`
+	}
+	return s
+}
@@ -0,0 +1,276 @@
+package migrate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Plan describes what a migration will do without writing anything to the target
+type Plan struct {
+	Job            string   `json:"job,omitempty"`
+	DependsOn      []string `json:"depends_on,omitempty"`
+	Type           string   `json:"type"`
+	Source         string   `json:"source"`
+	Target         string   `json:"target"`
+	Columns        []string `json:"columns"`
+	Tables         []string `json:"tables,omitempty"`
+	SelectSQL      string   `json:"select_sql,omitempty"`
+	CreateTableSQL string   `json:"create_table_sql,omitempty"`
+	SchemaChanges  []string `json:"schema_changes,omitempty"`
+	// EstimatedRows is -1 when the row count cannot be estimated up front, it is taken from the
+	// table statistics unless an exact count is asked for
+	EstimatedRows int64    `json:"estimated_rows"`
+	WriteMode     string   `json:"write_mode"`
+	Transaction   string   `json:"transaction,omitempty"`
+	DedupKeys     []string `json:"dedup_keys,omitempty"`
+	DedupKeep     string   `json:"dedup_keep,omitempty"`
+	Verify        bool     `json:"verify"`
+	Note          string   `json:"note,omitempty"`
+}
+
+// Plan resolves the columns and statements of the migration. Only the source is read, the
+// target database is queried to check if the table exists.
+func (m *Migrater) Plan() (*Plan, error) {
+	defer m.cleanUp()
+	plan := &Plan{
+		Type:          m.Type.String(),
+		EstimatedRows: -1,
+		Verify:        m.Verify,
+	}
+	if m.TargetDB != nil {
+		plan.Transaction = m.TargetTransaction
+		if plan.Transaction == "" {
+			plan.Transaction = TransactionBatch
+		}
+	}
+	if len(m.DedupKeys) > 0 {
+		plan.DedupKeys = m.DedupKeys
+		plan.DedupKeep = m.DedupKeep
+		if plan.DedupKeep == "" {
+			plan.DedupKeep = KeepFirst
+		}
+	}
+
+	if m.SourceDB != nil && len(m.SourceInclude) > 0 {
+		return m.planSchema(plan)
+	}
+	if m.SourceDB == nil && m.SourceFileType == "sql" {
+		plan.Source = "sql dump " + m.SourceFilePath
+		plan.Target = "the database tables of the dump"
+		plan.WriteMode = "replay the statements of the dump"
+		return plan, nil
+	}
+
+	if m.SourceDB != nil {
+		cols, err := m.getColumnsFromSourceTable()
+		if err != nil {
+			return nil, err
+		}
+		plan.Columns = sortedCopy(cols)
+		plan.SelectSQL = m.selectSQL(plan.Columns)
+		plan.Source = "database query"
+		if m.SourceTable != "" {
+			plan.Source = "database table " + m.SourceTable
+		}
+		if plan.EstimatedRows, err = m.estimateRows(m.SourceTable, plan.SelectSQL); err != nil {
+			return nil, err
+		}
+	} else {
+		reader, err := m.newReader()
+		if err != nil {
+			return nil, err
+		}
+		plan.Columns = reader.Columns()
+		reader.Close()
+		plan.Source = fmt.Sprintf("%s file %s", m.SourceFileType, m.SourceFilePath)
+	}
+
+	if m.TargetDB == nil {
+		plan.Target = fmt.Sprintf("%s file %s", m.TargetFileType, m.TargetFilePath)
+		plan.WriteMode = "write records to the file"
+		switch m.TargetFileType {
+		case "pgcopy":
+			format := m.TargetFileFormat
+			if format == "" {
+				format = CopyFormatText
+			}
+			plan.WriteMode = fmt.Sprintf("write the records in the COPY %s format", format)
+		case "pgbinary":
+			plan.WriteMode = "write the records in the COPY binary format"
+		case "fixedwidth":
+			plan.WriteMode = fmt.Sprintf("write the records as lines of %d characters", m.TargetFileLayout.Width())
+		case "xlsx":
+			sheet := m.TargetFileSheet
+			if sheet == "" {
+				sheet = "Sheet1"
+			}
+			plan.WriteMode = fmt.Sprintf("write the records to the sheet %s, replacing it", sheet)
+		}
+		if m.TargetFileType == "sql" {
+			dialect, statement := m.TargetFileDialect, m.TargetFileStatement
+			if dialect == "" {
+				dialect = DialectPostgres
+			}
+			if statement == "" {
+				statement = StatementInsert
+			}
+			plan.WriteMode = fmt.Sprintf("write a %s dump of table %s with %s statements", dialect, m.TargetTable, statement)
+			create, after, err := m.dumpTableSQL(m.TargetTable, plan.Columns, dialect)
+			if err != nil {
+				return nil, err
+			}
+			plan.CreateTableSQL = strings.Join(append(create, after...), ";\n")
+		}
+		return plan, nil
+	}
+	plan.Target = "database table " + m.TargetTable
+	if m.TargetTransaction == TransactionStaging {
+		plan.WriteMode = fmt.Sprintf("load %s%s, then swap it with the table", m.TargetTable, stagingSuffix)
+		return plan, nil
+	}
+	if m.tableExists(m.TargetTable) {
+		plan.WriteMode = "insert into the existing table"
+		diff, err := m.diffSchema(m.TargetTable, plan.Columns)
+		if err != nil {
+			return nil, err
+		}
+		plan.SchemaChanges = append(m.evolveSQL(m.TargetTable, diff), diff.mismatched...)
+		switch m.schemaPolicy() {
+		case SchemaIgnore:
+			if len(diff.missing) > 0 {
+				plan.Columns = without(plan.Columns, diff.missing)
+				plan.SchemaChanges = append(plan.SchemaChanges, "ignore the columns "+strings.Join(diff.missing, ", "))
+			}
+		case SchemaFail:
+			if problems := diff.problems(); len(problems) > 0 {
+				plan.SchemaChanges = []string{"fail, the table does not match the source: " + strings.Join(problems, "; ")}
+			}
+		}
+		return plan, nil
+	}
+	create, after, err := m.createTableStatements(m.TargetTable, plan.Columns)
+	if err != nil {
+		return nil, err
+	}
+	plan.CreateTableSQL = strings.Join(append(create, after...), ";\n")
+	plan.WriteMode = "create the table, then insert"
+	return plan, nil
+}
+
+// planSchema lists the tables copied from the source schema in the order they are copied
+func (m *Migrater) planSchema(plan *Plan) (*Plan, error) {
+	tables, err := m.schemaTables()
+	if err != nil {
+		return nil, err
+	}
+	plan.Source = "database schema " + m.namespace()
+	plan.Target = "database tables of the same name"
+	plan.Tables = tables
+	plan.EstimatedRows = 0
+	for _, table := range tables {
+		table = m.qualified(table)
+		count, err := m.estimateRows(table, "SELECT * FROM "+table)
+		if err != nil {
+			return nil, err
+		}
+		if count < 0 {
+			plan.EstimatedRows = -1
+			break
+		}
+		plan.EstimatedRows += count
+	}
+	plan.WriteMode = "create the missing tables, then insert"
+	return plan, nil
+}
+
+// estimateRows returns the row count of the table from its statistics, -1 when the table was
+// never analyzed or the rows come from a query. With PlanExactCount the query is counted instead.
+func (m *Migrater) estimateRows(table, query string) (int64, error) {
+	var count int64
+	if m.PlanExactCount {
+		countSQL := fmt.Sprintf("SELECT count(*) FROM (%s) AS plan_count", query)
+		err := m.SourceDB.QueryRow(countSQL).Scan(&count)
+		return count, err
+	}
+	if table == "" {
+		return -1, nil
+	}
+	err := m.SourceDB.QueryRow("SELECT reltuples::bigint FROM pg_class WHERE oid = $1::regclass", table).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	if count < 0 {
+		return -1, nil
+	}
+	return count, nil
+}
+
+// WriteText prints the plan in a human readable form
+func (p *Plan) WriteText(w io.Writer) error {
+	estimate := "unknown"
+	if p.EstimatedRows >= 0 {
+		estimate = fmt.Sprint(p.EstimatedRows)
+	}
+	var lines []string
+	if p.Job != "" {
+		lines = append(lines, "Job            : "+p.Job)
+	}
+	if len(p.DependsOn) > 0 {
+		lines = append(lines, "Depends on     : "+strings.Join(p.DependsOn, ", "))
+	}
+	if p.Type != "" {
+		lines = append(lines, "Migration type : "+p.Type)
+	}
+	lines = append(lines,
+		"Source         : "+p.Source,
+		"Target         : "+p.Target,
+	)
+	if len(p.Tables) > 0 {
+		lines = append(lines, "Tables         : "+strings.Join(p.Tables, ", "))
+	} else {
+		lines = append(lines, "Columns        : "+strings.Join(p.Columns, ", "))
+	}
+	lines = append(lines,
+		"Estimated rows : "+estimate,
+		"Write mode     : "+p.WriteMode,
+	)
+	if p.Transaction != "" {
+		lines = append(lines, "Transaction    : "+p.Transaction)
+	}
+	if len(p.DedupKeys) > 0 {
+		lines = append(lines, fmt.Sprintf("Dedup          : keep %s on %s", p.DedupKeep, strings.Join(p.DedupKeys, ", ")))
+	}
+	if p.Verify {
+		lines = append(lines, "Verify         : reconcile the target after the migration")
+	}
+	if p.Note != "" {
+		lines = append(lines, "Note           : "+p.Note)
+	}
+	if p.SelectSQL != "" {
+		lines = append(lines, "Select SQL     : "+p.SelectSQL)
+	}
+	for _, change := range p.SchemaChanges {
+		lines = append(lines, "Schema change  : "+change)
+	}
+	if p.CreateTableSQL != "" {
+		lines = append(lines, "Create SQL     : "+strings.Replace(p.CreateTableSQL, "\n", "\n                 ", -1))
+	}
+	_, err := fmt.Fprintln(w, strings.Join(lines, "\n"))
+	return err
+}
+
+// WriteJSON prints the plan as indented json
+func (p *Plan) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(p)
+}
+
+func sortedCopy(cols []string) []string {
+	sorted := append([]string{}, cols...)
+	sort.Strings(sorted)
+	return sorted
+}
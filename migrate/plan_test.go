@@ -0,0 +1,47 @@
+package migrate
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestEstimateRows(t *testing.T) {
+	stats := regexp.QuoteMeta("SELECT reltuples::bigint FROM pg_class WHERE oid = $1::regclass")
+	count := regexp.QuoteMeta("SELECT count(*) FROM (SELECT * FROM t) AS plan_count")
+	tests := []struct {
+		name  string
+		table string
+		exact bool
+		query string
+		value int64
+		want  int64
+	}{
+		{name: "statistics", table: "t", query: stats, value: 1200, want: 1200},
+		// a table which was never analyzed has no estimate
+		{name: "never analyzed", table: "t", query: stats, value: -1, want: -1},
+		{name: "source sql", want: -1},
+		{name: "exact", table: "t", exact: true, query: count, value: 7, want: 7},
+	}
+	for _, tt := range tests {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.query != "" {
+			mock.ExpectQuery(tt.query).WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(tt.value))
+		}
+		m := &Migrater{SourceDB: db, PlanExactCount: tt.exact}
+		got, err := m.estimateRows(tt.table, "SELECT * FROM t")
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+		} else if got != tt.want {
+			t.Errorf("%s: got %d rows, want %d", tt.name, got, tt.want)
+		}
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+		}
+		db.Close()
+	}
+}
@@ -0,0 +1,86 @@
+package migrate
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	// PreviewTable prints the previewed records as an aligned table
+	PreviewTable = "table"
+	// PreviewTarget prints the previewed records in the target file format
+	PreviewTarget = "target"
+
+	// previewWidth is the widest value printed in a table cell
+	previewWidth = 40
+)
+
+// Preview reads the first n records from the source through the dedup stage and prints them to w,
+// the duplicates among them are left out. Nothing is written to the target.
+func (m *Migrater) Preview(n int, format string, w io.Writer) error {
+	defer m.cleanUp()
+	// a database is only asked for the rows shown, closing the rows would read the rest
+	m.sourceLimit = n
+	reader, err := m.newReader()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	cols := reader.Columns()
+	if err = m.checkDedupKeys(cols); err != nil {
+		return err
+	}
+	var records []map[string]interface{}
+	err = m.pump(reader, n, func(record map[string]interface{}) error {
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if m.Summary.DuplicatesDropped > 0 {
+		fmt.Fprintf(m.progress(), "Showing %d of the first %d source records, %d duplicates discarded\n",
+			len(records), m.Summary.RecordsRead, m.Summary.DuplicatesDropped)
+	}
+
+	if format == PreviewTarget && m.TargetFileType != "" {
+		m.TargetFile = bufio.NewWriter(w)
+		// an xlsx target saves the workbook to its path, without one it writes to TargetFile
+		m.TargetFilePath = ""
+		writer, err := m.newWriter(cols)
+		if err != nil {
+			return err
+		}
+		if err = writer.Write(records); err != nil {
+			return err
+		}
+		return writer.Close()
+	}
+	return writeTable(w, cols, records)
+}
+
+// writeTable prints the records as tab aligned columns, long values are cut at previewWidth
+func writeTable(w io.Writer, cols []string, records []map[string]interface{}) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(cols, "\t"))
+	for _, record := range records {
+		values := make([]string, len(cols))
+		for i, col := range cols {
+			val := record[col]
+			if val == nil {
+				values[i] = "NULL"
+				continue
+			}
+			values[i] = strings.NewReplacer("\t", " ", "\n", " ").Replace(toString(val))
+			if r := []rune(values[i]); len(r) > previewWidth {
+				values[i] = string(r[:previewWidth-3]) + "..."
+			}
+		}
+		fmt.Fprintln(tw, strings.Join(values, "\t"))
+	}
+	return tw.Flush()
+}
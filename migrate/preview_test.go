@@ -0,0 +1,82 @@
+package migrate
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestPreview(t *testing.T) {
+	tests := []struct {
+		name   string
+		n      int
+		dedup  []string
+		format string
+		want   string
+	}{
+		{name: "table", n: 2, format: PreviewTable, want: "id  name\n1   a\n1   b\n"},
+		{name: "target", n: 2, format: PreviewTarget, want: "id,name\n1,a\n1,b\n"},
+		// the duplicates among the first records are left out
+		{name: "dedup", n: 3, dedup: []string{"id"}, format: PreviewTable, want: "id  name\n1   a\n2   c\n"},
+		{name: "null", n: 4, format: PreviewTable, want: "id  name\n1   a\n1   b\n2   c\n3   NULL\n"},
+	}
+	for _, tt := range tests {
+		source := "id,name\n1,a\n1,b\n2,c\n"
+		m, _ := fileMigrater("csv", source, "csv")
+		if tt.name == "null" {
+			m, _ = fileMigrater("json", `[{"id":1,"name":"a"},{"id":1,"name":"b"},{"id":2,"name":"c"},{"id":3,"name":null}]`, "csv")
+		}
+		m.DedupKeys = tt.dedup
+		var out bytes.Buffer
+		if err := m.Preview(tt.n, tt.format, &out); err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if out.String() != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, out.String(), tt.want)
+		}
+	}
+}
+
+func TestSelectSQLLimit(t *testing.T) {
+	tests := []struct {
+		sql   string
+		limit int
+		want  string
+	}{
+		{"SELECT id FROM t", 0, "SELECT id FROM t"},
+		{"SELECT id FROM t ORDER BY id LIMIT 50;", 10, "SELECT * FROM (SELECT id FROM t ORDER BY id LIMIT 50) AS source LIMIT 10"},
+	}
+	for _, tt := range tests {
+		m := &Migrater{SourceSQL: tt.sql, sourceLimit: tt.limit}
+		if got := m.selectSQL(nil); got != tt.want {
+			t.Errorf("selectSQL(%q, %d) = %q, want %q", tt.sql, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestPreviewLimitsSourceQuery(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM t LIMIT 1")).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM (SELECT id FROM t) AS source LIMIT 2")).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	mock.ExpectClose()
+
+	m := &Migrater{SourceDB: db, SourceSQL: "SELECT id FROM t"}
+	var out bytes.Buffer
+	if err = m.Preview(2, PreviewTable, &out); err != nil {
+		t.Fatal(err)
+	}
+	if want := "id\n1\n2\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
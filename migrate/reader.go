@@ -0,0 +1,221 @@
+package migrate
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/clbanning/mxj"
+)
+
+func init() {
+	// xml files written by earlier versions did not escape the values
+	mxj.CustomDecoder = &xml.Decoder{Strict: false}
+	// attributes are read as @ columns and written from them
+	mxj.SetAttrPrefix(attrPrefix)
+}
+
+// recordReader reads records from the source one at a time. Read returns io.EOF once the
+// source is exhausted.
+type recordReader interface {
+	Columns() []string
+	Read() (map[string]interface{}, error)
+	Close() error
+}
+
+// newReader returns a recordReader for the configured source
+func (m *Migrater) newReader() (recordReader, error) {
+	if m.SourceDB != nil {
+		return m.newDBReader()
+	}
+	switch m.SourceFileType {
+	case "csv":
+		return newCSVReader(m.SourceFile)
+	case "xml":
+		if m.SourceFileRecordPath != "" {
+			return m.newXPathReader()
+		}
+		return newXMLReader(m.SourceFile, m.SourceFileArrays)
+	case "pgcopy":
+		return m.newPGCopyReader()
+	case "pgbinary":
+		return m.newPGBinaryReader()
+	case "parquet":
+		return m.newParquetReader()
+	case "avro":
+		return m.newAvroReader()
+	case "xlsx":
+		return m.newXLSXReader()
+	case "fixedwidth":
+		return m.newFixedWidthReader(), nil
+	case "json":
+		if m.SourceFileRecordPath != "" {
+			return m.newJSONPathReader()
+		}
+		return m.newJSONReader()
+	case "sql":
+		return nil, errors.New("A sql dump can only be replayed into a database target")
+	}
+	return nil, fmt.Errorf("Unsupported source file type (%s)", m.SourceFileType)
+}
+
+type csvReader struct {
+	reader  *csv.Reader
+	columns []string
+}
+
+func newCSVReader(r io.Reader) (*csvReader, error) {
+	reader := csv.NewReader(r)
+	// read the header first
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("Error reading csv header (%s)", err)
+	}
+	return &csvReader{reader: reader, columns: header}, nil
+}
+
+func (c *csvReader) Columns() []string { return c.columns }
+
+func (c *csvReader) Read() (map[string]interface{}, error) {
+	record, err := c.reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	mp := make(map[string]interface{}, len(record))
+	for i := range record {
+		mp[c.columns[i]] = record[i]
+	}
+	return mp, nil
+}
+
+func (c *csvReader) Close() error { return nil }
+
+// xmlReader reads a sequence of <Root> documents. A document is flattened into path named
+// columns, one record per document unless its arrays are exploded into rows. The documents are
+// read ahead to take the columns of all of them.
+type xmlReader struct {
+	reader io.Reader
+	arrays string
+	rows   *flatRows
+}
+
+func newXMLReader(r io.Reader, arrays string) (*xmlReader, error) {
+	x := &xmlReader{reader: r, arrays: arrays}
+	var err error
+	x.rows, err = spoolRows(func() ([]map[string]interface{}, error) {
+		doc, err := x.decode()
+		if err != nil {
+			return nil, err
+		}
+		return flatten(doc, x.arrays), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+func (x *xmlReader) decode() (map[string]interface{}, error) {
+	doc, err := mxj.NewMapXmlReader(x.reader)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range doc {
+		if mp, ok := v.(map[string]interface{}); ok {
+			return mp, nil
+		}
+	}
+	return nil, errors.New("Xml document does not contain a record")
+}
+
+func (x *xmlReader) Columns() []string { return x.rows.columns }
+
+func (x *xmlReader) Read() (map[string]interface{}, error) { return x.rows.Read() }
+
+func (x *xmlReader) Close() error { return x.rows.Close() }
+
+type dbReader struct {
+	rows    *sql.Rows
+	columns []string
+}
+
+func (m *Migrater) newDBReader() (*dbReader, error) {
+	var err error
+	var cols []string
+	var selectSQL string
+	var rows *sql.Rows
+	if cols, err = m.getColumnsFromSourceTable(); err != nil {
+		return nil, err
+	}
+	sort.Strings(cols)
+
+	selectSQL = m.selectSQL(cols)
+	if rows, err = m.SourceDB.Query(selectSQL); err != nil {
+		return nil, err
+	}
+	// the result set may not follow the sorted order for a custom sql
+	if cols, err = rows.Columns(); err != nil {
+		rows.Close()
+		return nil, err
+	}
+	return &dbReader{rows: rows, columns: cols}, nil
+}
+
+// selectSQL builds the query reading the source table or returns the configured source sql,
+// limited to sourceLimit rows when it is set
+func (m *Migrater) selectSQL(cols []string) string {
+	if m.SourceSQL != "" {
+		if m.sourceLimit > 0 {
+			// the sql may end with its own ORDER BY or LIMIT
+			return fmt.Sprintf("SELECT * FROM (%s) AS source LIMIT %d",
+				strings.TrimRight(strings.TrimSpace(m.SourceSQL), ";"), m.sourceLimit)
+		}
+		return m.SourceSQL
+	}
+	if m.sourceLimit > 0 {
+		return m.QB.Reader.Select(strings.Join(cols, ",")).From(m.SourceTable).Limit(m.sourceLimit).Build()
+	}
+	return m.QB.Reader.Select(strings.Join(cols, ",")).From(m.SourceTable).Build()
+}
+
+func (d *dbReader) Columns() []string { return sortedCopy(d.columns) }
+
+func (d *dbReader) Read() (map[string]interface{}, error) {
+	return scanRecord(d.rows, d.columns)
+}
+
+// scanRecord scans the next row into a record, returning io.EOF after the last row
+func scanRecord(rows *sql.Rows, cols []string) (map[string]interface{}, error) {
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	columns := make([]interface{}, len(cols))
+	columnPointers := make([]interface{}, len(cols))
+	for i := range cols {
+		columnPointers[i] = &columns[i]
+	}
+	if err := rows.Scan(columnPointers...); err != nil {
+		return nil, err
+	}
+	mp := make(map[string]interface{}, len(cols))
+	for i, colName := range cols {
+		val := columnPointers[i].(*interface{})
+		switch (*val).(type) {
+		case []uint8:
+			mp[colName] = string((*val).([]byte))
+		default:
+			mp[colName] = *val
+		}
+	}
+	return mp, nil
+}
+
+func (d *dbReader) Close() error { return d.rows.Close() }
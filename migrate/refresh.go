@@ -0,0 +1,236 @@
+package migrate
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// stagingSuffix is appended to the target table, its indexes and constraints for the copies
+// loaded in staging mode
+const stagingSuffix = "_staging"
+
+// prepareStaging creates an empty staging table for table. When the table exists the staging
+// table has its columns and defaults but no indexes or constraints, they are built after the load.
+func (m *Migrater) prepareStaging(table string, cols []string) (string, error) {
+	staging := table + stagingSuffix
+	if _, err := m.TargetDB.Exec("DROP TABLE IF EXISTS " + staging); err != nil {
+		return "", err
+	}
+	if !m.tableExists(table) {
+		_, err := m.createTable(staging, cols)
+		return staging, err
+	}
+	referencing, err := m.referencingTables(table)
+	if err != nil {
+		return "", err
+	}
+	if len(referencing) > 0 {
+		return "", fmt.Errorf("Table %s is referenced by foreign keys of %s and cannot be swapped",
+			table, strings.Join(referencing, ", "))
+	}
+	_, err = m.TargetDB.Exec(fmt.Sprintf(
+		"CREATE TABLE %s (LIKE %s INCLUDING DEFAULTS INCLUDING IDENTITY INCLUDING STORAGE INCLUDING COMMENTS)",
+		staging, table))
+	return staging, err
+}
+
+// finishStaging builds the indexes and constraints of the table on the loaded staging table,
+// checks it holds every written record and swaps it with the table. The old table is dropped
+// once the swap is committed.
+func (m *Migrater) finishStaging(staging, table string) error {
+	var objects []tableObject
+	existed := m.tableExists(table)
+	if existed {
+		var err error
+		if objects, err = catalogObjects(m.TargetDB, table); err != nil {
+			return err
+		}
+		for _, o := range objects {
+			fmt.Fprintln(m.progress(), "Building", o.name, "on", staging)
+			if _, err = m.TargetDB.Exec(o.stagingSQL(staging)); err != nil {
+				return fmt.Errorf("Error building %s on %s (%s)", o.name, staging, err)
+			}
+		}
+	}
+
+	var count int
+	if err := m.TargetDB.QueryRow("SELECT count(*) FROM " + staging).Scan(&count); err != nil {
+		return err
+	}
+	if count != m.Summary.RecordsWritten {
+		return fmt.Errorf("Table %s holds %d rows but %d records were written, %s is unchanged",
+			staging, count, m.Summary.RecordsWritten, table)
+	}
+
+	if err := m.swapStaging(staging, table, existed); err != nil {
+		return err
+	}
+	if !existed {
+		return nil
+	}
+	if _, err := m.TargetDB.Exec("DROP TABLE " + table + "_old"); err != nil {
+		return fmt.Errorf("Swapped %s but dropping the old table failed (%s)", table, err)
+	}
+	// the names are free again now that the old table is gone
+	for _, o := range objects {
+		if _, err := m.TargetDB.Exec(o.restoreSQL(table)); err != nil {
+			fmt.Fprintf(m.progress(), "Could not rename %s back to %s (%s)\n", o.stagingName(), o.name, err)
+		}
+	}
+	return nil
+}
+
+// swapStaging puts the loaded staging table in place of table in one transaction, readers see
+// either the old or the new rows
+func (m *Migrater) swapStaging(staging, table string, existed bool) error {
+	tx, err := m.TargetDB.Begin()
+	if err != nil {
+		return err
+	}
+	if err = swapTables(tx, staging, table, existed); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Error swapping %s into %s (%s)", staging, table, err)
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	fmt.Fprintf(m.progress(), "Swapped %s into %s\n", staging, table)
+	return nil
+}
+
+func swapTables(tx *sql.Tx, staging, table string, existed bool) error {
+	if !existed {
+		return renameNewTable(tx, staging, table)
+	}
+
+	// sequences owned by the old table are handed over, dropping it would drop them otherwise
+	owned, err := ownedSequences(tx, table)
+	if err != nil {
+		return err
+	}
+	for _, stmt := range []string{
+		fmt.Sprintf("ALTER TABLE %s RENAME TO %s_old", table, unqualified(table)),
+		fmt.Sprintf("ALTER TABLE %s RENAME TO %s", staging, unqualified(table)),
+	} {
+		if _, err = tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	for sequence, column := range owned {
+		if _, err = tx.Exec(fmt.Sprintf("ALTER SEQUENCE %s OWNED BY %s.%s", sequence, table, column)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renameNewTable renames a staging table created by the run to table. Its keys, indexes and
+// identity sequences were named after the staging table and get the names of the table.
+func renameNewTable(tx *sql.Tx, staging, table string) error {
+	objects, err := catalogObjects(tx, staging)
+	if err != nil {
+		return err
+	}
+	owned, err := ownedSequences(tx, staging)
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s RENAME TO %s", staging, unqualified(table))); err != nil {
+		return err
+	}
+	from, to := unqualified(staging), unqualified(table)
+	for _, o := range objects {
+		if name := renamed(o.name, from, to); strings.HasPrefix(o.name, from) && name != o.name {
+			if _, err = tx.Exec(o.renameSQL(table, o.name, name)); err != nil {
+				return err
+			}
+		}
+	}
+	for sequence := range owned {
+		if name := unqualified(sequence); strings.HasPrefix(name, from) {
+			stmt := fmt.Sprintf("ALTER SEQUENCE %s RENAME TO %s", sequence, renamed(name, from, to))
+			if _, err = tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// unqualified drops the schema of a table name, RENAME TO takes the bare name
+func unqualified(table string) string {
+	return table[strings.LastIndex(table, ".")+1:]
+}
+
+// ownedSequences maps the sequences owned by the columns of table to their column
+func ownedSequences(tx *sql.Tx, table string) (map[string]string, error) {
+	rows, err := tx.Query(`SELECT d.objid::regclass::text, a.attname
+		FROM pg_depend d
+		JOIN pg_attribute a ON a.attrelid = d.refobjid AND a.attnum = d.refobjsubid
+		WHERE d.refobjid = $1::regclass AND d.classid = 'pg_class'::regclass AND d.deptype = 'a'`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	owned := map[string]string{}
+	for rows.Next() {
+		var sequence, column string
+		if err = rows.Scan(&sequence, &column); err != nil {
+			return nil, err
+		}
+		owned[sequence] = column
+	}
+	return owned, rows.Err()
+}
+
+// referencingTables lists the other tables with a foreign key to table
+func (m *Migrater) referencingTables(table string) ([]string, error) {
+	rows, err := m.TargetDB.Query(`SELECT DISTINCT conrelid::regclass::text FROM pg_constraint
+		WHERE contype = 'f' AND confrelid = $1::regclass AND conrelid <> confrelid ORDER BY 1`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tables = append(tables, name)
+	}
+	return tables, rows.Err()
+}
+
+func (o tableObject) stagingName() string {
+	name := o.name
+	if len(name)+len(stagingSuffix) > maxIdentifier {
+		name = name[:maxIdentifier-len(stagingSuffix)]
+	}
+	return name + stagingSuffix
+}
+
+// stagingSQL builds the object on the staging table under the staging name
+func (o tableObject) stagingSQL(staging string) string {
+	referenced := ""
+	if o.selfReference {
+		referenced = staging
+	}
+	return o.createSQL(staging, o.stagingName(), referenced)
+}
+
+// restoreSQL gives the object of the swapped in table its original name back
+func (o tableObject) restoreSQL(table string) string {
+	return o.renameSQL(table, o.stagingName(), o.name)
+}
+
+// renameSQL renames the object of table from one name to another
+func (o tableObject) renameSQL(table, from, to string) string {
+	if o.kind != indexKind {
+		return fmt.Sprintf("ALTER TABLE %s RENAME CONSTRAINT %s TO %s", table, from, to)
+	}
+	// the index lives in the schema of the table
+	schema := strings.TrimSuffix(table, unqualified(table))
+	return fmt.Sprintf("ALTER INDEX %s%s RENAME TO %s", schema, from, to)
+}
@@ -0,0 +1,132 @@
+package migrate
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestStagingEvolvesTheStagingTable(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	exists := regexp.QuoteMeta("SELECT to_regclass('t')")
+	mock.ExpectQuery(exists).WillReturnRows(sqlmock.NewRows([]string{"to_regclass"}).AddRow("t"))
+	mock.ExpectExec(regexp.QuoteMeta("DROP TABLE IF EXISTS t_staging")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(exists).WillReturnRows(sqlmock.NewRows([]string{"to_regclass"}).AddRow("t"))
+	mock.ExpectQuery("SELECT DISTINCT conrelid").WithArgs("t").WillReturnRows(sqlmock.NewRows([]string{"conrelid"}))
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE t_staging (LIKE t ")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("FROM pg_attribute").WithArgs("t_staging").WillReturnRows(
+		sqlmock.NewRows([]string{"attname", "format_type", "attnotnull", "def", "comment", "identity"}).
+			AddRow("id", "text", false, "", "", false))
+	// the column missing from the table is added to the staging copy only
+	mock.ExpectExec(regexp.QuoteMeta("ALTER TABLE t_staging ADD COLUMN name text")).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	m, _ := fileMigrater("csv", "id,name\n", "")
+	m.TargetDB, m.TargetTable = db, "t"
+	m.TargetTransaction, m.TargetSchemaPolicy = TransactionStaging, SchemaAdd
+	// the swap stops at the first statement after the load, which the mock does not expect
+	m.run()
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	if m.TargetTable != "t" {
+		t.Errorf("target table left at %s", m.TargetTable)
+	}
+}
+
+func TestSwapRenamesTheObjectsOfANewTable(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM pg_constraint").WithArgs("s.t_staging").WillReturnRows(
+		sqlmock.NewRows([]string{"conname", "def", "contype", "referenced", "self"}).
+			AddRow("t_staging_pkey", "PRIMARY KEY (id)", "p", "", false).
+			AddRow("t_staging_parent_fkey", "FOREIGN KEY (parent) REFERENCES t_staging(id)", "f", "t_staging", true))
+	mock.ExpectQuery("FROM pg_index").WithArgs("s.t_staging").WillReturnRows(
+		sqlmock.NewRows([]string{"relname", "def"}).AddRow("t_staging_name_idx", "CREATE INDEX t_staging_name_idx ON s.t_staging USING btree (name)"))
+	mock.ExpectQuery("FROM pg_depend").WithArgs("s.t_staging").WillReturnRows(
+		sqlmock.NewRows([]string{"objid", "attname"}).AddRow("s.t_staging_id_seq", "id"))
+	for _, stmt := range []string{
+		"ALTER TABLE s.t_staging RENAME TO t",
+		"ALTER TABLE s.t RENAME CONSTRAINT t_staging_pkey TO t_pkey",
+		"ALTER TABLE s.t RENAME CONSTRAINT t_staging_parent_fkey TO t_parent_fkey",
+		"ALTER INDEX s.t_staging_name_idx RENAME TO t_name_idx",
+		"ALTER SEQUENCE s.t_staging_id_seq RENAME TO t_id_seq",
+	} {
+		mock.ExpectExec(regexp.QuoteMeta(stmt)).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectCommit()
+
+	m := &Migrater{TargetDB: db}
+	if err = m.swapStaging("s.t_staging", "s.t", false); err != nil {
+		t.Fatal(err)
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStagingNames(t *testing.T) {
+	long := "orders_customer_reference_number_and_region_code_unique_key_x"
+	tests := []struct {
+		o       tableObject
+		staging string
+		restore string
+	}{
+		{
+			o:       tableObject{name: "orders_pkey", definition: "PRIMARY KEY (id)", kind: "p"},
+			staging: "ALTER TABLE s.orders_staging ADD CONSTRAINT orders_pkey_staging PRIMARY KEY (id)",
+			restore: "ALTER TABLE s.orders RENAME CONSTRAINT orders_pkey_staging TO orders_pkey",
+		},
+		{
+			o:       tableObject{name: "orders_name_idx", definition: "CREATE INDEX orders_name_idx ON s.orders USING btree (name)", kind: indexKind},
+			staging: "CREATE INDEX orders_name_idx_staging ON s.orders_staging USING btree (name)",
+			restore: "ALTER INDEX s.orders_name_idx_staging RENAME TO orders_name_idx",
+		},
+		{
+			o:       tableObject{name: "orders_parent_fkey", definition: "FOREIGN KEY (parent) REFERENCES orders(id)", kind: "f", selfReference: true},
+			staging: "ALTER TABLE s.orders_staging ADD CONSTRAINT orders_parent_fkey_staging FOREIGN KEY (parent) REFERENCES s.orders_staging(id)",
+			restore: "ALTER TABLE s.orders RENAME CONSTRAINT orders_parent_fkey_staging TO orders_parent_fkey",
+		},
+		{
+			// the staging name stays within the identifier limit
+			o:       tableObject{name: long, definition: "UNIQUE (ref)", kind: "u"},
+			staging: "ALTER TABLE s.orders_staging ADD CONSTRAINT " + long[:maxIdentifier-len(stagingSuffix)] + "_staging UNIQUE (ref)",
+			restore: "ALTER TABLE s.orders RENAME CONSTRAINT " + long[:maxIdentifier-len(stagingSuffix)] + "_staging TO " + long,
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.o.stagingSQL("s.orders_staging"); got != tt.staging {
+			t.Errorf("%s: stagingSQL = %q, want %q", tt.o.name, got, tt.staging)
+		}
+		if got := tt.o.restoreSQL("s.orders"); got != tt.restore {
+			t.Errorf("%s: restoreSQL = %q, want %q", tt.o.name, got, tt.restore)
+		}
+	}
+}
+
+func TestRenamed(t *testing.T) {
+	tests := []struct {
+		name, source, target, want string
+	}{
+		{"orders_pkey", "orders", "orders", "orders_pkey"},
+		{"orders_pkey", "orders", "sales", "sales_pkey"},
+		{"pk_id", "orders", "sales", "sales_pk_id"},
+		{"orders_staging_id_seq", "orders_staging", "orders", "orders_id_seq"},
+	}
+	for _, tt := range tests {
+		if got := renamed(tt.name, tt.source, tt.target); got != tt.want {
+			t.Errorf("renamed(%s, %s, %s) = %s, want %s", tt.name, tt.source, tt.target, got, tt.want)
+		}
+	}
+	if got := renamed("x", "a", string(make([]byte, 70))); len(got) != maxIdentifier {
+		t.Errorf("renamed gave %d characters, want at most %d", len(got), maxIdentifier)
+	}
+}
@@ -0,0 +1,201 @@
+package migrate
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// defaultNamespace is the postgres schema holding the tables when none is configured
+const defaultNamespace = "public"
+
+// migrateSchema copies every table of the source namespace matching the include patterns,
+// parents before the tables referencing them through a foreign key
+func (m *Migrater) migrateSchema() error {
+	tables, err := m.schemaTables()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(m.progress(), "migrating %d tables from schema %s\n", len(tables), m.namespace())
+
+	total := Summary{Verified: m.Verify}
+	for _, table := range tables {
+		fmt.Fprintln(m.progress(), "migrating table", table)
+		m.SourceTable = m.qualified(table)
+		m.TargetTable = table
+		m.Summary = Summary{}
+		if err = m.run(); err != nil {
+			return fmt.Errorf("table %s: %s", table, err)
+		}
+		fmt.Fprintf(m.progress(), "Table %s: %s\n", table, m.Summary)
+		total.RecordsRead += m.Summary.RecordsRead
+		total.RecordsWritten += m.Summary.RecordsWritten
+		total.DuplicatesDropped += m.Summary.DuplicatesDropped
+		total.Verified = total.Verified && m.Summary.Verified
+	}
+	m.Summary = total
+	return nil
+}
+
+func (m *Migrater) namespace() string {
+	if m.SourceNamespace == "" {
+		return defaultNamespace
+	}
+	return m.SourceNamespace
+}
+
+// qualified prefixes the table with its namespace unless it lives in the default one
+func (m *Migrater) qualified(table string) string {
+	if m.namespace() == defaultNamespace {
+		return table
+	}
+	return m.namespace() + "." + table
+}
+
+// schemaTables lists the tables of the source namespace matching the include and not the
+// exclude patterns, in foreign key order
+func (m *Migrater) schemaTables() ([]string, error) {
+	include, err := compilePatterns(m.SourceInclude)
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := compilePatterns(m.SourceExclude)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := m.SourceDB.Query(`SELECT table_name FROM information_schema.tables
+		WHERE table_schema = $1 AND table_type = 'BASE TABLE' ORDER BY table_name`, m.namespace())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	selected := map[string]bool{}
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err = rows.Scan(&table); err != nil {
+			return nil, err
+		}
+		// the batch ledger of the migrater is never copied
+		if table != ledgerTable && matchAny(include, table) && !matchAny(exclude, table) {
+			selected[table] = true
+			tables = append(tables, table)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(tables) == 0 {
+		return nil, fmt.Errorf("No table in schema %s matches %s", m.namespace(), strings.Join(m.SourceInclude, ", "))
+	}
+
+	parents, err := m.foreignKeys(selected)
+	if err != nil {
+		return nil, err
+	}
+	return orderTables(tables, parents, m.progress()), nil
+}
+
+// foreignKeys maps each selected table to the selected tables it references
+func (m *Migrater) foreignKeys(selected map[string]bool) (map[string][]string, error) {
+	rows, err := m.SourceDB.Query(`SELECT DISTINCT tc.table_name, ccu.table_name
+		FROM information_schema.table_constraints tc
+		JOIN information_schema.constraint_column_usage ccu
+			ON tc.constraint_name = ccu.constraint_name AND tc.constraint_schema = ccu.constraint_schema
+		WHERE tc.constraint_type = 'FOREIGN KEY' AND tc.table_schema = $1`, m.namespace())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	parents := map[string][]string{}
+	for rows.Next() {
+		var child, parent string
+		if err = rows.Scan(&child, &parent); err != nil {
+			return nil, err
+		}
+		// a self reference does not affect the order
+		if child != parent && selected[child] && selected[parent] {
+			parents[child] = append(parents[child], parent)
+		}
+	}
+	return parents, rows.Err()
+}
+
+// orderTables sorts the tables so that every table comes after the tables it references. Tables
+// referencing each other in a cycle are appended by name.
+func orderTables(tables []string, parents map[string][]string, progress io.Writer) []string {
+	pending := map[string]int{}
+	children := map[string][]string{}
+	for _, table := range tables {
+		pending[table] = len(parents[table])
+		for _, parent := range parents[table] {
+			children[parent] = append(children[parent], table)
+		}
+	}
+
+	var ordered, ready []string
+	for _, table := range tables {
+		if pending[table] == 0 {
+			ready = append(ready, table)
+		}
+	}
+	done := map[string]bool{}
+	for len(ready) > 0 {
+		sort.Strings(ready)
+		table := ready[0]
+		ready = ready[1:]
+		ordered = append(ordered, table)
+		done[table] = true
+		for _, child := range children[table] {
+			pending[child]--
+			if pending[child] == 0 {
+				ready = append(ready, child)
+			}
+		}
+	}
+	for _, table := range tables {
+		if !done[table] {
+			fmt.Fprintf(progress, "Table %s is part of a foreign key cycle, copying it last\n", table)
+			ordered = append(ordered, table)
+		}
+	}
+	return ordered
+}
+
+// compilePatterns turns the table patterns into matchers. A pattern starting with re: is a
+// regular expression, anything else a glob such as stg_*.
+func compilePatterns(patterns []string) ([]func(string) bool, error) {
+	var matchers []func(string) bool
+	for _, p := range patterns {
+		if strings.HasPrefix(p, "re:") {
+			re, err := regexp.Compile(strings.TrimPrefix(p, "re:"))
+			if err != nil {
+				return nil, fmt.Errorf("Invalid table pattern %s (%s)", p, err)
+			}
+			matchers = append(matchers, re.MatchString)
+			continue
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("Invalid table pattern %s (%s)", p, err)
+		}
+		glob := p
+		matchers = append(matchers, func(table string) bool {
+			ok, _ := path.Match(glob, table)
+			return ok
+		})
+	}
+	return matchers, nil
+}
+
+func matchAny(matchers []func(string) bool, table string) bool {
+	for _, match := range matchers {
+		if match(table) {
+			return true
+		}
+	}
+	return false
+}
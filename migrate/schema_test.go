@@ -0,0 +1,61 @@
+package migrate
+
+import (
+	"io"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestOrderTables(t *testing.T) {
+	tables := []string{"orders", "lines", "customers", "x", "y", "notes"}
+	parents := map[string][]string{
+		"orders": {"customers"},
+		"lines":  {"orders"},
+		"x":      {"y"},
+		"y":      {"x"},
+	}
+	want := []string{"customers", "notes", "orders", "lines", "x", "y"}
+	if got := orderTables(tables, parents, io.Discard); !reflect.DeepEqual(got, want) {
+		t.Errorf("orderTables = %v, want %v", got, want)
+	}
+}
+
+func TestCompilePatterns(t *testing.T) {
+	matchers, err := compilePatterns([]string{"stg_*", "re:^tmp_[0-9]+$"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]bool{"stg_orders": true, "tmp_12": true, "tmp_x": false, "orders": false, "public.stg_a": false}
+	for table, want := range tests {
+		if got := matchAny(matchers, table); got != want {
+			t.Errorf("matchAny(%s) = %v, want %v", table, got, want)
+		}
+	}
+	for _, bad := range []string{"re:(", "stg_["} {
+		if _, err = compilePatterns([]string{bad}); err == nil {
+			t.Errorf("pattern %s compiled", bad)
+		}
+	}
+}
+
+func TestColumnsOfEmptyTable(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, name FROM empty LIMIT 1")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}))
+
+	m := &Migrater{SourceDB: db, SourceSQL: "SELECT id, name FROM empty"}
+	cols, err := m.getColumnsFromSourceTable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"id", "name"}; !reflect.DeepEqual(cols, want) {
+		t.Errorf("columns %v, want %v", cols, want)
+	}
+}
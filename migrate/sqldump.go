@@ -0,0 +1,551 @@
+package migrate
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Dialects and statements of a sql dump target
+const (
+	DialectPostgres = "postgres"
+	DialectMySQL    = "mysql"
+	StatementInsert = "insert"
+	StatementCopy   = "copy"
+)
+
+// sqlWriter writes a dump creating the table and loading the records, ready for psql or mysql
+type sqlWriter struct {
+	writer    *bufio.Writer
+	table     string
+	columns   []string
+	dialect   string
+	statement string
+	copying   bool
+	// types are the source column types, the bytea columns are written as hex
+	types map[string]string
+	// after runs once the data is loaded, e.g. the sequence resets
+	after []string
+}
+
+func (m *Migrater) newSQLWriter(cols []string) (*sqlWriter, error) {
+	s := &sqlWriter{
+		writer:    m.TargetFile,
+		table:     m.TargetTable,
+		columns:   cols,
+		dialect:   m.TargetFileDialect,
+		statement: m.TargetFileStatement,
+	}
+	if s.dialect == "" {
+		s.dialect = DialectPostgres
+	}
+	if s.statement == "" {
+		s.statement = StatementInsert
+	}
+	create, after, err := m.dumpTableSQL(s.table, cols, s.dialect)
+	if err != nil {
+		return nil, err
+	}
+	s.after = after
+	if s.types, err = m.sourceTypeNames(cols); err != nil {
+		return nil, err
+	}
+	if s.dialect == DialectPostgres {
+		fmt.Fprintln(s.writer, "SET standard_conforming_strings = on;")
+	}
+	for _, stmt := range create {
+		fmt.Fprintf(s.writer, "%s;\n", stmt)
+	}
+	return s, nil
+}
+
+// dumpTableSQL builds the CREATE TABLE of the dump. A postgres dump of a source table carries
+// its keys, indexes and defaults, otherwise the columns get the source types.
+func (m *Migrater) dumpTableSQL(table string, cols []string, dialect string) ([]string, []string, error) {
+	if dialect == DialectPostgres && m.copyCatalog() {
+		return m.catalogTableSQL(table)
+	}
+	types, err := m.sourceTypeNames(cols)
+	if err != nil {
+		return nil, nil, err
+	}
+	defs := make([]string, len(cols))
+	for i, col := range cols {
+		datatype := types[col]
+		if datatype == "" {
+			datatype = "text"
+		}
+		if dialect == DialectMySQL {
+			datatype = mysqlType(datatype)
+		}
+		defs[i] = col + " " + datatype
+	}
+	return []string{fmt.Sprintf("CREATE TABLE %s (%s)", table, strings.Join(defs, ", "))}, nil, nil
+}
+
+// mysqlTypes maps the postgres types to mysql, sized types keep their size
+var mysqlTypes = map[string]string{
+	"smallint":                    "SMALLINT",
+	"integer":                     "INT",
+	"bigint":                      "BIGINT",
+	"real":                        "FLOAT",
+	"double precision":            "DOUBLE",
+	"numeric":                     "DECIMAL",
+	"boolean":                     "BOOLEAN",
+	"character varying":           "VARCHAR",
+	"character":                   "CHAR",
+	"text":                        "LONGTEXT",
+	"date":                        "DATE",
+	"timestamp without time zone": "DATETIME(6)",
+	"timestamp with time zone":    "DATETIME(6)",
+	"json":                        "JSON",
+	"jsonb":                       "JSON",
+	"bytea":                       "LONGBLOB",
+	"uuid":                        "CHAR(36)",
+}
+
+func mysqlType(datatype string) string {
+	parts := sizedType.FindStringSubmatch(datatype)
+	if parts == nil {
+		return "LONGTEXT"
+	}
+	t, ok := mysqlTypes[parts[1]]
+	if !ok {
+		return "LONGTEXT"
+	}
+	if parts[2] != "" && !strings.Contains(t, "(") {
+		t += "(" + parts[2]
+		if parts[3] != "" {
+			t += "," + parts[3]
+		}
+		t += ")"
+	}
+	if t == "VARCHAR" {
+		// mysql needs a length
+		t = "LONGTEXT"
+	}
+	return t
+}
+
+func (s *sqlWriter) Write(records []map[string]interface{}) error {
+	if s.statement == StatementCopy {
+		return s.writeCopy(records)
+	}
+	fmt.Fprintf(s.writer, "INSERT INTO %s (%s) VALUES\n", s.table, strings.Join(s.columns, ", "))
+	for i, record := range records {
+		values := make([]string, len(s.columns))
+		for j, col := range s.columns {
+			if s.types[col] == "bytea" && record[col] != nil {
+				values[j] = byteaLiteral(record[col], s.dialect)
+				continue
+			}
+			values[j] = sqlLiteral(record[col], s.dialect)
+		}
+		sep := ","
+		if i == len(records)-1 {
+			sep = ";"
+		}
+		fmt.Fprintf(s.writer, "(%s)%s\n", strings.Join(values, ", "), sep)
+	}
+	return s.writer.Flush()
+}
+
+func (s *sqlWriter) writeCopy(records []map[string]interface{}) error {
+	if !s.copying {
+		fmt.Fprintf(s.writer, "COPY %s (%s) FROM stdin;\n", s.table, strings.Join(s.columns, ", "))
+		s.copying = true
+	}
+	for _, record := range records {
+		values := make([]string, len(s.columns))
+		for i, col := range s.columns {
+			if s.types[col] == "bytea" && record[col] != nil {
+				values[i] = copyText(`\x` + hex.EncodeToString([]byte(toString(record[col]))))
+				continue
+			}
+			values[i] = copyText(record[col])
+		}
+		fmt.Fprintln(s.writer, strings.Join(values, "\t"))
+	}
+	return s.writer.Flush()
+}
+
+func (s *sqlWriter) Close() error {
+	if s.copying {
+		fmt.Fprintln(s.writer, `\.`)
+	}
+	for _, stmt := range s.after {
+		fmt.Fprintf(s.writer, "%s;\n", stmt)
+	}
+	return s.writer.Flush()
+}
+
+// sqlLiteral formats a value as a literal of the dialect
+func sqlLiteral(v interface{}, dialect string) string {
+	switch val := v.(type) {
+	case nil:
+		return "NULL"
+	case bool:
+		if val {
+			return "TRUE"
+		}
+		return "FALSE"
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprint(val)
+	case float32:
+		return floatLiteral(float64(val), fmt.Sprint(val))
+	case float64:
+		return floatLiteral(val, fmt.Sprint(val))
+	case time.Time:
+		if dialect == DialectMySQL {
+			return "'" + val.UTC().Format("2006-01-02 15:04:05.999999") + "'"
+		}
+		return "'" + val.Format("2006-01-02 15:04:05.999999Z07:00") + "'"
+	}
+	s := toString(v)
+	if dialect == DialectMySQL {
+		return "'" + mysqlEscaper.Replace(s) + "'"
+	}
+	return quoteLiteral(s)
+}
+
+// floatLiteral quotes the special values of a float, a bare NaN or Inf is not valid sql
+func floatLiteral(f float64, text string) string {
+	if special := floatSpecial(f); special != "" {
+		return "'" + special + "'"
+	}
+	return text
+}
+
+// floatSpecial spells NaN and the infinities as postgres reads them, empty for other values
+func floatSpecial(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	}
+	return ""
+}
+
+// byteaLiteral writes the bytes of a bytea value as a hex literal of the dialect
+func byteaLiteral(v interface{}, dialect string) string {
+	hexed := hex.EncodeToString([]byte(toString(v)))
+	if dialect == DialectMySQL {
+		return "X'" + hexed + "'"
+	}
+	return `'\x` + hexed + "'"
+}
+
+var mysqlEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", `\n`, "\r", `\r`, "\x00", `\0`, "\x1a", `\Z`)
+
+// copyEscaper escapes a value of the postgres COPY text format
+var copyEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+// copyText formats a value for the postgres COPY text format, \N is NULL
+func copyText(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return `\N`
+	case bool:
+		if val {
+			return "t"
+		}
+		return "f"
+	case time.Time:
+		return val.Format("2006-01-02 15:04:05.999999Z07:00")
+	case float32:
+		if special := floatSpecial(float64(val)); special != "" {
+			return special
+		}
+	case float64:
+		if special := floatSpecial(val); special != "" {
+			return special
+		}
+	}
+	return copyEscaper.Replace(toString(v))
+}
+
+// copyUnescape reads a field of the postgres COPY text format, nil for \N
+func copyUnescape(field string) interface{} {
+	if field == `\N` {
+		return nil
+	}
+	if !strings.Contains(field, `\`) {
+		return field
+	}
+	var b strings.Builder
+	for i := 0; i < len(field); i++ {
+		c := field[i]
+		if c != '\\' || i == len(field)-1 {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		switch field[i] {
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'v':
+			b.WriteByte('\v')
+		default:
+			b.WriteByte(field[i])
+		}
+	}
+	return b.String()
+}
+
+// copyStatement matches COPY table (columns) FROM stdin
+var copyStatement = regexp.MustCompile(`(?is)^COPY\s+(\S+)\s*\(([^)]*)\)\s+FROM\s+stdin`)
+
+// replay runs the statements of a postgres sql dump against the target database. The rows of
+// COPY blocks are loaded through the driver.
+func (m *Migrater) replay() error {
+	if len(m.DedupKeys) > 0 || m.Verify {
+		return errors.New("Dedup and verify are not supported when replaying a sql dump")
+	}
+	fmt.Fprintln(m.progress(), "Replaying sql dump", m.SourceFilePath)
+	for {
+		stmt, err := readStatement(m.SourceFile)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if parts := copyStatement.FindStringSubmatch(stmt); parts != nil {
+			// the rows start on the line after the statement
+			if _, err = m.SourceFile.ReadString('\n'); err != nil {
+				return err
+			}
+			if err = m.replayCopy(parts[1], splitColumns(parts[2])); err != nil {
+				return err
+			}
+			continue
+		}
+		result, err := m.target().Exec(stmt)
+		if err != nil {
+			return fmt.Errorf("Error replaying %s (%s)", firstLine(stmt), err)
+		}
+		if strings.HasPrefix(strings.ToUpper(stmt), "INSERT") {
+			if n, err := result.RowsAffected(); err == nil {
+				m.Summary.RecordsRead += int(n)
+				m.Summary.RecordsWritten += int(n)
+			}
+		}
+	}
+	fmt.Fprintln(m.progress(), "Complete")
+	return nil
+}
+
+// replayCopy loads the rows following a COPY statement up to the \. line. COPY needs a
+// transaction, one is opened unless the job runs in one.
+func (m *Migrater) replayCopy(table string, cols []string) (err error) {
+	tx := m.targetTx
+	if tx == nil {
+		if tx, err = m.TargetDB.Begin(); err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil {
+				tx.Rollback()
+				return
+			}
+			err = tx.Commit()
+		}()
+	}
+
+	copyIn := pq.CopyIn(table, cols...)
+	if i := strings.Index(table, "."); i >= 0 {
+		copyIn = pq.CopyInSchema(table[:i], table[i+1:], cols...)
+	}
+	stmt, err := tx.Prepare(copyIn)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for {
+		line, err := m.SourceFile.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return fmt.Errorf("COPY into %s is not ended by \\. (%s)", table, err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == `\.` {
+			break
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != len(cols) {
+			return fmt.Errorf("COPY into %s has a row of %d fields for %d columns", table, len(fields), len(cols))
+		}
+		args := make([]interface{}, len(fields))
+		for i, field := range fields {
+			args[i] = copyUnescape(field)
+		}
+		if _, err = stmt.Exec(args...); err != nil {
+			return err
+		}
+		m.Summary.RecordsRead++
+	}
+	if _, err = stmt.Exec(); err != nil {
+		return err
+	}
+	m.Summary.RecordsWritten = m.Summary.RecordsRead
+	return nil
+}
+
+// readStatement reads the next statement up to a semicolon outside of quotes and comments,
+// io.EOF when only whitespace is left. It knows the quoting of postgres: single and double
+// quotes, E'...' strings with backslash escapes, $$ and $tag$ dollar quotes, -- and nested /* */
+// comments.
+func readStatement(r *bufio.Reader) (string, error) {
+	var b strings.Builder
+	// quote is the delimiter closing the open string, escapes is set in an E'' string
+	var quote string
+	var escapes bool
+	var prev, prev2 rune
+	// depth counts the open comments, body is where the text of a dollar quote starts
+	depth, body := 0, 0
+	for {
+		c, _, err := r.ReadRune()
+		if err == io.EOF {
+			if quote != "" || depth > 0 {
+				return "", fmt.Errorf("The sql dump ends inside a string or comment: %s", firstLine(strings.TrimSpace(b.String())))
+			}
+			if stmt := strings.TrimSpace(b.String()); stmt != "" {
+				return stmt, nil
+			}
+			return "", io.EOF
+		}
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case depth > 0:
+			// comments are dropped, they may nest
+			switch {
+			case c == '*' && peek(r) == '/':
+				r.ReadRune()
+				if depth--; depth == 0 {
+					b.WriteRune(' ')
+				}
+			case c == '/' && peek(r) == '*':
+				r.ReadRune()
+				depth++
+			}
+			continue
+		case quote == "'" && escapes && c == '\\':
+			// the escaped character cannot close the string
+			b.WriteRune(c)
+			if c, _, err = r.ReadRune(); err != nil {
+				continue
+			}
+		case quote != "" && len(quote) == 1:
+			// a doubled quote stays in the string, which keeps its escapes
+			if string(c) == quote && peek(r) == c {
+				b.WriteRune(c)
+				r.ReadRune()
+			} else if string(c) == quote {
+				quote = ""
+			}
+		case quote != "":
+			b.WriteRune(c)
+			if b.Len()-body >= len(quote) && strings.HasSuffix(b.String(), quote) {
+				quote = ""
+			}
+			prev2, prev = prev, c
+			continue
+		case c == '\'' || c == '"':
+			quote = string(c)
+			escapes = c == '\'' && (prev == 'E' || prev == 'e') && !isIdentRune(prev2)
+		case c == '$' && !isIdentRune(prev):
+			if tag, ok := dollarTag(r); ok {
+				quote = "$" + tag + "$"
+				b.WriteString(quote)
+				body = b.Len()
+				prev2, prev = prev, '$'
+				continue
+			}
+		case c == '-' && peek(r) == '-':
+			// a comment runs to the end of the line
+			if _, err = r.ReadString('\n'); err != nil && err != io.EOF {
+				return "", err
+			}
+			b.WriteRune('\n')
+			prev2, prev = prev, '\n'
+			continue
+		case c == '/' && peek(r) == '*':
+			r.ReadRune()
+			depth++
+			continue
+		case c == ';':
+			return strings.TrimSpace(b.String()), nil
+		}
+		b.WriteRune(c)
+		prev2, prev = prev, c
+	}
+}
+
+// dollarTag reads the tag of a dollar quote after its first $, empty for $$. Nothing is read
+// when the $ does not open a dollar quote, such as the $1 of a parameter.
+func dollarTag(r *bufio.Reader) (string, bool) {
+	for n := 1; ; n++ {
+		next, err := r.Peek(n)
+		if err != nil {
+			return "", false
+		}
+		c := next[n-1]
+		if c == '$' {
+			tag := string(next[:n-1])
+			r.Discard(n)
+			return tag, true
+		}
+		if !isIdentRune(rune(c)) || n == 1 && c >= '0' && c <= '9' {
+			return "", false
+		}
+	}
+}
+
+func isIdentRune(c rune) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c > 127
+}
+
+// splitColumns splits the column list of a statement
+func splitColumns(list string) []string {
+	var cols []string
+	for _, col := range strings.Split(list, ",") {
+		cols = append(cols, strings.Trim(strings.TrimSpace(col), `"`))
+	}
+	return cols
+}
+
+func firstLine(stmt string) string {
+	if i := strings.IndexByte(stmt, '\n'); i >= 0 {
+		return stmt[:i] + " ..."
+	}
+	return stmt
+}
+
+func peek(r *bufio.Reader) rune {
+	c, _, err := r.ReadRune()
+	if err != nil {
+		return 0
+	}
+	r.UnreadRune()
+	return c
+}
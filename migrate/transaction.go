@@ -0,0 +1,51 @@
+package migrate
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Commit granularity of a database target
+const (
+	// TransactionBatch commits every batch on its own, a failure keeps the batches loaded so far
+	TransactionBatch = "batch"
+	// TransactionJob loads the whole job in one transaction, a failure leaves the target untouched
+	TransactionJob = "job"
+	// TransactionStaging loads into <table>_staging and swaps it with the table on success
+	TransactionStaging = "staging"
+)
+
+// querier is implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// target returns the transaction of the job when one is open, the target database otherwise
+func (m *Migrater) target() querier {
+	if m.targetTx != nil {
+		return m.targetTx
+	}
+	return m.TargetDB
+}
+
+// inJobTransaction runs fn in a single transaction on the target, committed only when fn succeeds
+func (m *Migrater) inJobTransaction(fn func() error) error {
+	tx, err := m.TargetDB.Begin()
+	if err != nil {
+		return err
+	}
+	m.targetTx = tx
+	defer func() { m.targetTx = nil }()
+
+	if err = fn(); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			fmt.Fprintln(m.progress(), "Error rolling back the job", rbErr)
+		}
+		fmt.Fprintln(m.progress(), "Rolled back the job, the target is unchanged")
+		return err
+	}
+	fmt.Fprintln(m.progress(), "Committing the job")
+	return tx.Commit()
+}
@@ -0,0 +1,62 @@
+package migrate
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestInJobTransaction(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{name: "commit"},
+		{name: "rollback", err: errors.New("batch 2 failed")},
+	}
+	for _, tt := range tests {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO t").WillReturnResult(sqlmock.NewResult(0, 1))
+		if tt.err == nil {
+			mock.ExpectCommit()
+		} else {
+			mock.ExpectRollback()
+		}
+
+		m := &Migrater{TargetDB: db}
+		err = m.inJobTransaction(func() error {
+			// the statements of the job go through its transaction
+			if m.target() == querier(db) {
+				t.Errorf("%s: the job writes outside its transaction", tt.name)
+			}
+			if _, err := m.target().Exec("INSERT INTO t VALUES (1)"); err != nil {
+				return err
+			}
+			return tt.err
+		})
+		if err != tt.err {
+			t.Errorf("%s: got error %v, want %v", tt.name, err, tt.err)
+		}
+		if m.target() != querier(db) {
+			t.Errorf("%s: the transaction is still open", tt.name)
+		}
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+		}
+		db.Close()
+	}
+}
+
+func TestUnqualified(t *testing.T) {
+	tests := map[string]string{"orders": "orders", "sales.orders": "orders", "db.sales.orders": "orders"}
+	for table, want := range tests {
+		if got := unqualified(table); got != want {
+			t.Errorf("unqualified(%s) = %s, want %s", table, got, want)
+		}
+	}
+}
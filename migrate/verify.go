@@ -0,0 +1,357 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// detailLimit is the number of keyed rows held to list the mismatching keys
+const detailLimit = 100000
+
+// keyBatch is the number of keys looked up by one query of the written rows
+const keyBatch = 500
+
+// checksum aggregates the rows of one side of the migration. The hashes are summed, so the
+// result does not depend on the order the rows were read in.
+type checksum struct {
+	columns []string
+	keys    []string
+	// types holds the target column types the values are normalized to
+	types   map[string]string
+	rows    int
+	rowHash uint64
+	sums    map[string]*columnSum
+	// detail maps the key of a row to its row hash, it is dropped once it grows past detailLimit
+	detail map[string]uint64
+	// written holds the values of every key added, by their canonical text
+	written map[string][]interface{}
+}
+
+type columnSum struct {
+	hash    uint64
+	nulls   int
+	numeric bool
+	total   *big.Rat
+}
+
+func newChecksum(columns []string, keys []string, types map[string]string) *checksum {
+	c := &checksum{columns: columns, keys: keys, types: types, sums: map[string]*columnSum{}}
+	for _, col := range columns {
+		c.sums[col] = &columnSum{numeric: true, total: new(big.Rat)}
+	}
+	if len(keys) > 0 {
+		c.detail = map[string]uint64{}
+	}
+	return c
+}
+
+// keepKeys makes the checksum hold the key values of the rows added, to read them back
+func (c *checksum) keepKeys() {
+	c.written = map[string][]interface{}{}
+}
+
+func (c *checksum) add(record map[string]interface{}) {
+	c.rows++
+	row := fnv.New64a()
+	for _, col := range c.columns {
+		val, null := typedCanonical(c.types[col], record[col])
+		sum := c.sums[col]
+
+		h := fnv.New64a()
+		h.Write([]byte(val))
+		sum.hash += h.Sum64()
+		row.Write([]byte(col))
+		row.Write([]byte{0})
+		row.Write([]byte(val))
+		row.Write([]byte{0})
+
+		if null {
+			sum.nulls++
+			continue
+		}
+		if sum.numeric {
+			if r, ok := new(big.Rat).SetString(val); ok {
+				sum.total.Add(sum.total, r)
+			} else {
+				sum.numeric = false
+			}
+		}
+	}
+	c.rowHash += row.Sum64()
+	if len(c.keys) == 0 {
+		return
+	}
+
+	parts := make([]string, len(c.keys))
+	for i, k := range c.keys {
+		parts[i], _ = typedCanonical(c.types[k], record[k])
+	}
+	key := strings.Join(parts, ", ")
+	if c.written != nil {
+		if _, ok := c.written[key]; !ok {
+			values := make([]interface{}, len(c.keys))
+			for i, k := range c.keys {
+				values[i] = record[k]
+			}
+			c.written[key] = values
+		}
+	}
+	if c.detail == nil {
+		return
+	}
+	if len(c.detail) >= detailLimit {
+		c.detail = nil
+		return
+	}
+	// a repeated key is folded into the same entry, the sum still differs if either row does
+	c.detail[key] += row.Sum64()
+}
+
+// canonical formats a value the same way regardless of whether it came from a file or a database
+func canonical(v interface{}) (string, bool) {
+	switch val := v.(type) {
+	case nil:
+		return "\x00NULL", true
+	case time.Time:
+		return val.UTC().Format(time.RFC3339Nano), false
+	case float64:
+		return strconv.FormatFloat(val, 'g', -1, 64), false
+	case float32:
+		return strconv.FormatFloat(float64(val), 'g', -1, 32), false
+	default:
+		return toString(val), false
+	}
+}
+
+// typedCanonical formats a value the way the target column type holds it, so the text of a
+// file matches the typed value read back from the table. A value which does not parse as the
+// type, or a column of another type, is compared as canonical text.
+func typedCanonical(datatype string, v interface{}) (string, bool) {
+	if v == nil || datatype == "" {
+		return canonical(v)
+	}
+	switch binaryType(datatype) {
+	case "smallint", "integer", "bigint":
+		if n, err := binaryInt(v); err == nil {
+			return strconv.FormatInt(n, 10), false
+		}
+	case "numeric":
+		// 1.50 and 1.5 are the same numeric
+		if r, ok := new(big.Rat).SetString(strings.TrimSpace(toString(v))); ok {
+			return r.RatString(), false
+		}
+	case "real":
+		if f, err := binaryFloat(v); err == nil {
+			return strconv.FormatFloat(f, 'g', -1, 32), false
+		}
+	case "double precision":
+		if f, err := binaryFloat(v); err == nil {
+			return strconv.FormatFloat(f, 'g', -1, 64), false
+		}
+	case "boolean":
+		if b, ok := v.(bool); ok {
+			return strconv.FormatBool(b), false
+		}
+		switch strings.ToLower(strings.TrimSpace(toString(v))) {
+		case "t", "true", "y", "yes", "on", "1":
+			return "true", false
+		case "f", "false", "n", "no", "off", "0":
+			return "false", false
+		}
+	case "date":
+		if tm, err := binaryTime(v); err == nil {
+			return tm.Format("2006-01-02"), false
+		}
+	case "timestamp without time zone":
+		// the offset of a file value is dropped by the column
+		if tm, err := binaryTime(v); err == nil {
+			return tm.Format("2006-01-02 15:04:05.999999999"), false
+		}
+	case "timestamp with time zone":
+		if tm, err := binaryTime(v); err == nil {
+			return tm.UTC().Format(time.RFC3339Nano), false
+		}
+	}
+	return canonical(v)
+}
+
+// startVerify returns the checksum the written records are added to, normalized to the types of
+// the target columns. The rows of a table which is not empty are told apart from those the run
+// writes by the verify keys, the written rows are then read back by key.
+func (m *Migrater) startVerify(cols []string) (*checksum, error) {
+	for _, key := range m.VerifyKeys {
+		if !contains(cols, key) {
+			return nil, fmt.Errorf("Verify key %s is not a source column", key)
+		}
+	}
+	columns, err := catalogColumns(m.target(), m.TargetTable)
+	if err != nil {
+		return nil, err
+	}
+	// the unquoted names of the table are folded by postgres
+	types := map[string]string{}
+	for _, col := range cols {
+		for _, c := range columns {
+			if strings.EqualFold(c.name, col) {
+				types[col] = c.datatype
+			}
+		}
+	}
+	sums := newChecksum(cols, m.VerifyKeys, types)
+	if len(m.VerifyKeys) > 0 {
+		sums.keepKeys()
+		return sums, nil
+	}
+	var held bool
+	if err = m.target().QueryRow("SELECT EXISTS (SELECT 1 FROM " + m.TargetTable + ")").Scan(&held); err != nil {
+		return nil, err
+	}
+	if held {
+		return nil, fmt.Errorf("Please provide target.verify.keys to verify %s, the table already holds rows", m.TargetTable)
+	}
+	return sums, nil
+}
+
+// targetChecksum sums the rows of the target table written by the run, the whole table when
+// the run loaded it empty and the rows with the written keys otherwise
+func (m *Migrater) targetChecksum(source *checksum) (*checksum, error) {
+	c := newChecksum(source.columns, source.keys, source.types)
+	selectSQL := "SELECT " + strings.Join(source.columns, ",") + " FROM " + m.TargetTable
+	if source.written == nil {
+		return c, m.addRows(c, selectSQL)
+	}
+
+	keys := make([]string, 0, len(source.written))
+	for key := range source.written {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > keyBatch {
+			n = keyBatch
+		}
+		var tuples []string
+		var args []interface{}
+		for _, key := range keys[:n] {
+			params := make([]string, len(source.keys))
+			for i, v := range source.written[key] {
+				args = append(args, v)
+				params[i] = fmt.Sprintf("$%d", len(args))
+			}
+			tuples = append(tuples, "("+strings.Join(params, ", ")+")")
+		}
+		query := fmt.Sprintf("%s WHERE (%s) IN (%s)", selectSQL, strings.Join(source.keys, ", "), strings.Join(tuples, ", "))
+		if err := m.addRows(c, query, args...); err != nil {
+			return nil, err
+		}
+		keys = keys[n:]
+	}
+	return c, nil
+}
+
+// addRows adds the rows of the query to the checksum
+func (m *Migrater) addRows(c *checksum, query string, args ...interface{}) error {
+	rows, err := m.target().Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for {
+		record, err := scanRecord(rows, c.columns)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		c.add(record)
+	}
+}
+
+// verify compares the records written to the target against the rows of the target table
+// they were written to
+func (m *Migrater) verify(source *checksum) error {
+	fmt.Fprintln(m.progress(), "Verifying target against source")
+	target, err := m.targetChecksum(source)
+	if err != nil {
+		return err
+	}
+
+	var mismatches []string
+	if source.rows != target.rows {
+		mismatches = append(mismatches, fmt.Sprintf("row count: source %d, target %d", source.rows, target.rows))
+	}
+	for _, col := range source.columns {
+		s, t := source.sums[col], target.sums[col]
+		if s.nulls != t.nulls {
+			mismatches = append(mismatches, fmt.Sprintf("column %s nulls: source %d, target %d", col, s.nulls, t.nulls))
+		}
+		if s.hash != t.hash {
+			mismatches = append(mismatches, fmt.Sprintf("column %s checksum: source %x, target %x", col, s.hash, t.hash))
+		}
+		if s.numeric && t.numeric && s.total.Cmp(t.total) != 0 {
+			mismatches = append(mismatches, fmt.Sprintf("column %s sum: source %s, target %s",
+				col, s.total.FloatString(6), t.total.FloatString(6)))
+		}
+	}
+	if len(mismatches) == 0 && source.rowHash != target.rowHash {
+		mismatches = append(mismatches, fmt.Sprintf("row checksum: source %x, target %x", source.rowHash, target.rowHash))
+	}
+	m.Summary.Verified = len(mismatches) == 0
+	if m.Summary.Verified {
+		fmt.Fprintf(m.progress(), "Verified %d rows\n", target.rows)
+		return nil
+	}
+
+	for _, mismatch := range mismatches {
+		fmt.Fprintln(m.progress(), "Mismatch:", mismatch)
+	}
+	m.reportKeys(source, target)
+	return errors.New("Reconciliation between source and target failed")
+}
+
+// reportKeys lists the keys that are missing, unexpected or different in the target
+func (m *Migrater) reportKeys(source, target *checksum) {
+	if source.detail == nil || target.detail == nil {
+		if len(source.keys) > 0 {
+			fmt.Fprintln(m.progress(), "Too many rows to list the mismatching keys")
+		}
+		return
+	}
+	var report []string
+	for k, h := range source.detail {
+		th, ok := target.detail[k]
+		switch {
+		case !ok:
+			report = append(report, fmt.Sprintf("missing in target: %s", k))
+		case th != h:
+			report = append(report, fmt.Sprintf("different in target: %s", k))
+		}
+	}
+	for k := range target.detail {
+		if _, ok := source.detail[k]; !ok {
+			report = append(report, fmt.Sprintf("unexpected in target: %s", k))
+		}
+	}
+	sort.Strings(report)
+
+	limit := m.VerifyMaxReport
+	if limit <= 0 {
+		limit = 20
+	}
+	for i, line := range report {
+		if i == limit {
+			fmt.Fprintf(m.progress(), "... and %d more keys\n", len(report)-limit)
+			break
+		}
+		fmt.Fprintln(m.progress(), line)
+	}
+}
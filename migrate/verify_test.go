@@ -0,0 +1,159 @@
+package migrate
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestTypedCanonical(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	moment := time.Date(2024, 3, 1, 10, 30, 0, 500000000, time.UTC)
+	tests := []struct {
+		datatype string
+		file     interface{}
+		db       interface{}
+	}{
+		{"integer", " 42", int64(42)},
+		{"bigint", "-7", int64(-7)},
+		{"numeric(10,2)", "1.5", "1.50"},
+		{"numeric", "1e3", "1000"},
+		{"real", "0.1", float32(0.1)},
+		{"double precision", "2.50", 2.5},
+		{"boolean", "t", true},
+		{"boolean", "FALSE", false},
+		{"date", "2024-03-01", day},
+		{"timestamp without time zone", "2024-03-01 10:30:00.5", moment},
+		{"timestamp without time zone", "2024-03-01T10:30:00.5+02:00", moment},
+		{"timestamp with time zone", "2024-03-01T12:30:00.5+02:00", moment},
+		{"text", "x", "x"},
+		// a column without a known type compares the text
+		{"", "1", "1"},
+	}
+	for _, tt := range tests {
+		file, _ := typedCanonical(tt.datatype, tt.file)
+		db, _ := typedCanonical(tt.datatype, tt.db)
+		if file != db {
+			t.Errorf("%s: file %q gives %q, db %#v gives %q", tt.datatype, tt.file, file, tt.db, db)
+		}
+	}
+
+	if _, null := typedCanonical("integer", nil); !null {
+		t.Error("nil is not NULL")
+	}
+	// a value which does not parse keeps its text and still differs
+	a, _ := typedCanonical("integer", "1x")
+	b, _ := typedCanonical("integer", int64(1))
+	if a == b {
+		t.Errorf("1x and 1 both give %q", a)
+	}
+}
+
+func catalogRows(cols ...[2]string) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"attname", "format_type", "attnotnull", "def", "comment", "identity"})
+	for _, c := range cols {
+		rows.AddRow(c[0], c[1], false, "", "", false)
+	}
+	return rows
+}
+
+func TestStartVerify(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    []string
+		held    bool
+		wantErr string
+	}{
+		{name: "empty table"},
+		{name: "table with rows", held: true, wantErr: "Please provide target.verify.keys"},
+		{name: "keys", keys: []string{"id"}, held: true},
+		{name: "unknown key", keys: []string{"idd"}, wantErr: "Verify key idd is not a source column"},
+	}
+	for _, tt := range tests {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		mock.ExpectQuery("FROM pg_attribute").WillReturnRows(catalogRows([2]string{"id", "integer"}))
+		if len(tt.keys) == 0 {
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS (SELECT 1 FROM t)")).
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(tt.held))
+		}
+		m := &Migrater{TargetDB: db, TargetTable: "t", VerifyKeys: tt.keys}
+		sums, err := m.startVerify([]string{"id", "amount"})
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: got %v, want an error containing %q", tt.name, err, tt.wantErr)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+		} else if got := sums.written != nil; got != (len(tt.keys) > 0) {
+			t.Errorf("%s: keeps the written keys %v", tt.name, got)
+		}
+		db.Close()
+	}
+}
+
+func TestVerifyReadsTheWrittenKeys(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	cols, keys := []string{"id", "region", "amount"}, []string{"id", "region"}
+	types := map[string]string{"id": "integer", "region": "text", "amount": "numeric"}
+	source := newChecksum(cols, keys, types)
+	source.keepKeys()
+	for i := 0; i < keyBatch+1; i++ {
+		source.add(map[string]interface{}{"id": strconv.Itoa(i), "region": "eu", "amount": "1.5"})
+	}
+	// a repeated key is read back once
+	source.add(map[string]interface{}{"id": "0", "region": "eu", "amount": "1.5"})
+	if len(source.written) != keyBatch+1 {
+		t.Fatalf("%d written keys, want %d", len(source.written), keyBatch+1)
+	}
+
+	// the rows are read back by key in batches, the other rows of the table are left out
+	first := sqlmock.NewRows(cols)
+	for i := 0; i < keyBatch; i++ {
+		first.AddRow(int64(i), "eu", "1.50")
+	}
+	first.AddRow(int64(0), "eu", "1.50")
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id,region,amount FROM t WHERE (id, region) IN (($1, $2), ($3, $4),")).WillReturnRows(first)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id,region,amount FROM t WHERE (id, region) IN (($1, $2))")).
+		WillReturnRows(sqlmock.NewRows(cols).AddRow(int64(keyBatch), "eu", "2.00"))
+
+	m := &Migrater{TargetDB: db, TargetTable: "t"}
+	if err = m.verify(source); err == nil {
+		t.Error("a different amount is verified")
+	}
+	if m.Summary.Verified {
+		t.Error("Summary.Verified is set")
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVerifyEmptyTable(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	cols := []string{"id", "amount"}
+	source := newChecksum(cols, nil, map[string]string{"id": "integer", "amount": "numeric"})
+	source.add(map[string]interface{}{"id": "1", "amount": "1.5"})
+	source.add(map[string]interface{}{"id": "2", "amount": nil})
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id,amount FROM t")).
+		WillReturnRows(sqlmock.NewRows(cols).AddRow(int64(2), nil).AddRow(int64(1), "1.50"))
+
+	m := &Migrater{TargetDB: db, TargetTable: "t"}
+	if err = m.verify(source); err != nil || !m.Summary.Verified {
+		t.Errorf("got %v, verified %v", err, m.Summary.Verified)
+	}
+}
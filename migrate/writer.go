@@ -0,0 +1,178 @@
+package migrate
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"time"
+
+	"github.com/PrakharSrivastav/migrater/retry"
+	"github.com/clbanning/mxj"
+)
+
+func init() {
+	// escape &, < and friends in the values so the xml target stays well formed
+	mxj.XMLEscapeChars(true)
+}
+
+// recordWriter writes batches of records to the target. Close flushes anything still buffered.
+type recordWriter interface {
+	Write(records []map[string]interface{}) error
+	Close() error
+}
+
+// newWriter returns a recordWriter for the configured target writing the given columns
+func (m *Migrater) newWriter(cols []string) (recordWriter, error) {
+	if m.TargetDB != nil {
+		writer := &dbWriter{m: m, columns: cols}
+		// a job transaction cannot replay a single batch, the whole job fails instead
+		if m.TargetLedger && m.TargetRetry.Retries > 0 && m.targetTx == nil {
+			var err error
+			if writer.ledger, err = newLedger(m.TargetDB, m.TargetTable, m.progress()); err != nil {
+				return nil, err
+			}
+		}
+		return writer, nil
+	}
+	switch m.TargetFileType {
+	case "csv":
+		return &csvWriter{writer: csv.NewWriter(m.TargetFile), columns: cols}, nil
+	case "xml":
+		return &xmlWriter{writer: m.TargetFile}, nil
+	case "sql":
+		return m.newSQLWriter(cols)
+	case "pgcopy":
+		return m.newPGCopyWriter(cols)
+	case "pgbinary":
+		return m.newPGBinaryWriter(cols)
+	case "parquet":
+		return m.newParquetWriter(cols)
+	case "avro":
+		return m.newAvroWriter(cols)
+	case "xlsx":
+		return m.newXLSXWriter(cols)
+	case "fixedwidth":
+		return m.newFixedWidthWriter(cols)
+	case "json":
+		return &jsonWriter{writer: m.TargetFile}, nil
+	}
+	return nil, fmt.Errorf("Unsupported target file type (%s)", m.TargetFileType)
+}
+
+type csvWriter struct {
+	writer      *csv.Writer
+	columns     []string
+	wroteHeader bool
+}
+
+func (c *csvWriter) Write(records []map[string]interface{}) error {
+	if !c.wroteHeader {
+		if err := c.writer.Write(c.columns); err != nil {
+			return err
+		}
+		c.wroteHeader = true
+	}
+	for _, record := range records {
+		csvTemp := make([]string, len(c.columns))
+		for i, col := range c.columns {
+			csvTemp[i] = toString(record[col])
+		}
+		if err := c.writer.Write(csvTemp); err != nil {
+			return err
+		}
+	}
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+func (c *csvWriter) Close() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+type xmlWriter struct {
+	writer *bufio.Writer
+}
+
+func (x *xmlWriter) Write(records []map[string]interface{}) error {
+	for _, value := range records {
+		xmlTemp := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			xmlTemp[k] = toString(v)
+		}
+		// path named columns become nested elements, @ columns attributes
+		if err := mxj.Map(unflatten(xmlTemp)).XmlIndentWriter(x.writer, "", "  ", "Root"); err != nil {
+			return fmt.Errorf("Error writing xml (%s)", err)
+		}
+	}
+	return x.writer.Flush()
+}
+
+func (x *xmlWriter) Close() error { return x.writer.Flush() }
+
+type dbWriter struct {
+	m       *Migrater
+	columns []string
+	// ledger makes the batches safe to retry, nil unless enabled with retries on
+	ledger *ledger
+	batch  int
+}
+
+func (d *dbWriter) Write(records []map[string]interface{}) error {
+	insert := d.m.QB.Inserter.Table(d.m.TargetTable).Columns(d.columns)
+	for _, record := range records {
+		insert.Values(record)
+	}
+	stmt := insert.Build()
+	d.batch++
+	if d.m.targetTx != nil {
+		if _, err := d.m.targetTx.Exec(stmt); err != nil {
+			return fmt.Errorf("Error (%s) in executing", err)
+		}
+		return nil
+	}
+	err := d.retryPolicy().Do(fmt.Sprintf("Batch %d", d.batch), func() error {
+		if d.ledger != nil {
+			return d.ledger.write(d.batch, stmt, len(records))
+		}
+		_, err := d.m.TargetDB.Exec(stmt)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Error (%s) in executing", err)
+	}
+	return nil
+}
+
+// retryPolicy retries a batch after any transient error when the ledger skips a batch already
+// committed, else only after the errors which surely rolled it back
+func (d *dbWriter) retryPolicy() retry.Policy {
+	policy := d.m.TargetRetry
+	if d.ledger == nil {
+		policy.Transient = retry.RolledBack
+	}
+	return policy
+}
+
+func (d *dbWriter) Close() error {
+	if d.ledger != nil {
+		return d.ledger.done()
+	}
+	return nil
+}
+
+// toString formats a source value for the text based targets
+func toString(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case []byte:
+		return string(val)
+	case time.Time:
+		return val.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(val)
+	}
+}
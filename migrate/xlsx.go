@@ -0,0 +1,600 @@
+package migrate
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// xlsxDigits is the precision of an excel number, longer numerics are written as text
+const xlsxDigits = 15
+
+// xlsxDateFormats are the built-in number formats showing a date or a time
+var xlsxDateFormats = map[int]bool{
+	14: true, 15: true, 16: true, 17: true, 18: true, 19: true, 20: true, 21: true, 22: true,
+	27: true, 28: true, 29: true, 30: true, 31: true, 32: true, 33: true, 34: true, 35: true, 36: true,
+	45: true, 46: true, 47: true, 50: true, 51: true, 52: true, 53: true, 54: true, 55: true, 56: true,
+	57: true, 58: true,
+}
+
+// xlsxLiteral matches the quoted text, escaped characters and colors of a custom number format
+var xlsxLiteral = regexp.MustCompile(`"[^"]*"|\\.|\[[^\]]*\]`)
+
+// xlsxSheetChars are the characters excel does not allow in a sheet name
+var xlsxSheetChars = strings.NewReplacer(":", "_", `\`, "_", "/", "_", "?", "_", "*", "_", "[", "_", "]", "_")
+
+// xlsxReader reads the rows of a worksheet as typed records. The whole sheet is read when the
+// reader is opened, the type of a column is the one its cells share.
+type xlsxReader struct {
+	book    *excelize.File
+	sheet   string
+	columns []string
+	rows    [][]interface{}
+	next    int
+	// dates caches whether the number format of a style shows a date
+	dates    map[int]bool
+	date1904 bool
+}
+
+func (m *Migrater) newXLSXReader() (*xlsxReader, error) {
+	book, err := excelize.OpenReader(m.SourceFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading xlsx file (%s)", err)
+	}
+	x := &xlsxReader{book: book, dates: map[int]bool{}}
+	if x.sheet, err = xlsxSheet(book, m.SourceFileSheet); err != nil {
+		book.Close()
+		return nil, err
+	}
+	if props, err := book.GetWorkbookProps(); err == nil && props.Date1904 != nil {
+		x.date1904 = *props.Date1904
+	}
+	if err = x.load(m.SourceFileRange, m.SourceFileHeaderRow); err != nil {
+		book.Close()
+		return nil, err
+	}
+
+	m.fileTypes = map[string]string{}
+	for i, col := range x.columns {
+		m.fileTypes[col] = x.columnType(i)
+	}
+	return x, nil
+}
+
+// xlsxSheet resolves a sheet given by name or 1-based index, the first sheet by default
+func xlsxSheet(book *excelize.File, sheet string) (string, error) {
+	sheets := book.GetSheetList()
+	if len(sheets) == 0 {
+		return "", errors.New("Xlsx file does not contain a sheet")
+	}
+	if sheet == "" {
+		return sheets[0], nil
+	}
+	if n, err := strconv.Atoi(sheet); err == nil {
+		if n < 1 || n > len(sheets) {
+			return "", fmt.Errorf("Xlsx file has %d sheets, there is no sheet %d", len(sheets), n)
+		}
+		return sheets[n-1], nil
+	}
+	for _, name := range sheets {
+		if strings.EqualFold(name, sheet) {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("Xlsx file does not contain the sheet %s", sheet)
+}
+
+// load reads the header and the records of the sheet. Without a range the columns span the
+// header row and the records run to the last row of the sheet. The header row defaults to the
+// first row of the range.
+func (x *xlsxReader) load(cellRange string, headerRow int) error {
+	rows, err := x.book.GetRows(x.sheet, excelize.Options{RawCellValue: true})
+	if err != nil {
+		return fmt.Errorf("Error reading sheet %s (%s)", x.sheet, err)
+	}
+	firstCol, firstRow, lastCol, lastRow := 1, 1, 0, len(rows)
+	if cellRange != "" {
+		cells := strings.SplitN(cellRange, ":", 2)
+		if len(cells) != 2 {
+			return fmt.Errorf("Invalid xlsx range (%s)", cellRange)
+		}
+		if firstCol, firstRow, err = excelize.CellNameToCoordinates(cells[0]); err == nil {
+			lastCol, lastRow, err = excelize.CellNameToCoordinates(cells[1])
+		}
+		if err != nil || lastCol < firstCol || lastRow < firstRow {
+			return fmt.Errorf("Invalid xlsx range (%s)", cellRange)
+		}
+	}
+	if headerRow == 0 {
+		headerRow = firstRow
+	}
+	if headerRow > len(rows) {
+		return fmt.Errorf("Sheet %s has no header row %d", x.sheet, headerRow)
+	}
+	header := rows[headerRow-1]
+	if lastCol == 0 {
+		lastCol = len(header)
+	}
+
+	seen := map[string]bool{}
+	for c := firstCol; c <= lastCol; c++ {
+		name := ""
+		if c <= len(header) {
+			name = strings.TrimSpace(header[c-1])
+		}
+		if name == "" {
+			// a column without a header is named after its letter
+			name, _ = excelize.ColumnNumberToName(c)
+		}
+		if seen[name] {
+			return fmt.Errorf("Column %s appears twice in the header of sheet %s", name, x.sheet)
+		}
+		seen[name] = true
+		x.columns = append(x.columns, name)
+	}
+
+	if lastRow > len(rows) {
+		lastRow = len(rows)
+	}
+	for r := headerRow + 1; r <= lastRow; r++ {
+		if r < firstRow {
+			continue
+		}
+		row := make([]interface{}, len(x.columns))
+		empty := true
+		for i := range x.columns {
+			c := firstCol + i
+			if c > len(rows[r-1]) || rows[r-1][c-1] == "" {
+				continue
+			}
+			cell, _ := excelize.CoordinatesToCellName(c, r)
+			if row[i], err = x.value(cell, rows[r-1][c-1]); err != nil {
+				return fmt.Errorf("Error (%s) reading cell %s", err, cell)
+			}
+			empty = false
+		}
+		// blank rows inside the sheet are skipped
+		if !empty {
+			x.rows = append(x.rows, row)
+		}
+	}
+	return nil
+}
+
+// value converts the raw value of a cell to a bool, an int64, a float64, a time.Time for a
+// number formatted as a date, or a string
+func (x *xlsxReader) value(cell, raw string) (interface{}, error) {
+	typ, err := x.book.GetCellType(x.sheet, cell)
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	case excelize.CellTypeBool:
+		return raw == "1", nil
+	case excelize.CellTypeDate:
+		return time.Parse(time.RFC3339Nano, raw)
+	case excelize.CellTypeUnset, excelize.CellTypeNumber:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return raw, nil
+		}
+		style, err := x.book.GetCellStyle(x.sheet, cell)
+		if err != nil {
+			return nil, err
+		}
+		if x.isDate(style) {
+			return xlsxTime(n, x.date1904), nil
+		}
+		if n == math.Trunc(n) && math.Abs(n) < 1<<53 {
+			return int64(n), nil
+		}
+		return n, nil
+	}
+	return raw, nil
+}
+
+// xlsxTime is the time of an excel date number, to the microsecond
+func xlsxTime(serial float64, date1904 bool) time.Time {
+	epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	if date1904 {
+		epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	} else if serial < 61 {
+		// excel counts a 29 February 1900
+		serial++
+	}
+	days := math.Floor(serial)
+	micros := math.Round((serial - days) * 86400e6)
+	return epoch.AddDate(0, 0, int(days)).Add(time.Duration(micros) * time.Microsecond)
+}
+
+// isDate tells whether the number format of a style shows a date or a time
+func (x *xlsxReader) isDate(style int) bool {
+	if date, ok := x.dates[style]; ok {
+		return date
+	}
+	date := false
+	if s, err := x.book.GetStyle(style); err == nil {
+		date = xlsxDateFormats[s.NumFmt]
+		if s.CustomNumFmt != nil {
+			format := strings.ToLower(xlsxLiteral.ReplaceAllString(*s.CustomNumFmt, ""))
+			date = strings.ContainsAny(format, "ymdhs")
+		}
+	}
+	x.dates[style] = date
+	return date
+}
+
+// columnType is the postgres type shared by the cells of a column. A column mixing types is
+// text, and its values are turned into strings.
+func (x *xlsxReader) columnType(i int) string {
+	datatype := ""
+	for _, row := range x.rows {
+		var t string
+		switch v := row[i].(type) {
+		case nil:
+			continue
+		case bool:
+			t = "boolean"
+		case int64:
+			t = "bigint"
+		case float64:
+			t = "double precision"
+		case time.Time:
+			t = "date"
+			if v.Hour() != 0 || v.Minute() != 0 || v.Second() != 0 || v.Nanosecond() != 0 {
+				t = "timestamp without time zone"
+			}
+		default:
+			t = "text"
+		}
+		datatype = xlsxCommonType(datatype, t)
+	}
+	if datatype == "text" {
+		for _, row := range x.rows {
+			if row[i] != nil {
+				row[i] = xlsxText(row[i])
+			}
+		}
+	}
+	if datatype == "" {
+		return "text"
+	}
+	return datatype
+}
+
+// xlsxCommonType is the type holding the cells of two types, integers widen to double
+// precision and dates to timestamps
+func xlsxCommonType(a, b string) string {
+	if a == "" || a == b {
+		return b
+	}
+	widened := map[string]bool{a: true, b: true}
+	switch {
+	case widened["bigint"] && widened["double precision"]:
+		return "double precision"
+	case widened["date"] && widened["timestamp without time zone"]:
+		return "timestamp without time zone"
+	}
+	return "text"
+}
+
+// xlsxText formats a cell value of a text column
+func xlsxText(v interface{}) string {
+	switch val := v.(type) {
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case time.Time:
+		if val.Hour() == 0 && val.Minute() == 0 && val.Second() == 0 && val.Nanosecond() == 0 {
+			return val.Format("2006-01-02")
+		}
+		return val.Format("2006-01-02 15:04:05.999999999")
+	}
+	return toString(v)
+}
+
+func (x *xlsxReader) Columns() []string { return x.columns }
+
+func (x *xlsxReader) Read() (map[string]interface{}, error) {
+	if x.next == len(x.rows) {
+		return nil, io.EOF
+	}
+	row := x.rows[x.next]
+	x.next++
+	mp := make(map[string]interface{}, len(x.columns))
+	for i, col := range x.columns {
+		mp[col] = row[i]
+	}
+	return mp, nil
+}
+
+func (x *xlsxReader) Close() error { return x.book.Close() }
+
+// xlsxColumn is a column of an xlsx target and the style formatting its cells
+type xlsxColumn struct {
+	name     string
+	datatype string
+	style    int
+}
+
+// xlsxWriter writes the records to a worksheet of a workbook. An existing workbook keeps its
+// other sheets, so the jobs writing the same file each add their sheet. A sheet holding more
+// rows than excel allows continues on "<sheet> (2)" and so on.
+type xlsxWriter struct {
+	book *excelize.File
+	// path is saved when the writer is closed, with no path the workbook is written to writer
+	path   string
+	writer *bufio.Writer
+	fresh  bool
+	// date1904 is set for a workbook counting its dates from 1904
+	date1904 bool
+	sheet    string
+	part     int
+	stream   *excelize.StreamWriter
+	row      int
+	header   int
+	columns  []xlsxColumn
+}
+
+func (m *Migrater) newXLSXWriter(cols []string) (*xlsxWriter, error) {
+	types, err := m.sourceTypeNames(cols)
+	if err != nil {
+		return nil, err
+	}
+	x := &xlsxWriter{path: m.TargetFilePath, writer: m.TargetFile, sheet: xlsxSheetChars.Replace(m.TargetFileSheet)}
+	if x.sheet == "" {
+		x.sheet = "Sheet1"
+	}
+	if stat, err := os.Stat(x.path); x.path != "" && err == nil && stat.Size() > 0 {
+		if x.book, err = excelize.OpenFile(x.path); err != nil {
+			return nil, fmt.Errorf("Error reading xlsx file (%s)", err)
+		}
+		if props, err := x.book.GetWorkbookProps(); err == nil && props.Date1904 != nil {
+			x.date1904 = *props.Date1904
+		}
+		// a sheet written by an earlier run is replaced
+		sheets := x.book.GetSheetList()
+		for _, name := range sheets {
+			if x.ownSheet(name, len(sheets)) {
+				if err = x.book.DeleteSheet(name); err != nil {
+					return nil, err
+				}
+			}
+		}
+	} else {
+		x.book, x.fresh = excelize.NewFile(), true
+	}
+
+	if x.header, err = x.book.NewStyle(&excelize.Style{
+		Font:   &excelize.Font{Bold: true},
+		Fill:   excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"D9E1F2"}},
+		Border: []excelize.Border{{Type: "bottom", Color: "000000", Style: 1}},
+	}); err != nil {
+		return nil, err
+	}
+	for _, col := range cols {
+		c := xlsxColumn{name: col, datatype: types[col]}
+		if c.style, err = x.book.NewStyle(xlsxStyle(c.datatype)); err != nil {
+			return nil, err
+		}
+		x.columns = append(x.columns, c)
+	}
+	if err = x.nextSheet(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// xlsxStyle is the number format of the cells of a postgres type
+func xlsxStyle(datatype string) *excelize.Style {
+	format := ""
+	switch t := binaryType(datatype); t {
+	case "smallint", "integer", "bigint":
+		format = "0"
+	case "date":
+		format = "yyyy-mm-dd"
+	case "timestamp with time zone", "timestamp without time zone":
+		format = "yyyy-mm-dd hh:mm:ss"
+	case "numeric":
+		var precision, scale int
+		if n, _ := fmt.Sscanf(strings.ToLower(datatype), "numeric(%d,%d)", &precision, &scale); n < 1 {
+			return &excelize.Style{}
+		}
+		if precision > xlsxDigits {
+			return &excelize.Style{NumFmt: 49}
+		}
+		format = "0"
+		if scale > 0 {
+			format += "." + strings.Repeat("0", scale)
+		}
+	case "real", "double precision", "boolean":
+		return &excelize.Style{}
+	default:
+		// text keeps values such as zip codes from being read as numbers
+		return &excelize.Style{NumFmt: 49}
+	}
+	return &excelize.Style{CustomNumFmt: &format}
+}
+
+// ownSheet tells whether a sheet of the workbook is one of the parts this writer writes
+func (x *xlsxWriter) ownSheet(name string, sheets int) bool {
+	for part := 1; part <= sheets; part++ {
+		if name == x.sheetName(part) {
+			return true
+		}
+	}
+	return false
+}
+
+// sheetName is the name of a part of the sheet, cut to the 31 characters excel allows
+func (x *xlsxWriter) sheetName(part int) string {
+	suffix := ""
+	if part > 1 {
+		suffix = fmt.Sprintf(" (%d)", part)
+	}
+	name := x.sheet
+	for utf8.RuneCountInString(name)+len(suffix) > excelize.MaxSheetNameLength {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return strings.TrimRight(name, " ") + suffix
+}
+
+// nextSheet flushes the current sheet and starts the next part with the header row
+func (x *xlsxWriter) nextSheet() error {
+	if x.stream != nil {
+		if err := x.stream.Flush(); err != nil {
+			return err
+		}
+	}
+	x.part++
+	name := x.sheetName(x.part)
+	if _, err := x.book.NewSheet(name); err != nil {
+		return fmt.Errorf("Error creating sheet %s (%s)", name, err)
+	}
+	var err error
+	if x.stream, err = x.book.NewStreamWriter(name); err != nil {
+		return err
+	}
+	header := make([]interface{}, len(x.columns))
+	for i, col := range x.columns {
+		width := float64(utf8.RuneCountInString(col.name) + 4)
+		width = math.Max(12, math.Min(width, 50))
+		if err = x.stream.SetColWidth(i+1, i+1, width); err != nil {
+			return err
+		}
+		header[i] = excelize.Cell{StyleID: x.header, Value: col.name}
+	}
+	if err = x.stream.SetPanes(&excelize.Panes{Freeze: true, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft"}); err != nil {
+		return err
+	}
+	x.row = 1
+	return x.stream.SetRow("A1", header)
+}
+
+func (x *xlsxWriter) Write(records []map[string]interface{}) error {
+	for _, record := range records {
+		if x.row == excelize.TotalRows {
+			if err := x.nextSheet(); err != nil {
+				return err
+			}
+		}
+		x.row++
+		cells := make([]interface{}, len(x.columns))
+		for i, col := range x.columns {
+			cells[i] = excelize.Cell{StyleID: col.style, Value: x.value(col.datatype, record[col.name])}
+		}
+		cell, _ := excelize.CoordinatesToCellName(1, x.row)
+		if err := x.stream.SetRow(cell, cells); err != nil {
+			return fmt.Errorf("Error writing xlsx row %d (%s)", x.row, err)
+		}
+	}
+	return nil
+}
+
+// value converts a value to the cell value of the column type. A value which does not parse as
+// the type is written as it is.
+func (x *xlsxWriter) value(datatype string, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	switch t := binaryType(datatype); t {
+	case "smallint", "integer", "bigint":
+		if n, err := binaryInt(v); err == nil {
+			return n
+		}
+		if n, err := strconv.ParseInt(strings.TrimSpace(toString(v)), 10, 64); err == nil {
+			return n
+		}
+	case "real", "double precision":
+		if f, err := binaryFloat(v); err == nil {
+			return f
+		}
+	case "numeric":
+		// excel keeps 15 digits, a longer number stays exact as text
+		s := strings.TrimSpace(toString(v))
+		var precision int
+		fmt.Sscanf(strings.ToLower(datatype), "numeric(%d", &precision)
+		if digits := strings.TrimLeft(strings.NewReplacer("-", "", ".", "").Replace(s), "0"); precision > xlsxDigits || len(digits) > xlsxDigits {
+			return s
+		}
+		if f, err := binaryFloat(v); err == nil {
+			return f
+		}
+	case "boolean":
+		if b, ok := v.(bool); ok {
+			return b
+		}
+		if b, err := strconv.ParseBool(strings.TrimSpace(toString(v))); err == nil {
+			return b
+		}
+	case "date", "timestamp without time zone":
+		if tm, err := binaryTime(v); err == nil {
+			return x.serial(tm)
+		}
+	case "timestamp with time zone":
+		// excel has no time zones, the time is written in UTC
+		if tm, err := binaryTime(v); err == nil {
+			return x.serial(tm.UTC())
+		}
+	}
+	if b, ok := v.([]byte); ok {
+		return string(b)
+	}
+	return v
+}
+
+// serial is the excel date number of the wall clock time of tm, the inverse of xlsxTime. Excel
+// has no dates before 1900, they are written as text.
+func (x *xlsxWriter) serial(tm time.Time) interface{} {
+	wall := time.Date(tm.Year(), tm.Month(), tm.Day(), tm.Hour(), tm.Minute(), tm.Second(), tm.Nanosecond(), time.UTC)
+	epoch, first := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC), time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
+	if x.date1904 {
+		epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+		first = epoch
+	}
+	if wall.Before(first) {
+		return xlsxText(wall)
+	}
+	seconds := wall.Unix() - epoch.Unix()
+	serial := float64(seconds)/86400 + float64(wall.Nanosecond())/86400e9
+	if !x.date1904 && wall.Before(time.Date(1900, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		serial--
+	}
+	return serial
+}
+
+func (x *xlsxWriter) Close() error {
+	if err := x.stream.Flush(); err != nil {
+		return err
+	}
+	// a new workbook comes with an empty Sheet1
+	if x.fresh && x.sheetName(1) != "Sheet1" {
+		if err := x.book.DeleteSheet("Sheet1"); err != nil {
+			return err
+		}
+	}
+	if index, err := x.book.GetSheetIndex(x.sheetName(1)); err == nil && index >= 0 {
+		x.book.SetActiveSheet(index)
+	}
+	defer x.book.Close()
+	if x.path == "" {
+		if err := x.book.Write(x.writer); err != nil {
+			return err
+		}
+		return x.writer.Flush()
+	}
+	if err := x.book.SaveAs(x.path); err != nil {
+		return fmt.Errorf("Error saving xlsx file (%s)", err)
+	}
+	return nil
+}
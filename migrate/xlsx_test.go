@@ -0,0 +1,254 @@
+package migrate
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func TestXLSXSerial(t *testing.T) {
+	tests := []struct {
+		tm       time.Time
+		date1904 bool
+		want     interface{}
+	}{
+		{time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC), false, 1.0},
+		{time.Date(1900, 2, 28, 0, 0, 0, 0, time.UTC), false, 59.0},
+		// excel counts a 29 February 1900, the days after it are one higher
+		{time.Date(1900, 3, 1, 0, 0, 0, 0, time.UTC), false, 61.0},
+		{time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), false, 45352.5},
+		{time.Date(1904, 1, 2, 6, 0, 0, 0, time.UTC), true, 1.25},
+		{time.Date(1899, 12, 31, 0, 0, 0, 0, time.UTC), false, "1899-12-31"},
+		{time.Date(1903, 12, 31, 0, 0, 0, 0, time.UTC), true, "1903-12-31"},
+	}
+	for _, tt := range tests {
+		x := &xlsxWriter{date1904: tt.date1904}
+		got := x.serial(tt.tm)
+		if got != tt.want {
+			t.Errorf("serial(%s, %v) = %v, want %v", tt.tm, tt.date1904, got, tt.want)
+			continue
+		}
+		if serial, ok := got.(float64); ok && !xlsxTime(serial, tt.date1904).Equal(tt.tm) {
+			t.Errorf("xlsxTime(%v, %v) = %s, want %s", serial, tt.date1904, xlsxTime(serial, tt.date1904), tt.tm)
+		}
+	}
+}
+
+func TestXLSXSheetName(t *testing.T) {
+	tests := []struct {
+		sheet string
+		part  int
+		want  string
+	}{
+		{"orders", 1, "orders"},
+		{"orders", 2, "orders (2)"},
+		{strings.Repeat("a", 40), 1, strings.Repeat("a", 31)},
+		{strings.Repeat("a", 40), 12, strings.Repeat("a", 26) + " (12)"},
+		{strings.Repeat("é", 30) + " x", 3, strings.Repeat("é", 27) + " (3)"},
+	}
+	for _, tt := range tests {
+		x := &xlsxWriter{sheet: tt.sheet}
+		if got := x.sheetName(tt.part); got != tt.want {
+			t.Errorf("sheetName(%q, %d) = %q, want %q", tt.sheet, tt.part, got, tt.want)
+		}
+	}
+}
+
+func TestXLSXCommonType(t *testing.T) {
+	tests := []struct{ a, b, want string }{
+		{"", "bigint", "bigint"},
+		{"bigint", "bigint", "bigint"},
+		{"bigint", "double precision", "double precision"},
+		{"date", "timestamp without time zone", "timestamp without time zone"},
+		{"bigint", "date", "text"},
+		{"boolean", "text", "text"},
+	}
+	for _, tt := range tests {
+		if got := xlsxCommonType(tt.a, tt.b); got != tt.want {
+			t.Errorf("xlsxCommonType(%s, %s) = %s, want %s", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestXLSXRoundTrip(t *testing.T) {
+	cols := []string{"zip", "id", "amount", "big", "ok", "day", "at", "mixed"}
+	var out bytes.Buffer
+	m := &Migrater{TargetFile: bufio.NewWriter(&out), TargetFileSheet: "orders/2024", fileTypes: map[string]string{
+		"id": "bigint", "amount": "numeric(10,2)", "big": "numeric(30,2)", "ok": "boolean",
+		"day": "date", "at": "timestamp with time zone",
+	}}
+	w, err := m.newXLSXWriter(cols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := []map[string]interface{}{
+		{"zip": "00123", "id": "1", "amount": "1.5", "big": "1234567890123456789.01", "ok": "true",
+			"day": "2024-02-29", "at": "2024-03-01 12:00:00+02", "mixed": "x"},
+		{"zip": nil, "id": int64(-2), "amount": "bad", "big": nil, "ok": false, "day": nil, "at": nil, "mixed": nil},
+	}
+	if err = w.Write(records); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	source := &Migrater{SourceFile: bufio.NewReader(&out), SourceFileSheet: "orders_2024"}
+	r, err := source.newXLSXReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if !reflect.DeepEqual(r.Columns(), cols) {
+		t.Errorf("columns %v, want %v", r.Columns(), cols)
+	}
+	wantTypes := map[string]string{
+		"zip": "text", "id": "bigint", "amount": "text", "big": "text", "ok": "boolean",
+		"day": "date", "at": "timestamp without time zone", "mixed": "text",
+	}
+	if !reflect.DeepEqual(source.fileTypes, wantTypes) {
+		t.Errorf("types %v, want %v", source.fileTypes, wantTypes)
+	}
+	want := []map[string]interface{}{
+		{"zip": "00123", "id": int64(1), "amount": "1.5", "big": "1234567890123456789.01", "ok": true,
+			"day": time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), "at": time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC), "mixed": "x"},
+		{"zip": nil, "id": int64(-2), "amount": "bad", "big": nil, "ok": false, "day": nil, "at": nil, "mixed": nil},
+	}
+	for i, w := range want {
+		got, err := r.Read()
+		if err != nil || !reflect.DeepEqual(got, w) {
+			t.Errorf("record %d: %#v %v, want %#v", i, got, err, w)
+		}
+	}
+	if _, err = r.Read(); err != io.EOF {
+		t.Errorf("got %v after the last record, want io.EOF", err)
+	}
+}
+
+// mustOpen opens a file closed with the test
+func mustOpen(t *testing.T, path string) *os.File {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestXLSXReaderOptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "book.xlsx")
+	book := excelize.NewFile()
+	book.NewSheet("data")
+	rows := [][]interface{}{
+		{"report"},
+		{},
+		{"id", "", "name"},
+		{1, 2, "a"},
+		{},
+		{3, 4, "b"},
+		{5, 6, "c"},
+	}
+	for i, row := range rows {
+		cell, _ := excelize.CoordinatesToCellName(1, i+1)
+		book.SetSheetRow("data", cell, &row)
+	}
+	if err := book.SaveAs(path); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		sheet     string
+		cellRange string
+		headerRow int
+		cols      []string
+		ids       []interface{}
+		wantErr   string
+	}{
+		{name: "header row", sheet: "2", headerRow: 3, cols: []string{"id", "B", "name"},
+			ids: []interface{}{int64(1), int64(3), int64(5)}},
+		{name: "range", sheet: "DATA", cellRange: "A3:C6", cols: []string{"id", "B", "name"},
+			ids: []interface{}{int64(1), int64(3)}},
+		{name: "range and header row", sheet: "data", cellRange: "A4:A7", headerRow: 3, cols: []string{"id"},
+			ids: []interface{}{int64(1), int64(3), int64(5)}},
+		{name: "sheet index", sheet: "3", wantErr: "there is no sheet 3"},
+		{name: "sheet name", sheet: "other", wantErr: "does not contain the sheet other"},
+		{name: "bad range", sheet: "data", cellRange: "C3:A1", wantErr: "Invalid xlsx range"},
+		{name: "header past the end", sheet: "data", headerRow: 9, wantErr: "no header row 9"},
+	}
+	for _, tt := range tests {
+		m := &Migrater{SourceFile: bufio.NewReader(mustOpen(t, path)), SourceFileSheet: tt.sheet,
+			SourceFileRange: tt.cellRange, SourceFileHeaderRow: tt.headerRow}
+		r, err := m.newXLSXReader()
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: got %v, want an error containing %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(r.Columns(), tt.cols) {
+			t.Errorf("%s: columns %v, want %v", tt.name, r.Columns(), tt.cols)
+		}
+		var ids []interface{}
+		for {
+			record, err := r.Read()
+			if err != nil {
+				break
+			}
+			ids = append(ids, record["id"])
+		}
+		if !reflect.DeepEqual(ids, tt.ids) {
+			t.Errorf("%s: ids %v, want %v", tt.name, ids, tt.ids)
+		}
+		r.Close()
+	}
+}
+
+func TestXLSXWriterReplacesItsSheet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "book.xlsx")
+	write := func(sheet string, rows int) {
+		m := &Migrater{TargetFilePath: path, TargetFileSheet: sheet}
+		w, err := m.newXLSXWriter([]string{"id"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < rows; i++ {
+			if err = w.Write([]map[string]interface{}{{"id": i}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("customers", 3)
+	write("orders", 2)
+	// a second run of the orders job replaces its sheet and keeps the customers
+	write("orders", 1)
+
+	book, err := excelize.OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer book.Close()
+	if sheets := book.GetSheetList(); !reflect.DeepEqual(sheets, []string{"customers", "orders"}) {
+		t.Errorf("sheets %v, want [customers orders]", sheets)
+	}
+	for sheet, want := range map[string]int{"customers": 4, "orders": 2} {
+		if rows, _ := book.GetRows(sheet); len(rows) != want {
+			t.Errorf("%s has %d rows, want %d", sheet, len(rows), want)
+		}
+	}
+}
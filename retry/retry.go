@@ -0,0 +1,86 @@
+// Package retry runs database operations again after transient failures with exponential backoff
+package retry
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// maxBackoff caps the wait between two attempts
+const maxBackoff = 30 * time.Second
+
+// Policy is the number of times a failed operation is retried and the wait before the first
+// retry, the wait doubles after every attempt
+type Policy struct {
+	Retries int
+	Backoff time.Duration
+	// Transient tells which errors are retried, Retryable when nil
+	Transient func(error) bool
+}
+
+// Do runs fn until it succeeds, fails with an error which is not transient or runs out of retries
+func (p Policy) Do(what string, fn func() error) error {
+	wait := p.Backoff
+	if wait <= 0 {
+		wait = time.Second
+	}
+	transient := p.Transient
+	if transient == nil {
+		transient = Retryable
+	}
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt > p.Retries || !transient(err) {
+			return err
+		}
+		// up to a quarter of jitter so parallel jobs do not retry in lockstep
+		sleep := wait + time.Duration(rand.Int63n(int64(wait)/4+1))
+		fmt.Printf("%s failed (%s), retry %d of %d in %s\n", what, err, attempt, p.Retries, sleep.Round(time.Millisecond))
+		time.Sleep(sleep)
+		if wait *= 2; wait > maxBackoff {
+			wait = maxBackoff
+		}
+	}
+}
+
+// retryableCodes are the postgres errors worth another attempt: serialization failures,
+// deadlocks, a server shutting down or starting up and too many connections
+var retryableCodes = map[pq.ErrorCode]bool{
+	"40001": true,
+	"40P01": true,
+	"57P01": true,
+	"57P02": true,
+	"57P03": true,
+	"53300": true,
+}
+
+// Retryable tells if err is transient, a dropped or refused connection or one of the
+// retryable postgres errors
+func Retryable(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// class 08 is a connection exception
+		return retryableCodes[pqErr.Code] || pqErr.Code.Class() == "08"
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// RolledBack tells if err surely rolled the statement back, a serialization failure or a
+// deadlock. After a dropped connection the statement may have been committed or not.
+func RolledBack(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && (pqErr.Code == "40001" || pqErr.Code == "40P01")
+}
@@ -0,0 +1,92 @@
+package retry
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"serialization failure", &pq.Error{Code: "40001"}, true},
+		{"deadlock", &pq.Error{Code: "40P01"}, true},
+		{"admin shutdown", &pq.Error{Code: "57P01"}, true},
+		{"too many connections", &pq.Error{Code: "53300"}, true},
+		{"connection exception", &pq.Error{Code: "08006"}, true},
+		{"unique violation", &pq.Error{Code: "23505"}, false},
+		{"syntax error", &pq.Error{Code: "42601"}, false},
+		{"wrapped", fmt.Errorf("Batch 3: %w", &pq.Error{Code: "40001"}), true},
+		{"bad conn", driver.ErrBadConn, true},
+		{"eof", io.ErrUnexpectedEOF, true},
+		{"reset", &net.OpError{Op: "read", Err: syscall.ECONNRESET}, true},
+		{"refused", syscall.ECONNREFUSED, true},
+		{"other", errors.New("value too long"), false},
+	}
+	for _, tt := range tests {
+		if got := Retryable(tt.err); got != tt.want {
+			t.Errorf("%s: Retryable = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRolledBack(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"serialization failure", &pq.Error{Code: "40001"}, true},
+		{"deadlock", fmt.Errorf("Batch 3: %w", &pq.Error{Code: "40P01"}), true},
+		// the commit may have been sent before the connection dropped
+		{"connection exception", &pq.Error{Code: "08006"}, false},
+		{"bad conn", driver.ErrBadConn, false},
+		{"eof", io.EOF, false},
+		{"reset", &net.OpError{Op: "read", Err: syscall.ECONNRESET}, false},
+	}
+	for _, tt := range tests {
+		if got := RolledBack(tt.err); got != tt.want {
+			t.Errorf("%s: RolledBack = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDo(t *testing.T) {
+	transient := &pq.Error{Code: "40P01"}
+	tests := []struct {
+		name      string
+		retries   int
+		transient func(error) bool
+		errs      []error
+		attempts  int
+		err       error
+	}{
+		{name: "succeeds", retries: 3, errs: []error{nil}, attempts: 1},
+		{name: "recovers", retries: 3, errs: []error{transient, transient, nil}, attempts: 3},
+		{name: "runs out", retries: 2, errs: []error{transient, transient, transient, nil}, attempts: 3, err: transient},
+		{name: "permanent", retries: 3, errs: []error{io.ErrClosedPipe, nil}, attempts: 1, err: io.ErrClosedPipe},
+		{name: "off", retries: 0, errs: []error{transient, nil}, attempts: 1, err: transient},
+		{name: "rolled back", retries: 3, transient: RolledBack, errs: []error{transient, nil}, attempts: 2},
+		{name: "not rolled back", retries: 3, transient: RolledBack, errs: []error{driver.ErrBadConn, nil}, attempts: 1, err: driver.ErrBadConn},
+	}
+	for _, tt := range tests {
+		attempts := 0
+		err := Policy{Retries: tt.retries, Backoff: time.Millisecond, Transient: tt.transient}.Do(tt.name, func() error {
+			attempts++
+			return tt.errs[attempts-1]
+		})
+		if attempts != tt.attempts || err != tt.err {
+			t.Errorf("%s: %d attempts, error %v, want %d and %v", tt.name, attempts, err, tt.attempts, tt.err)
+		}
+	}
+}